@@ -25,7 +25,7 @@ if (!(Test-Path $releaseDir)) {
     New-Item -ItemType Directory -Path $buildDir -Force | Out-Null
 
     # Goアプリケーションをビルド
-    go build -o $outputFile -ldflags "-s -w" main.go
+    go build -o $outputFile -ldflags "-s -w" .
 
     # インストーラースクリプトをコピー
     Copy-Item "installer.ps1" "$buildDir/"
@@ -61,7 +61,7 @@ if (!(Test-Path $releaseDir)) {
     New-Item -ItemType Directory -Path $buildDir -Force | Out-Null
 
     # Goアプリケーションをビルド
-    go build -o $outputFile -ldflags "-s -w" main.go
+    go build -o $outputFile -ldflags "-s -w" .
 
     # ZIP化
     $zipPath = "$releaseDir/$appname" + "_$version" + "_$target.zip"
@@ -94,7 +94,7 @@ if (!(Test-Path $releaseDir)) {
     New-Item -ItemType Directory -Path $buildDir -Force | Out-Null
 
     # Goアプリケーションをビルド
-    go build -o $outputFile -ldflags "-s -w" main.go
+    go build -o $outputFile -ldflags "-s -w" .
 
     # ZIP化
     $zipPath = "$releaseDir/$appname" + "_$version" + "_$target.zip"
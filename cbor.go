@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+var cborDecoder = &decoder{
+	name:   "cbor",
+	label:  "CBOR",
+	decode: decodeCBOR,
+	encode: encodeCBOR,
+	binary: true,
+}
+
+// CBOR major types (RFC 8949)
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+const cborIndefinite = 31 // Additional information of indefinite lengths and break
+
+// decodeCBOR formats a CBOR payload as a JSON-like tree. Byte strings, tags and values
+// without a JSON form are shown in CBOR diagnostic notation, like h'0102' or 1(1700000000).
+func decodeCBOR(payload []byte, colored bool) (string, error) {
+	r := &byteReader{data: payload}
+	value, err := readCBOR(r, 0)
+	if err != nil {
+		return "", err
+	}
+	if value == cborBreak {
+		return "", r.errorf("unexpected break")
+	}
+	if r.pos < len(payload) {
+		return "", r.errorf("unexpected data after the value")
+	}
+	return formatTree(value, colored), nil
+}
+
+// cborBreak is returned by readCBOR for the break code ending indefinite lengths
+var cborBreak = jsonLiteral("break")
+
+// readCBOR reads one data item
+func readCBOR(r *byteReader, depth int) (interface{}, error) {
+	if depth > maxDecodeDepth {
+		return nil, r.errorf("nested deeper than %d levels", maxDecodeDepth)
+	}
+	start := r.pos
+	initial, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	major := initial >> 5
+	info := initial & 0x1F
+
+	// Arguments follow in 1, 2, 4 or 8 bytes
+	var argument uint64
+	switch {
+	case info < 24:
+		argument = uint64(info)
+	case info <= 27:
+		if argument, err = r.uint(1 << (info - 24)); err != nil {
+			return nil, err
+		}
+	case info == cborIndefinite && (major >= cborBytes && major <= cborMap || major == cborSimple):
+	default:
+		r.pos = start
+		return nil, r.errorf("invalid additional information %d of major type %d", info, major)
+	}
+	indefinite := info == cborIndefinite
+
+	switch major {
+	case cborUint:
+		return json.Number(strconv.FormatUint(argument, 10)), nil
+	case cborNegint:
+		return cborNegative(argument), nil
+	case cborBytes, cborText:
+		data, err := readCBORString(r, major, argument, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborBytes {
+			return data, nil
+		}
+		return string(data), nil
+	}
+	if (major == cborArray || major == cborMap) && !indefinite && argument > uint64(len(r.data)-r.pos) {
+		return nil, r.errorf("length %d exceeds the payload", argument)
+	}
+	switch major {
+	case cborArray:
+		array := []interface{}{}
+		for i := uint64(0); indefinite || i < argument; i++ {
+			value, err := readCBOR(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if value == cborBreak {
+				if !indefinite {
+					return nil, r.errorf("unexpected break")
+				}
+				break
+			}
+			array = append(array, value)
+		}
+		return array, nil
+	case cborMap:
+		object := jsonObject{}
+		for i := uint64(0); indefinite || i < argument; i++ {
+			key, err := readCBOR(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if key == cborBreak {
+				if !indefinite {
+					return nil, r.errorf("unexpected break")
+				}
+				break
+			}
+			value, err := readCBOR(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if value == cborBreak {
+				return nil, r.errorf("unexpected break")
+			}
+			object = append(object, jsonMember{keyText(key), value})
+		}
+		return object, nil
+	case cborTag:
+		value, err := readCBOR(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if value == cborBreak {
+			return nil, r.errorf("unexpected break")
+		}
+		// Bignums are shown as numbers
+		if data, ok := value.([]byte); ok && (argument == 2 || argument == 3) {
+			n := new(big.Int).SetBytes(data)
+			if argument == 3 {
+				n.Neg(n).Sub(n, big.NewInt(1))
+			}
+			return json.Number(n.String()), nil
+		}
+		return jsonTagged{strconv.FormatUint(argument, 10), value}, nil
+	}
+
+	// Major type 7: simple values and floats
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22:
+		return nil, nil
+	case 23:
+		return jsonLiteral("undefined"), nil
+	case 25:
+		return floatValue(halfFloat(uint16(argument)), 32), nil
+	case 26:
+		return floatValue(float64(math.Float32frombits(uint32(argument))), 32), nil
+	case 27:
+		return floatValue(math.Float64frombits(argument), 64), nil
+	case cborIndefinite:
+		return cborBreak, nil
+	}
+	return jsonLiteral("simple(" + strconv.FormatUint(argument, 10) + ")"), nil
+}
+
+// readCBORString reads the data of a byte or text string, joining the chunks of an indefinite length
+func readCBORString(r *byteReader, major byte, length uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		if length > uint64(len(r.data)-r.pos) {
+			return nil, r.errorf("length %d exceeds the payload", length)
+		}
+		return r.next(int(length))
+	}
+	var data []byte
+	for {
+		start := r.pos
+		initial, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if initial == 0xFF {
+			return data, nil
+		}
+		if initial>>5 != major || initial&0x1F == cborIndefinite {
+			r.pos = start
+			return nil, r.errorf("invalid chunk in indefinite length string")
+		}
+		r.pos = start
+		chunk, err := readCBOR(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		switch c := chunk.(type) {
+		case string:
+			data = append(data, c...)
+		case []byte:
+			data = append(data, c...)
+		}
+	}
+}
+
+// cborNegative returns the value -1-n of a negative integer
+func cborNegative(n uint64) json.Number {
+	if n < math.MaxInt64 {
+		return json.Number(strconv.FormatInt(-1-int64(n), 10))
+	}
+	value := new(big.Int).SetUint64(n)
+	return json.Number(value.Neg(value).Sub(value, big.NewInt(1)).String())
+}
+
+// halfFloat converts an IEEE 754 half-precision float
+func halfFloat(bits uint16) float64 {
+	exponent := int(bits>>10) & 0x1F
+	mantissa := float64(bits & 0x3FF)
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(mantissa, -24)
+	case 0x1F:
+		if mantissa == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mantissa+1024, exponent-25)
+	}
+	if bits&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
+
+// encodeCBOR encodes a value typed in json input mode as CBOR, with definite lengths
+// and integers in their shortest form
+func encodeCBOR(value interface{}) ([]byte, error) {
+	var out []byte
+	var encode func(value interface{}) error
+	encode = func(value interface{}) error {
+		switch v := value.(type) {
+		case nil:
+			out = append(out, 0xF6)
+		case bool:
+			if v {
+				out = append(out, 0xF5)
+			} else {
+				out = append(out, 0xF4)
+			}
+		case json.Number:
+			number, err := jsonNumberValue(v)
+			if err != nil {
+				return err
+			}
+			switch n := number.(type) {
+			case int64:
+				if n < 0 {
+					out = appendCBORHead(out, cborNegint, uint64(-1-n))
+				} else {
+					out = appendCBORHead(out, cborUint, uint64(n))
+				}
+			case uint64:
+				out = appendCBORHead(out, cborUint, n)
+			case float64:
+				out = binary.BigEndian.AppendUint64(append(out, 0xFB), math.Float64bits(n))
+			}
+		case string:
+			out = appendCBORHead(out, cborText, uint64(len(v)))
+			out = append(out, v...)
+		case []interface{}:
+			out = appendCBORHead(out, cborArray, uint64(len(v)))
+			for _, element := range v {
+				if err := encode(element); err != nil {
+					return err
+				}
+			}
+		case jsonObject:
+			out = appendCBORHead(out, cborMap, uint64(len(v)))
+			for _, member := range v {
+				if err := encode(member.key); err != nil {
+					return err
+				}
+				if err := encode(member.value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := encode(value); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// appendCBORHead appends the major type with its argument in the shortest form
+func appendCBORHead(out []byte, major byte, argument uint64) []byte {
+	head := major << 5
+	switch {
+	case argument < 24:
+		return append(out, head|byte(argument))
+	case argument <= math.MaxUint8:
+		return append(out, head|24, byte(argument))
+	case argument <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(out, head|25), uint16(argument))
+	case argument <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(out, head|26), uint32(argument))
+	}
+	return binary.BigEndian.AppendUint64(append(out, head|27), argument)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestCBOR(t *testing.T) {
+	value, err := parseJSON([]byte(`{"a":1,"b":[true,null,-2,1.5,"x"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeCBOR(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(data), "a2616101616285f5f621fb3ff80000000000006178"; got != want {
+		t.Errorf("encodeCBOR() = %s, want %s", got, want)
+	}
+	tree, err := decodeCBOR(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := formatTree(value, false); tree != want {
+		t.Errorf("decodeCBOR() = %s, want %s", tree, want)
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+		err  bool
+	}{
+		{"tag", "c11a6553f100", "1(1700000000)", false},
+		{"indefinite array", "9f0102ff", "[\n  1,\n  2\n]", false},
+		{"byte string", "420102", "h'0102'", false},
+		{"half float", "f93e00", "1.5", false},
+		{"missing element", "8201", "", true},
+		{"data after the value", "0101", "", true},
+		{"unexpected break", "ff", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			got, err := decodeCBOR(data, false)
+			if (err != nil) != tt.err || got != tt.want {
+				t.Errorf("decodeCBOR() = %q, %v, want %q (error %t)", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+)
+
+// Byte orders of an appended checksum
+const (
+	checksumLE       = "le"        // Binary, low byte first
+	checksumBE       = "be"        // Binary, high byte first
+	checksumASCIIHex = "ascii-hex" // Uppercase hex digits, high byte first
+)
+
+// checksumAlgo is a checksum or CRC algorithm for --checksum
+type checksumAlgo struct {
+	size      int    // Size in bytes
+	order     string // Default byte order
+	calculate func(data []byte) uint32
+}
+
+// Supported algorithms for --checksum
+var checksumAlgos = map[string]checksumAlgo{
+	"lrc":          {1, checksumBE, lrc},
+	"xor":          {1, checksumBE, xorChecksum},
+	"crc8":         {1, checksumBE, func(data []byte) uint32 { return uint32(crc8(data)) }},
+	"crc16-modbus": {2, checksumLE, func(data []byte) uint32 { return uint32(crc16Modbus(data)) }},
+	"crc16-ccitt":  {2, checksumBE, func(data []byte) uint32 { return uint32(crc16CCITT(data)) }},
+	"crc32":        {4, checksumLE, crc32.ChecksumIEEE},
+}
+
+// Alternative names of the supported algorithms
+var checksumAliases = map[string]string{
+	"crc16":  "crc16-modbus",
+	"modbus": "crc16-modbus",
+	"ccitt":  "crc16-ccitt",
+	"crc-8":  "crc8",
+	"crc-32": "crc32",
+}
+
+// checksumSpec is the algorithm and byte order selected with --checksum
+type checksumSpec struct {
+	name  string
+	algo  checksumAlgo
+	order string
+}
+
+var checksum *checksumSpec // nil when --checksum is not given
+
+// parseChecksum parses the value of --checksum like "crc16-modbus" or "crc32:be"
+func parseChecksum(value string) (*checksumSpec, error) {
+	name, order, _ := strings.Cut(strings.ToLower(value), ":")
+	if alias, ok := checksumAliases[name]; ok {
+		name = alias
+	}
+	algo, ok := checksumAlgos[name]
+	if !ok {
+		names := make([]string, 0, len(checksumAlgos))
+		for n := range checksumAlgos {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("checksum must be one of: %s", strings.Join(names, ", "))
+	}
+	switch order {
+	case "":
+		order = algo.order
+	case checksumLE, checksumBE, checksumASCIIHex:
+	default:
+		return nil, fmt.Errorf("checksum byte order must be 'le', 'be' or 'ascii-hex'")
+	}
+	return &checksumSpec{name: name, algo: algo, order: order}, nil
+}
+
+// String returns the algorithm and byte order, like "crc16-modbus:le"
+func (c *checksumSpec) String() string {
+	return c.name + ":" + c.order
+}
+
+// length returns the number of bytes the checksum takes in a frame
+func (c *checksumSpec) length() int {
+	if c.order == checksumASCIIHex {
+		return c.algo.size * 2
+	}
+	return c.algo.size
+}
+
+// encode returns the checksum of data in the selected byte order
+func (c *checksumSpec) encode(data []byte) []byte {
+	value := c.algo.calculate(data)
+	result := make([]byte, c.algo.size)
+	for i := range result {
+		shift := 8 * uint(i)
+		if c.order == checksumLE {
+			result[i] = byte(value >> shift)
+		} else {
+			result[c.algo.size-1-i] = byte(value >> shift)
+		}
+	}
+	if c.order == checksumASCIIHex {
+		return []byte(strings.ToUpper(hex.EncodeToString(result)))
+	}
+	return result
+}
+
+// appendTo appends the checksum of a message before it is terminated
+func (c *checksumSpec) appendTo(message string) string {
+	if c == nil {
+		return message
+	}
+	return message + string(c.encode([]byte(message)))
+}
+
+// strip removes the checksum from the end of a received message, if it fits
+func (c *checksumSpec) strip(message string) string {
+	if c == nil || len(message) < c.length() {
+		return message
+	}
+	return message[:len(message)-c.length()]
+}
+
+// checksumResult is the outcome of verifying a received message
+type checksumResult struct {
+	ok       bool
+	expected string // Checksum calculated over the message
+	actual   string // Checksum at the end of the message
+}
+
+// verify checks the checksum at the end of a received message (without terminator).
+// It returns nil when --checksum is not given.
+func (c *checksumSpec) verify(message []byte) *checksumResult {
+	if c == nil {
+		return nil
+	}
+	n := c.length()
+	if len(message) < n {
+		return &checksumResult{expected: fmt.Sprintf("%d bytes", n), actual: fmt.Sprintf("%d bytes", len(message))}
+	}
+	body, actual := message[:len(message)-n], message[len(message)-n:]
+	expected := c.encode(body)
+	result := &checksumResult{ok: string(expected) == string(actual)}
+	if c.order == checksumASCIIHex {
+		result.expected, result.actual = string(expected), visibleText(string(actual))
+	} else {
+		result.expected, result.actual = fmt.Sprintf("%X", expected), fmt.Sprintf("%X", actual)
+	}
+	return result
+}
+
+// label formats the result for a Received line, like "[CRC OK]" or "[CRC BAD expected=1234 actual=4321]"
+func (r *checksumResult) label(colored bool) string {
+	if r == nil {
+		return ""
+	}
+	color, reset := "", ""
+	if colored {
+		color, reset = colorGreen, colorReset
+		if !r.ok {
+			color = colorRed
+		}
+	}
+	if r.ok {
+		return fmt.Sprintf("%s[CRC OK]%s ", color, reset)
+	}
+	return fmt.Sprintf("%s[CRC BAD expected=%s actual=%s]%s ", color, r.expected, r.actual, reset)
+}
+
+// fields returns the structured log fields of the result
+func (r *checksumResult) fields() []logField {
+	if r == nil {
+		return nil
+	}
+	status := "ok"
+	if !r.ok {
+		status = "bad"
+	}
+	return []logField{
+		{"checksum", status},
+		{"checksum_expected", r.expected},
+		{"checksum_actual", r.actual},
+	}
+}
+
+// lrc calculates the longitudinal redundancy check (two's complement of the byte sum)
+func lrc(data []byte) uint32 {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return uint32(-sum)
+}
+
+// xorChecksum calculates the XOR of all bytes
+func xorChecksum(data []byte) uint32 {
+	var sum byte
+	for _, b := range data {
+		sum ^= b
+	}
+	return uint32(sum)
+}
+
+// crc8 calculates CRC-8 (poly 0x07, init 0x00)
+func crc8(data []byte) byte {
+	crc := byte(0)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16Modbus calculates CRC-16/MODBUS (poly 0xA001 reflected, init 0xFFFF)
+func crc16Modbus(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16CCITT calculates CRC-16/CCITT-FALSE (poly 0x1021, init 0xFFFF)
+func crc16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
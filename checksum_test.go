@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestChecksumAlgorithms(t *testing.T) {
+	// Check values of the catalogue of CRC algorithms, over "123456789"
+	data := []byte("123456789")
+	tests := []struct {
+		name string
+		want uint32
+	}{
+		{"lrc", 0x23},
+		{"xor", 0x31},
+		{"crc8", 0xF4},
+		{"crc16-modbus", 0x4B37},
+		{"crc16-ccitt", 0x29B1},
+		{"crc32", 0xCBF43926},
+	}
+	for _, tt := range tests {
+		if got := checksumAlgos[tt.name].calculate(data); got != tt.want {
+			t.Errorf("%s = %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChecksumSpec(t *testing.T) {
+	tests := []struct {
+		value   string
+		message string
+		want    string
+	}{
+		{"crc16", "123456789", "123456789\x37\x4B"},
+		{"crc16-modbus:be", "123456789", "123456789\x4B\x37"},
+		{"ccitt:ascii-hex", "123456789", "12345678929B1"},
+		{"crc32", "123456789", "123456789\x26\x39\xF4\xCB"},
+	}
+	for _, tt := range tests {
+		c, err := parseChecksum(tt.value)
+		if err != nil {
+			t.Fatalf("parseChecksum(%q): %v", tt.value, err)
+		}
+		framed := c.appendTo(tt.message)
+		if framed != tt.want {
+			t.Errorf("%s: appendTo() = %q, want %q", tt.value, framed, tt.want)
+		}
+		if stripped := c.strip(framed); stripped != tt.message {
+			t.Errorf("%s: strip() = %q, want %q", tt.value, stripped, tt.message)
+		}
+		if result := c.verify([]byte(framed)); !result.ok {
+			t.Errorf("%s: verify() = %+v, want ok", tt.value, result)
+		}
+		corrupted := []byte(framed)
+		corrupted[0] ^= 0xFF
+		if result := c.verify(corrupted); result.ok {
+			t.Errorf("%s: verify() of a corrupted message is ok", tt.value)
+		}
+	}
+
+	for _, value := range []string{"md5", "crc16:middle"} {
+		if _, err := parseChecksum(value); err == nil {
+			t.Errorf("parseChecksum(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// decoder is a payload format selected with --decode
+type decoder struct {
+	name  string
+	label string // Name in messages, like "MessagePack"
+
+	// decode formats a payload into lines shown below the message line,
+	// or returns why the payload is not in this format
+	decode func(payload []byte, colored bool) (string, error)
+
+	// validate checks a message typed in client mode before it is sent (nil: not checked)
+	validate func(payload []byte) error
+
+	// encode converts a value typed as JSON in json input mode into a payload (nil: no json input)
+	encode func(value interface{}) ([]byte, error)
+
+	// binary formats decode the payload bytes as received, without text encoding
+	binary bool
+}
+
+// Supported formats for --decode
+var decoders = map[string]*decoder{
+	"json":     jsonDecoder,
+	"msgpack":  msgpackDecoder,
+	"cbor":     cborDecoder,
+	"protobuf": protobufDecoder,
+}
+
+var activeDecoder *decoder // nil when --decode is not given
+
+var messageTerminator []byte // Terminator removed from binary payloads (nil: messages are not terminated)
+
+// parseDecoder validates the value of --decode
+func parseDecoder(value string) (*decoder, error) {
+	if d, ok := decoders[strings.ToLower(value)]; ok {
+		return d, nil
+	}
+	names := make([]string, 0, len(decoders))
+	for n := range decoders {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("decode format must be one of: %s", strings.Join(names, ", "))
+}
+
+// messagePayload returns the payload of a message: the text without terminator and checksum,
+// or for binary formats the bytes without length prefix, terminator and checksum
+func messagePayload(data []byte) []byte {
+	if activeDecoder != nil && activeDecoder.binary {
+		data = bytes.TrimSuffix(stripLengthPrefix(data), messageTerminator)
+		return []byte(checksum.strip(string(data)))
+	}
+	message := checksum.strip(strings.TrimRight(string(data), "\r\n"))
+	return []byte(decodeText([]byte(message)))
+}
+
+// decodePayload returns the decoded payload as indented lines following a message line,
+// the error flagged in red when it cannot be decoded, or "" when --decode is not given
+func decodePayload(data []byte, colored bool) string {
+	if activeDecoder == nil {
+		return ""
+	}
+	payload := messagePayload(data)
+	if emptyPayload(payload) {
+		return ""
+	}
+	decoded, err := activeDecoder.decode(payload, colored)
+	if err != nil {
+		line := fmt.Sprintf("Invalid %s: %v", activeDecoder.label, err)
+		if colored {
+			return "\n  " + colorRed + line + colorReset
+		}
+		return "\n  " + line
+	}
+	if decoded == "" {
+		return "" // Nothing selected with --jq
+	}
+	return "\n  " + strings.ReplaceAll(strings.TrimSuffix(decoded, "\n"), "\n", "\n  ")
+}
+
+// payloadFields returns the structured log field of a payload that cannot be decoded
+func payloadFields(data []byte) []logField {
+	if activeDecoder == nil {
+		return nil
+	}
+	payload := messagePayload(data)
+	if emptyPayload(payload) {
+		return nil
+	}
+	if _, err := activeDecoder.decode(payload, false); err != nil {
+		return []logField{{"decode_error", err.Error()}}
+	}
+	return nil
+}
+
+// validatePayload checks a message typed in client mode, if the selected format validates input
+func validatePayload(data []byte) error {
+	if activeDecoder == nil || activeDecoder.validate == nil {
+		return nil
+	}
+	if err := activeDecoder.validate(messagePayload(data)); err != nil {
+		return fmt.Errorf("invalid %s: %v", activeDecoder.label, err)
+	}
+	return nil
+}
+
+// emptyPayload reports whether there is nothing to decode in a payload
+func emptyPayload(payload []byte) bool {
+	if activeDecoder.binary {
+		return len(payload) == 0
+	}
+	return strings.TrimSpace(string(payload)) == ""
+}
+
+// encodeInput converts a message typed in json input mode into a payload of the --decode format
+func encodeInput(text string) (string, error) {
+	if activeDecoder == nil || activeDecoder.encode == nil {
+		return "", fmt.Errorf("json input requires --decode json, msgpack or cbor")
+	}
+	value, err := parseJSON([]byte(text))
+	if err != nil {
+		return "", fmt.Errorf("invalid JSON: %v", err)
+	}
+	data, err := activeDecoder.encode(value)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode as %s: %v", activeDecoder.label, err)
+	}
+	return string(data), nil
+}
+
+// byteReader reads a binary payload and reports errors with their offset
+type byteReader struct {
+	data []byte
+	pos  int
+}
+
+// errorf returns an error at the current offset
+func (r *byteReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s (offset %d)", fmt.Sprintf(format, args...), r.pos)
+}
+
+// next returns the next n bytes
+func (r *byteReader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data)-r.pos {
+		return nil, fmt.Errorf("unexpected end of data (offset %d)", len(r.data))
+	}
+	data := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return data, nil
+}
+
+// readByte returns the next byte
+func (r *byteReader) readByte() (byte, error) {
+	data, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// uint returns the next size bytes as a big-endian number
+func (r *byteReader) uint(size int) (uint64, error) {
+	data, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+// length returns the next size bytes as the length of following data, checked against the payload
+func (r *byteReader) length(size int) (int, error) {
+	start := r.pos
+	value, err := r.uint(size)
+	if err != nil {
+		return 0, err
+	}
+	if value > uint64(len(r.data)-r.pos) {
+		return 0, fmt.Errorf("length %d exceeds the payload (offset %d)", value, start)
+	}
+	return int(value), nil
+}
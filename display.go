@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Display modes for message lines
+const (
+	displayLine    = "line"    // Text, byte count and HEX column on one line
+	displayText    = "text"    // Text and byte count only
+	displayHexdump = "hexdump" // Byte count and hexdump -C style rows
+	displayBoth    = "both"    // Text, byte count and hexdump rows
+)
+
+var displayMode = displayLine
+
+// parseDisplayMode validates the value of --display
+func parseDisplayMode(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case displayLine, displayText, displayHexdump, displayBoth:
+		return strings.ToLower(value), nil
+	}
+	return "", fmt.Errorf("display mode must be 'line', 'text', 'hexdump' or 'both'")
+}
+
+// formatPayload formats the text, byte count and data of a message for the display mode.
+// The result follows the "Received:"/"Sent:" label; hexdump rows start on the next line.
+func formatPayload(text string, data []byte, colored bool) string {
+	cyan, purple, reset := "", "", ""
+	if colored {
+		cyan, purple, reset = colorCyan, colorPurple, colorReset
+	}
+
+	switch displayMode {
+	case displayText:
+		return fmt.Sprintf("%s (Bytes: %s%d%s)", text, cyan, len(data), reset)
+	case displayHexdump:
+		return fmt.Sprintf("(Bytes: %s%d%s)\n%s", cyan, len(data), reset, hexdump(data, colored))
+	case displayBoth:
+		return fmt.Sprintf("%s (Bytes: %s%d%s)\n%s", text, cyan, len(data), reset, hexdump(data, colored))
+	default:
+		return fmt.Sprintf("%s (Bytes: %s%d%s, HEX: %s%x%s)", text, cyan, len(data), reset, purple, data, reset)
+	}
+}
+
+// hexdump formats data like 'hexdump -C': offset, 16 hex bytes and an ASCII gutter per row
+func hexdump(data []byte, colored bool) string {
+	purple, reset := "", ""
+	if colored {
+		purple, reset = colorPurple, colorReset
+	}
+
+	var rows []string
+	for offset := 0; offset < len(data); offset += 16 {
+		end := offset + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		row := data[offset:end]
+
+		var hexPart, asciiPart strings.Builder
+		for i := 0; i < 16; i++ {
+			if i == 8 {
+				hexPart.WriteByte(' ')
+			}
+			if i < len(row) {
+				fmt.Fprintf(&hexPart, "%02x ", row[i])
+				if row[i] >= 0x20 && row[i] < 0x7F {
+					asciiPart.WriteByte(row[i])
+				} else {
+					asciiPart.WriteByte('.')
+				}
+			} else {
+				hexPart.WriteString("   ")
+			}
+		}
+		rows = append(rows, fmt.Sprintf("  %08x  %s%s%s |%s|", offset, purple, hexPart.String(), reset, asciiPart.String()))
+	}
+	if len(rows) == 0 {
+		return "  (empty)"
+	}
+	return strings.Join(rows, "\n")
+}
+
+// visibleText renders control characters in received text visibly
+// (CR as ␍, LF as ␊, TAB as ␉, others as \xHH) so they don't affect the terminal.
+func visibleText(text string) string {
+	var result strings.Builder
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			// Invalid UTF-8 byte
+			fmt.Fprintf(&result, "\\x%02X", text[i])
+		case r == '\r':
+			result.WriteString("␍")
+		case r == '\n':
+			result.WriteString("␊")
+		case r == '\t':
+			result.WriteString("␉")
+		case r < 0x20 || r == 0x7F:
+			fmt.Fprintf(&result, "\\x%02X", r)
+		case !unicode.IsPrint(r) && r != ' ':
+			fmt.Fprintf(&result, "\\u%04X", r)
+		default:
+			result.WriteString(text[i : i+size])
+		}
+		i += size
+	}
+	return result.String()
+}
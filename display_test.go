@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestHexdump(t *testing.T) {
+	data := []byte("Hello, World!\r\n\x00\x7f\xffABC")
+	want := "  00000000  48 65 6c 6c 6f 2c 20 57  6f 72 6c 64 21 0d 0a 00  |Hello, World!...|\n" +
+		"  00000010  7f ff 41 42 43                                    |..ABC|"
+	if got := hexdump(data, false); got != want {
+		t.Errorf("hexdump() =\n%s\nwant\n%s", got, want)
+	}
+	if got := hexdump(nil, false); got != "  (empty)" {
+		t.Errorf("hexdump(nil) = %q", got)
+	}
+}
+
+func TestVisibleText(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"abc", "abc"},
+		{"a\r\n", "a␍␊"},
+		{"a\tb", "a␉b"},
+		{"\x00\x1b[0m\x7f", `\x00\x1B[0m\x7F`},
+		{"\xff\xfe", `\xFF\xFE`},
+		{"日本 語", "日本 語"},
+		{"\u200b\u2028", `\u200B\u2028`},
+	}
+	for _, tt := range tests {
+		if got := visibleText(tt.text); got != tt.want {
+			t.Errorf("visibleText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFormatPayloadModes(t *testing.T) {
+	defer func() { displayMode = displayLine }()
+	tests := []struct {
+		mode, want string
+	}{
+		{displayLine, "AB (Bytes: 2, HEX: 4142)"},
+		{displayText, "AB (Bytes: 2)"},
+		{displayHexdump, "(Bytes: 2)\n  00000000  41 42                                             |AB|"},
+		{displayBoth, "AB (Bytes: 2)\n  00000000  41 42                                             |AB|"},
+	}
+	for _, tt := range tests {
+		displayMode = tt.mode
+		if got := formatPayload("AB", []byte("AB"), false); got != tt.want {
+			t.Errorf("formatPayload() in %s mode = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
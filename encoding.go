@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Supported character encodings for --encoding (nil means UTF-8, no conversion)
+var encodings = map[string]encoding.Encoding{
+	"utf-8":     nil,
+	"shift_jis": japanese.ShiftJIS,
+	"euc-jp":    japanese.EUCJP,
+	"utf-16le":  unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be":  unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"latin-1":   charmap.ISO8859_1,
+}
+
+// Alternative names of the supported encodings
+var encodingAliases = map[string]string{
+	"utf8":        "utf-8",
+	"sjis":        "shift_jis",
+	"shift-jis":   "shift_jis",
+	"cp932":       "shift_jis",
+	"windows-31j": "shift_jis",
+	"eucjp":       "euc-jp",
+	"utf-16":      "utf-16le", // No byte order mark detection
+	"latin1":      "latin-1",
+	"iso-8859-1":  "latin-1",
+}
+
+var (
+	encodingName = "utf-8"
+	textEncoding encoding.Encoding // nil for UTF-8
+)
+
+// parseEncoding validates the value of --encoding and returns its canonical name
+func parseEncoding(value string) (string, encoding.Encoding, error) {
+	name := strings.ToLower(value)
+	if alias, ok := encodingAliases[name]; ok {
+		name = alias
+	}
+	enc, ok := encodings[name]
+	if !ok {
+		names := make([]string, 0, len(encodings))
+		for n := range encodings {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", nil, fmt.Errorf("encoding must be one of: %s", strings.Join(names, ", "))
+	}
+	return name, enc, nil
+}
+
+// decodeText converts received bytes from the selected encoding to a UTF-8 string.
+// Byte sequences that are invalid in the encoding are shown as escaped bytes (\xHH)
+// instead of replacement characters.
+func decodeText(data []byte) string {
+	if textEncoding == nil {
+		return string(data)
+	}
+
+	decoder := textEncoding.NewDecoder()
+	var result strings.Builder
+	for i := 0; i < len(data); {
+		// Find the shortest byte sequence that decodes to one valid character
+		decoded := false
+		for n := 1; n <= 4 && i+n <= len(data); n++ {
+			out, err := decoder.Bytes(data[i : i+n])
+			if err != nil || utf8.RuneCount(out) != 1 {
+				continue
+			}
+			if r, _ := utf8.DecodeRune(out); r == utf8.RuneError {
+				continue
+			}
+			result.Write(out)
+			i += n
+			decoded = true
+			break
+		}
+		if !decoded {
+			fmt.Fprintf(&result, "\\x%02X", data[i])
+			i++
+		}
+	}
+	return result.String()
+}
+
+// encodeText converts typed UTF-8 text to the selected encoding
+func encodeText(text string) (string, error) {
+	if textEncoding == nil {
+		return text, nil
+	}
+	encoded, err := textEncoding.NewEncoder().String(text)
+	if err != nil {
+		// Report the first character that has no representation in the encoding
+		for _, r := range text {
+			if _, err := textEncoding.NewEncoder().String(string(r)); err != nil {
+				return "", fmt.Errorf("character %q cannot be encoded in %s", r, encodingName)
+			}
+		}
+		return "", err
+	}
+	return encoded, nil
+}
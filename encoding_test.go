@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestEncodingRoundTrip(t *testing.T) {
+	defer func() { encodingName, textEncoding = "utf-8", nil }()
+	tests := []struct {
+		encoding string
+		text     string
+		bytes    string
+	}{
+		{"utf-8", "日本", "\xe6\x97\xa5\xe6\x9c\xac"},
+		{"sjis", "日本", "\x93\xfa\x96\x7b"},
+		{"euc-jp", "日本", "\xc6\xfc\xcb\xdc"},
+		{"utf-16", "Aé", "A\x00\xe9\x00"},
+		{"utf-16be", "Aé", "\x00A\x00\xe9"},
+		{"latin-1", "café", "caf\xe9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			name, enc, err := parseEncoding(tt.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encodingName, textEncoding = name, enc
+			encoded, err := encodeText(tt.text)
+			if err != nil || encoded != tt.bytes {
+				t.Errorf("encodeText(%q) = %q, %v, want %q", tt.text, encoded, err, tt.bytes)
+			}
+			if decoded := decodeText([]byte(tt.bytes)); decoded != tt.text {
+				t.Errorf("decodeText(%q) = %q, want %q", tt.bytes, decoded, tt.text)
+			}
+		})
+	}
+}
+
+func TestEncodingInvalidData(t *testing.T) {
+	defer func() { encodingName, textEncoding = "utf-8", nil }()
+	encodingName, textEncoding, _ = parseEncoding("shift_jis")
+
+	if got := decodeText([]byte("AB\x81")); got != `AB\x81` {
+		t.Errorf("decodeText() = %q, want the invalid byte escaped", got)
+	}
+	if _, err := encodeText("A€"); err == nil {
+		t.Error("encodeText() of a character without Shift_JIS form succeeded")
+	}
+	if _, _, err := parseEncoding("ebcdic"); err == nil {
+		t.Error("parseEncoding(ebcdic) succeeded")
+	}
+}
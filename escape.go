@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const maxRepeatCount = 1 << 20 // Upper limit of {N} and ${rand:N}
+
+var messageSeq uint64 // Counter for ${seq}
+
+// processEscapeSequences converts escape sequences and placeholders in a string to their byte values.
+// The text between them is converted to the selected character encoding.
+func processEscapeSequences(input string) (string, error) {
+	var result strings.Builder
+	var literal strings.Builder // Text waiting to be encoded
+	var seq uint64              // ${seq} value of this message, assigned on first use
+
+	// flushLiteral writes the pending text in the selected encoding
+	flushLiteral := func() error {
+		if literal.Len() == 0 {
+			return nil
+		}
+		encoded, err := encodeText(literal.String())
+		if err != nil {
+			return err
+		}
+		result.WriteString(encoded)
+		literal.Reset()
+		return nil
+	}
+
+	i := 0
+	for i < len(input) {
+		switch {
+		case input[i] == '\\':
+			value, size, err := escapeSequence(input[i:])
+			if err != nil {
+				return "", &inputError{pos: i + 1, msg: err.Error()}
+			}
+			if err := flushLiteral(); err != nil {
+				return "", err
+			}
+			i += size
+
+			// Repeat count like \x00{64}
+			count, size, err := repeatCount(input[i:])
+			if err != nil {
+				return "", &inputError{pos: i + 1, msg: err.Error()}
+			}
+			result.WriteString(strings.Repeat(string(value), count))
+			i += size
+
+		case strings.HasPrefix(input[i:], "${"):
+			end := strings.IndexByte(input[i:], '}')
+			if end < 0 {
+				return "", &inputError{pos: i + 1, msg: "unterminated placeholder (expected ${name})"}
+			}
+			if err := flushLiteral(); err != nil {
+				return "", err
+			}
+			value, err := placeholderValue(input[i+2:i+end], []byte(result.String()), &seq)
+			if err != nil {
+				return "", &inputError{pos: i + 1, msg: err.Error()}
+			}
+			result.Write(value)
+			i += end + 1
+
+		default:
+			literal.WriteByte(input[i])
+			i++
+		}
+	}
+	if err := flushLiteral(); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// escapeSequence returns the bytes and length of the escape sequence at the start of s
+func escapeSequence(s string) ([]byte, int, error) {
+	if len(s) < 2 {
+		return nil, 0, fmt.Errorf("incomplete escape sequence (use \\\\ for a backslash)")
+	}
+	switch s[1] {
+	case 'r':
+		return []byte{0x0D}, 2, nil // CR
+	case 'n':
+		return []byte{0x0A}, 2, nil // LF
+	case 't':
+		return []byte{0x09}, 2, nil // TAB
+	case '0':
+		return []byte{0x00}, 2, nil // NUL
+	case 'e':
+		return []byte{0x1B}, 2, nil // ESC
+	case 'a':
+		return []byte{0x07}, 2, nil // BEL
+	case '\\':
+		return []byte{0x5C}, 2, nil // Backslash
+	case '$':
+		return []byte{'$'}, 2, nil // Dollar sign (not a placeholder)
+	case 'x':
+		// Handle \xHH format
+		if len(s) >= 4 {
+			if value, err := strconv.ParseUint(s[2:4], 16, 8); err == nil {
+				return []byte{byte(value)}, 4, nil
+			}
+		}
+		return nil, 0, fmt.Errorf("invalid \\x escape sequence (expected \\xHH)")
+	case 'u':
+		// Handle \uXXXX format, sent as UTF-8
+		if len(s) >= 6 {
+			if value, err := strconv.ParseUint(s[2:6], 16, 16); err == nil && utf8.ValidRune(rune(value)) {
+				return []byte(string(rune(value))), 6, nil
+			}
+		}
+		return nil, 0, fmt.Errorf("invalid \\u escape sequence (expected \\uXXXX)")
+	case 'd':
+		// Handle \dNNN format (decimal byte)
+		if len(s) >= 5 {
+			if value, err := strconv.ParseUint(s[2:5], 10, 8); err == nil {
+				return []byte{byte(value)}, 5, nil
+			}
+		}
+		return nil, 0, fmt.Errorf("invalid \\d escape sequence (expected \\dNNN, 000-255)")
+	}
+	r, _ := utf8.DecodeRuneInString(s[1:])
+	return nil, 0, fmt.Errorf("unknown escape sequence \\%c (use \\\\ for a backslash)", r)
+}
+
+// repeatCount parses a repeat count like {64} at the start of s.
+// It returns a count of 1 and length 0 if s does not start with a repeat count.
+func repeatCount(s string) (int, int, error) {
+	if !strings.HasPrefix(s, "{") {
+		return 1, 0, nil
+	}
+	end := strings.IndexByte(s, '}')
+	if end < 2 {
+		return 1, 0, nil
+	}
+	count, err := strconv.Atoi(s[1:end])
+	if err != nil {
+		return 1, 0, nil // Not a repeat count, e.g. "\n{text}"
+	}
+	if count < 1 || count > maxRepeatCount {
+		return 0, 0, fmt.Errorf("repeat count must be 1 to %d", maxRepeatCount)
+	}
+	return count, end + 1, nil
+}
+
+// placeholderValue returns the bytes of a ${name} placeholder.
+// preceding is the message converted so far, used by ${crc16}.
+func placeholderValue(name string, preceding []byte, seq *uint64) ([]byte, error) {
+	key, arg, hasArg := strings.Cut(name, ":")
+	switch key {
+	case "seq":
+		// Sequence number, incremented once per message
+		if *seq == 0 {
+			*seq = atomic.AddUint64(&messageSeq, 1)
+		}
+		return []byte(strconv.FormatUint(*seq, 10)), nil
+	case "ts":
+		// Unix time in seconds, or milliseconds with ${ts:ms}
+		switch arg {
+		case "":
+			return []byte(strconv.FormatInt(time.Now().Unix(), 10)), nil
+		case "ms":
+			return []byte(strconv.FormatInt(time.Now().UnixMilli(), 10)), nil
+		}
+		return nil, fmt.Errorf("invalid placeholder ${%s} (expected ${ts} or ${ts:ms})", name)
+	case "rand":
+		// Random bytes
+		count, err := strconv.Atoi(arg)
+		if !hasArg || err != nil || count < 1 || count > maxRepeatCount {
+			return nil, fmt.Errorf("invalid placeholder ${%s} (expected ${rand:N}, N is 1 to %d)", name, maxRepeatCount)
+		}
+		data := make([]byte, count)
+		if _, err := rand.Read(data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	case "file":
+		// File contents as-is
+		if !hasArg || arg == "" {
+			return nil, fmt.Errorf("invalid placeholder ${%s} (expected ${file:path})", name)
+		}
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	case "crc16":
+		// CRC-16/MODBUS over the preceding bytes, low byte first
+		crc := crc16Modbus(preceding)
+		return []byte{byte(crc), byte(crc >> 8)}, nil
+	}
+	return nil, fmt.Errorf("unknown placeholder ${%s}", name)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProcessEscapeSequences(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		pos   int // Position of the error, 0 if the input is valid
+	}{
+		{"repeat", `\x00{4}A`, "\x00\x00\x00\x00A", 0},
+		{"repeat of a named escape", `\r\n{2}`, "\r\n\n", 0},
+		{"braces without count", `\n{text}`, "\n{text}", 0},
+		{"empty braces", `\n{}`, "\n{}", 0},
+		{"zero repeat", `\x00{0}`, "", 5},
+		{"huge repeat", `ab\x00{1048577}`, "", 7},
+		{"crc16", `\x01\x03${crc16}`, "\x01\x03\x40\x21", 0},
+		{"escaped dollar", `\${seq}`, "${seq}", 0},
+		{"unknown placeholder", `A${foo}`, "", 2},
+		{"invalid ts", `${ts:us}`, "", 1},
+		{"invalid rand", `${rand:0}`, "", 1},
+		{"unterminated placeholder", `AB${seq`, "", 3},
+		{"incomplete escape", `AB\`, "", 3},
+		{"short hex escape", `\x4`, "", 1},
+		{"unknown escape", `A\q`, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := processEscapeSequences(tt.input)
+			if tt.pos == 0 {
+				if err != nil || got != tt.want {
+					t.Errorf("processEscapeSequences(%q) = %q, %v, want %q", tt.input, got, err, tt.want)
+				}
+				return
+			}
+			var inputErr *inputError
+			if !errors.As(err, &inputErr) {
+				t.Fatalf("processEscapeSequences(%q) = %q, %v, want an input error", tt.input, got, err)
+			}
+			if inputErr.pos != tt.pos {
+				t.Errorf("processEscapeSequences(%q) error %q, want position %d", tt.input, err, tt.pos)
+			}
+		})
+	}
+}
+
+func TestPlaceholderRand(t *testing.T) {
+	got, err := processEscapeSequences("${rand:16}")
+	if err != nil || len(got) != 16 {
+		t.Errorf("${rand:16} = %q, %v, want 16 bytes", got, err)
+	}
+	got, err = processEscapeSequences("${seq},${seq}")
+	if first, second, _ := strings.Cut(got, ","); err != nil || first != second {
+		t.Errorf("${seq},${seq} = %q, %v, want the same number twice", got, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/yutat23/coe/framing"
+	"github.com/yutat23/coe/tcp"
+)
+
+// newCodec returns the framing of the selected protocol or length prefix, or the terminator
+func newCodec(terminatorBytes []byte) framing.Codec {
+	if activeProtocol != nil && activeProtocol.split != nil {
+		return framing.Custom{Frame: activeProtocol.split, ConnFrame: activeProtocol.splitConn, Terminator: terminatorBytes}
+	}
+	if lengthPrefix != nil {
+		return lengthPrefix
+	}
+	return framing.Terminator(terminatorBytes)
+}
+
+// wireConn is a connection as seen by the messages: Telnet commands are answered and removed
+// from received data, 0xFF bytes in sent data are escaped, and the data on the wire is captured
+type wireConn struct {
+	net.Conn
+	telnet    *telnetSession
+	closeOnce sync.Once
+}
+
+// wrapConn returns the WrapConn function wrapping new connections for --telnet and --pcap.
+// Telnet commands are reported to sink, the sink of the session.
+func wrapConn(sink tcp.Sink) func(conn net.Conn, outbound bool) net.Conn {
+	return func(conn net.Conn, outbound bool) net.Conn {
+		capture.connected(conn, outbound)
+		c := &wireConn{Conn: conn}
+		if telnetEnabled {
+			c.telnet = newTelnetSession(conn, sink)
+		}
+		return c
+	}
+}
+
+func (c *wireConn) Read(p []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(p)
+		if n == 0 {
+			return n, err
+		}
+		capture.received(c.Conn, p[:n])
+		if c.telnet == nil {
+			return n, err
+		}
+		// Telnet commands are answered and removed from messages
+		n = copy(p, c.telnet.filter(p[:n]))
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (c *wireConn) Write(p []byte) (int, error) {
+	wire := telnetEscape(p)
+	if _, err := c.Conn.Write(wire); err != nil {
+		return 0, err
+	}
+	capture.sent(c.Conn, wire)
+	return len(p), nil
+}
+
+func (c *wireConn) Close() error {
+	c.closeOnce.Do(func() { capture.disconnected(c.Conn) })
+	return c.Conn.Close()
+}
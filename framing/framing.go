@@ -0,0 +1,183 @@
+// Package framing splits the data received on a TCP connection into frames and frames
+// messages for sending: by a terminator, a length prefix or a split function like a
+// protocol parser.
+package framing
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+// FlushTimeout is the time after which incomplete data is passed on as a partial frame
+const FlushTimeout = 100 * time.Millisecond
+
+// Codec frames the messages of a connection
+type Codec interface {
+	// Split returns the length of the first complete frame in data, or 0 if more data is needed
+	Split(data []byte) int
+
+	// Encode returns the frame to write for a message
+	Encode(message []byte) ([]byte, error)
+
+	// Decode returns the message of a received frame
+	Decode(frame []byte) []byte
+
+	// FlushPartial reports whether data without a complete frame is passed on after
+	// FlushTimeout. Codecs that know the length of a frame wait for the rest of it instead.
+	FlushPartial() bool
+}
+
+// ConnCodec is a Codec whose frames depend on the connection, like the responses to the
+// requests sent on it. ReadFrames splits the data of a connection with the codec returned
+// by ForConn for its remote address.
+type ConnCodec interface {
+	Codec
+	ForConn(addr string) Codec
+}
+
+// SplitFunc returns the length of the first complete frame in data, or 0 if more data is needed
+type SplitFunc func(data []byte) int
+
+// Terminator frames messages by a terminator like "\n"
+type Terminator []byte
+
+func (t Terminator) Split(data []byte) int {
+	if i := bytes.Index(data, t); i >= 0 {
+		return i + len(t)
+	}
+	return 0
+}
+
+func (t Terminator) Encode(message []byte) ([]byte, error) {
+	return append(bytes.Clone(message), t...), nil
+}
+
+func (t Terminator) Decode(frame []byte) []byte {
+	return bytes.TrimSuffix(frame, t)
+}
+
+// FlushPartial is true: a message without terminator is shown when no more data arrives
+func (t Terminator) FlushPartial() bool {
+	return true
+}
+
+// Custom frames received data with a split function, like the parser of a protocol.
+// Sent messages get Terminator appended, or are written as they are when it is nil.
+type Custom struct {
+	Frame SplitFunc
+
+	// ConnFrame is used instead of Frame when set, with the remote address of the connection.
+	// It is called once for each frame in order, so it may keep state of the connection.
+	ConnFrame func(addr string, data []byte) int
+
+	Terminator []byte
+}
+
+func (c Custom) Split(data []byte) int {
+	return c.Frame(data)
+}
+
+// ForConn returns the codec of a connection, splitting its data by ConnFrame when it is set
+func (c Custom) ForConn(addr string) Codec {
+	if c.ConnFrame == nil {
+		return c
+	}
+	frame := c.ConnFrame
+	return Custom{Frame: func(data []byte) int { return frame(addr, data) }, Terminator: c.Terminator}
+}
+
+func (c Custom) Encode(message []byte) ([]byte, error) {
+	return append(bytes.Clone(message), c.Terminator...), nil
+}
+
+func (c Custom) Decode(frame []byte) []byte {
+	return bytes.TrimSuffix(frame, c.Terminator)
+}
+
+// FlushPartial is false: the split function waits until a frame is complete
+func (c Custom) FlushPartial() bool {
+	return false
+}
+
+// ReadFrames reads from conn and splits the received data into frames with codec.
+// onFrame is called with each frame as received. Data without a complete frame is passed
+// when no more data arrives within FlushTimeout if the codec flushes partial frames
+// (partial is true), and when the connection is closed (partial is true unless the codec
+// flushes partial frames, then the data is a message without terminator).
+// ReadFrames returns the receive error, or the error returned by onFrame.
+func ReadFrames(conn net.Conn, codec Codec, bufferSize int, onFrame func(frame []byte, partial bool) error) error {
+	if c, ok := codec.(ConnCodec); ok {
+		codec = c.ForConn(conn.RemoteAddr().String())
+	}
+	buffer := make([]byte, bufferSize)
+	var messageBuffer bytes.Buffer
+
+	// flush passes the buffered data to onFrame and resets the buffer
+	flush := func(partial bool) error {
+		if messageBuffer.Len() == 0 {
+			return nil
+		}
+		frame := bytes.Clone(messageBuffer.Bytes())
+		messageBuffer.Reset()
+		return onFrame(frame, partial)
+	}
+
+	// split passes each complete frame in the buffer to onFrame
+	split := func() error {
+		for messageBuffer.Len() > 0 {
+			length := codec.Split(messageBuffer.Bytes())
+			if length == 0 {
+				break
+			}
+			frame := bytes.Clone(messageBuffer.Next(length))
+			if err := onFrame(frame, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		// Set read deadline to detect when data stops coming
+		conn.SetReadDeadline(time.Now().Add(FlushTimeout))
+		n, err := conn.Read(buffer)
+
+		// Check if it's a timeout error
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			// Timeout occurred - flush buffered data if any, unless the codec waits for the
+			// rest of the frame. Frames depending on what was sent on the connection in the
+			// meantime may be complete now, so the data is split again.
+			if codec.FlushPartial() {
+				if err := flush(true); err != nil {
+					return err
+				}
+			} else if err := split(); err != nil {
+				return err
+			}
+			continue // Continue reading
+		}
+
+		if err != nil {
+			// Flush any remaining buffered data before returning
+			if ferr := flush(!codec.FlushPartial()); ferr != nil {
+				return ferr
+			}
+			return err
+		}
+
+		if n == 0 {
+			// Flush any remaining buffered data when connection is closed gracefully
+			if err := flush(!codec.FlushPartial()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Process received data
+		messageBuffer.Write(buffer[:n])
+		if err := split(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// frame is a frame passed to onFrame by ReadFrames
+type frame struct {
+	Data    string
+	Partial bool
+}
+
+func (f frame) String() string {
+	return fmt.Sprintf("%q partial=%t", f.Data, f.Partial)
+}
+
+// readAll writes each chunk to a pipe, pausing after it when the pause is set, and
+// returns the frames ReadFrames passes on until the pipe is closed
+func readAll(t *testing.T, codec Codec, chunks []string, pauses []time.Duration) []frame {
+	t.Helper()
+	client, server := net.Pipe()
+	go func() {
+		for i, chunk := range chunks {
+			client.Write([]byte(chunk))
+			if i < len(pauses) {
+				time.Sleep(pauses[i])
+			}
+		}
+		client.Close()
+	}()
+
+	var frames []frame
+	err := ReadFrames(server, codec, 16, func(data []byte, partial bool) error {
+		frames = append(frames, frame{string(data), partial})
+		return nil
+	})
+	if err != io.EOF {
+		t.Errorf("ReadFrames() = %v, want EOF", err)
+	}
+	return frames
+}
+
+func TestReadFrames(t *testing.T) {
+	const slow = 3 * FlushTimeout
+	lengthPrefix := &LengthPrefix{Size: 2}
+	// A protocol frame is one byte of length followed by the data
+	custom := Custom{Frame: func(data []byte) int {
+		if len(data) == 0 || len(data) < 1+int(data[0]) {
+			return 0
+		}
+		return 1 + int(data[0])
+	}}
+
+	tests := []struct {
+		name   string
+		codec  Codec
+		chunks []string
+		pauses []time.Duration
+		want   []frame
+	}{
+		{
+			name:   "terminator frames in one read",
+			codec:  Terminator("\n"),
+			chunks: []string{"a\nbc\n"},
+			want:   []frame{{"a\n", false}, {"bc\n", false}},
+		},
+		{
+			name:   "terminator frame split over reads",
+			codec:  Terminator("\r\n"),
+			chunks: []string{"hel", "lo\r", "\nworld\r\n"},
+			want:   []frame{{"hello\r\n", false}, {"world\r\n", false}},
+		},
+		{
+			name:   "frame longer than the buffer",
+			codec:  Terminator("\n"),
+			chunks: []string{"0123456789abcdefghij\n"},
+			want:   []frame{{"0123456789abcdefghij\n", false}},
+		},
+		{
+			name:   "delayed terminator flushes a partial frame",
+			codec:  Terminator("\n"),
+			chunks: []string{"abc", "def\n"},
+			pauses: []time.Duration{slow},
+			want:   []frame{{"abc", true}, {"def\n", false}},
+		},
+		{
+			name:   "terminator data without terminator at close",
+			codec:  Terminator("\n"),
+			chunks: []string{"a\nrest"},
+			want:   []frame{{"a\n", false}, {"rest", false}},
+		},
+		{
+			name:   "length prefix split over reads",
+			codec:  lengthPrefix,
+			chunks: []string{"\x00", "\x03ab", "c\x00\x01d"},
+			want:   []frame{{"\x00\x03abc", false}, {"\x00\x01d", false}},
+		},
+		{
+			name:   "delayed length-prefixed frame is not flushed",
+			codec:  lengthPrefix,
+			chunks: []string{"\x00\x06hel", "lo!"},
+			pauses: []time.Duration{slow},
+			want:   []frame{{"\x00\x06hello!", false}},
+		},
+		{
+			name:   "incomplete length-prefixed frame at close",
+			codec:  lengthPrefix,
+			chunks: []string{"\x00\x06hel"},
+			want:   []frame{{"\x00\x06hel", true}},
+		},
+		{
+			name:   "delayed custom frame is not flushed",
+			codec:  custom,
+			chunks: []string{"\x05ab", "c", "de\x01x"},
+			pauses: []time.Duration{slow, slow},
+			want:   []frame{{"\x05abcde", false}, {"\x01x", false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readAll(t, tt.codec, tt.chunks, tt.pauses)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("frames = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFramesCallbackError(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("a\nb\n"))
+
+	stop := errors.New("stop")
+	count := 0
+	err := ReadFrames(server, Terminator("\n"), 16, func(data []byte, partial bool) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("ReadFrames() = %v after %d frames, want %v after 1", err, count, stop)
+	}
+}
+
+// A ConnFrame split function gets the address of the connection, and buffered data is split
+// again when no more data arrives
+func TestReadFramesConnFrame(t *testing.T) {
+	var addrs []string
+	var ready atomic.Bool // Set by the writer while no data arrives
+	codec := Custom{Frame: Terminator("\n").Split, ConnFrame: func(addr string, data []byte) int {
+		addrs = append(addrs, addr)
+		if !ready.Load() {
+			return 0
+		}
+		return len(data)
+	}}
+
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte("abc"))
+		ready.Store(true)
+		time.Sleep(3 * FlushTimeout)
+		client.Close()
+	}()
+	var frames []frame
+	ReadFrames(server, codec, 16, func(data []byte, partial bool) error {
+		frames = append(frames, frame{string(data), partial})
+		return nil
+	})
+	if want := []frame{{"abc", false}}; !reflect.DeepEqual(frames, want) {
+		t.Errorf("frames = %v, want %v", frames, want)
+	}
+	if len(addrs) == 0 || addrs[0] != server.RemoteAddr().String() {
+		t.Errorf("ConnFrame addresses = %q, want %q", addrs, server.RemoteAddr().String())
+	}
+}
+
+func TestLengthPrefix(t *testing.T) {
+	tests := []struct {
+		value   string
+		message string
+		frame   string
+	}{
+		{"1", "hi", "\x02hi"},
+		{"2", "hi", "\x00\x02hi"},
+		{"2:le", "hi", "\x02\x00hi"},
+		{"4", "hi", "\x00\x00\x00\x02hi"},
+		{"4:LE", "hi", "\x02\x00\x00\x00hi"},
+	}
+	for _, tt := range tests {
+		l, err := ParseLengthPrefix(tt.value)
+		if err != nil {
+			t.Fatalf("ParseLengthPrefix(%q): %v", tt.value, err)
+		}
+		frame, err := l.Encode([]byte(tt.message))
+		if err != nil || string(frame) != tt.frame {
+			t.Errorf("%s: Encode() = %q, %v, want %q", tt.value, frame, err, tt.frame)
+		}
+		if n := l.Split(append(frame, 'x')); n != len(frame) {
+			t.Errorf("%s: Split() = %d, want %d", tt.value, n, len(frame))
+		}
+		if n := l.Split(frame[:len(frame)-1]); n != 0 {
+			t.Errorf("%s: Split() of an incomplete frame = %d, want 0", tt.value, n)
+		}
+		if message := l.Decode(frame); !bytes.Equal(message, []byte(tt.message)) {
+			t.Errorf("%s: Decode() = %q, want %q", tt.value, message, tt.message)
+		}
+	}
+
+	for _, value := range []string{"3", "x", "2:xe"} {
+		if _, err := ParseLengthPrefix(value); err == nil {
+			t.Errorf("ParseLengthPrefix(%q) succeeded", value)
+		}
+	}
+	if _, err := (&LengthPrefix{Size: 1}).Encode(make([]byte, 256)); err == nil {
+		t.Error("Encode() of 256 bytes with a 1 byte prefix succeeded")
+	}
+}
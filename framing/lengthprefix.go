@@ -0,0 +1,90 @@
+package framing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LengthPrefix frames messages by a length field in front of each message
+type LengthPrefix struct {
+	Size         int  // 1, 2 or 4 bytes
+	LittleEndian bool // Byte order of the length (default: big endian)
+}
+
+// ParseLengthPrefix parses a length prefix like "2" or "4:le"
+func ParseLengthPrefix(value string) (*LengthPrefix, error) {
+	sizeText, order, _ := strings.Cut(strings.ToLower(value), ":")
+	size, err := strconv.Atoi(sizeText)
+	if err != nil || (size != 1 && size != 2 && size != 4) {
+		return nil, fmt.Errorf("length prefix size must be 1, 2 or 4 bytes")
+	}
+	switch order {
+	case "", "be", "le":
+	default:
+		return nil, fmt.Errorf("length prefix byte order must be 'le' or 'be'")
+	}
+	return &LengthPrefix{Size: size, LittleEndian: order == "le"}, nil
+}
+
+// String returns the size and byte order, like "2:be"
+func (l *LengthPrefix) String() string {
+	if l.LittleEndian {
+		return fmt.Sprintf("%d:le", l.Size)
+	}
+	return fmt.Sprintf("%d:be", l.Size)
+}
+
+// length returns the message length of a frame, or -1 when the prefix is incomplete
+func (l *LengthPrefix) length(data []byte) int {
+	if len(data) < l.Size {
+		return -1
+	}
+	length := 0
+	for i := 0; i < l.Size; i++ {
+		if l.LittleEndian {
+			length |= int(data[i]) << (8 * uint(i))
+		} else {
+			length = length<<8 | int(data[i])
+		}
+	}
+	return length
+}
+
+func (l *LengthPrefix) Split(data []byte) int {
+	length := l.length(data)
+	if length < 0 || len(data) < l.Size+length {
+		return 0
+	}
+	return l.Size + length
+}
+
+// Encode puts the length prefix in front of a message
+func (l *LengthPrefix) Encode(message []byte) ([]byte, error) {
+	if uint64(len(message)) >= 1<<(8*uint(l.Size)) {
+		return nil, fmt.Errorf("message of %d bytes is too long for a %d byte length prefix", len(message), l.Size)
+	}
+	frame := make([]byte, l.Size, l.Size+len(message))
+	for i := range l.Size {
+		shift := 8 * uint(i)
+		if l.LittleEndian {
+			frame[i] = byte(len(message) >> shift)
+		} else {
+			frame[l.Size-1-i] = byte(len(message) >> shift)
+		}
+	}
+	return append(frame, message...), nil
+}
+
+// FlushPartial is false: the rest of a frame is awaited however long it takes
+func (l *LengthPrefix) FlushPartial() bool {
+	return false
+}
+
+// Decode removes the length prefix from a received frame
+func (l *LengthPrefix) Decode(frame []byte) []byte {
+	if len(frame) < l.Size {
+		return frame
+	}
+	return frame[l.Size:]
+}
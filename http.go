@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	httpMaxHeaderSize = 64 * 1024 // Data without the end of the headers is passed as it is after this size
+	httpMaxBodyText   = 2048      // Longer bodies are cut in the display
+)
+
+var httpProtocol = &protocol{
+	name:      "http",
+	split:     splitHTTP,
+	splitConn: splitHTTPConn,
+	summary:   summarizeHTTP,
+	describe:  describeHTTP,
+	track:     trackHTTP,
+	respond:   respondHTTP,
+}
+
+var httpResponse []byte // Canned response of the server loaded with --http-response (nil: no response)
+
+var (
+	httpRequests   = map[string][]string{} // Methods of the requests sent on each connection that wait for a response (client mode)
+	httpRequestsMu sync.Mutex
+)
+
+// httpMessage is a parsed HTTP/1.1 request or response
+type httpMessage struct {
+	startLine string
+	headers   []string // "Name: value" lines
+	body      []byte   // Body with chunked encoding removed
+	chunked   bool
+}
+
+// httpHeaderEnd returns the length of the start line and headers including the empty line, or 0
+func httpHeaderEnd(data []byte) int {
+	crlf := bytes.Index(data, []byte("\r\n\r\n"))
+	lf := bytes.Index(data, []byte("\n\n"))
+	switch {
+	case crlf >= 0 && (lf < 0 || crlf < lf):
+		return crlf + 4
+	case lf >= 0:
+		return lf + 2
+	}
+	return 0
+}
+
+// httpHeader returns the value of a header, or ""
+func httpHeader(headers []string, name string) string {
+	for _, line := range headers {
+		if key, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// httpHeaderLines splits the start line and headers
+func httpHeaderLines(head []byte) (string, []string) {
+	lines := strings.Split(strings.TrimRight(string(head), "\r\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	return lines[0], lines[1:]
+}
+
+// httpStatus returns the status code of a response, or "" for a request
+func httpStatus(startLine string) string {
+	if !strings.HasPrefix(startLine, "HTTP/") {
+		return ""
+	}
+	fields := strings.Fields(startLine)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+// httpHasNoBody reports whether a response status never has a body (1xx, 204, 304)
+func httpHasNoBody(startLine string) bool {
+	status := httpStatus(startLine)
+	return strings.HasPrefix(status, "1") || status == "204" || status == "304"
+}
+
+// httpChunkedLength returns the length of a chunked body and the body without chunked encoding.
+// The length is 0 if more data is needed, or -1 if the encoding is invalid.
+func httpChunkedLength(data []byte) (int, []byte) {
+	var body []byte
+	pos := 0
+	for {
+		end := bytes.IndexByte(data[pos:], '\n')
+		if end < 0 {
+			return 0, nil
+		}
+		sizeText, _, _ := strings.Cut(strings.TrimSpace(string(data[pos:pos+end])), ";")
+		size, err := strconv.ParseUint(sizeText, 16, 31)
+		if err != nil {
+			return -1, nil
+		}
+		pos += end + 1
+		if size == 0 {
+			// Last chunk, followed by optional trailers and an empty line
+			if bytes.HasPrefix(data[pos:], []byte("\r\n")) {
+				return pos + 2, body
+			}
+			if bytes.HasPrefix(data[pos:], []byte("\n")) {
+				return pos + 1, body
+			}
+			if trailers := httpHeaderEnd(data[pos:]); trailers > 0 {
+				return pos + trailers, body
+			}
+			return 0, nil
+		}
+		if len(data) < pos+int(size) {
+			return 0, nil
+		}
+		body = append(body, data[pos:pos+int(size)]...)
+		pos += int(size)
+		// CRLF after the chunk data
+		if bytes.HasPrefix(data[pos:], []byte("\r\n")) {
+			pos += 2
+		} else if bytes.HasPrefix(data[pos:], []byte("\n")) {
+			pos++
+		} else if len(data) < pos+2 {
+			return 0, nil
+		} else {
+			return -1, nil
+		}
+	}
+}
+
+// splitHTTP frames HTTP/1.1 messages by headers, Content-Length and chunked encoding.
+// Responses without either are passed when the connection is closed. A request is held until its
+// whole body arrives; "Expect: 100-continue" is not answered, so clients send the body after
+// their own wait.
+func splitHTTP(data []byte) int {
+	headerEnd := httpHeaderEnd(data)
+	if headerEnd == 0 {
+		if len(data) > httpMaxHeaderSize {
+			return len(data)
+		}
+		return 0
+	}
+	startLine, headers := httpHeaderLines(data[:headerEnd])
+	if httpHasNoBody(startLine) {
+		return headerEnd
+	}
+	if strings.Contains(strings.ToLower(httpHeader(headers, "Transfer-Encoding")), "chunked") {
+		length, _ := httpChunkedLength(data[headerEnd:])
+		if length < 0 {
+			return len(data)
+		}
+		if length == 0 {
+			return 0
+		}
+		return headerEnd + length
+	}
+	if value := httpHeader(headers, "Content-Length"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 {
+			return len(data)
+		}
+		if len(data) < headerEnd+length {
+			return 0
+		}
+		return headerEnd + length
+	}
+	if strings.HasPrefix(startLine, "HTTP/") {
+		return 0 // Body until the connection is closed
+	}
+	return headerEnd
+}
+
+// splitHTTPConn frames the messages of a connection like splitHTTP, except that responses to
+// HEAD requests sent on it have no body. Each final (not 1xx) response answers the oldest
+// request waiting for a response.
+func splitHTTPConn(conn string, data []byte) int {
+	headerEnd := httpHeaderEnd(data)
+	if headerEnd == 0 || !bytes.HasPrefix(data, []byte("HTTP/")) {
+		return splitHTTP(data)
+	}
+	startLine, _ := httpHeaderLines(data[:headerEnd])
+
+	httpRequestsMu.Lock()
+	defer httpRequestsMu.Unlock()
+	methods := httpRequests[conn]
+	length := headerEnd
+	if len(methods) == 0 || methods[0] != "HEAD" {
+		length = splitHTTP(data)
+	}
+	if length > 0 && len(methods) > 0 && !strings.HasPrefix(httpStatus(startLine), "1") {
+		httpRequests[conn] = methods[1:]
+	}
+	return length
+}
+
+// trackHTTP keeps the methods of the requests sent in client mode until they are answered
+func trackHTTP(conn string, frame []byte, request bool) {
+	httpRequestsMu.Lock()
+	defer httpRequestsMu.Unlock()
+	if frame == nil {
+		delete(httpRequests, conn)
+		return
+	}
+	if !request || serverSide {
+		return
+	}
+	if m := parseHTTPMessage(frame); m != nil {
+		method, _, _ := strings.Cut(m.startLine, " ")
+		httpRequests[conn] = append(httpRequests[conn], strings.ToUpper(method))
+	}
+}
+
+// parseHTTPMessage parses a framed HTTP message, or returns nil if it has no headers
+func parseHTTPMessage(frame []byte) *httpMessage {
+	headerEnd := httpHeaderEnd(frame)
+	if headerEnd == 0 {
+		return nil
+	}
+	m := &httpMessage{}
+	m.startLine, m.headers = httpHeaderLines(frame[:headerEnd])
+	m.body = frame[headerEnd:]
+	if strings.Contains(strings.ToLower(httpHeader(m.headers, "Transfer-Encoding")), "chunked") {
+		if length, body := httpChunkedLength(m.body); length > 0 {
+			m.body = body
+			m.chunked = true
+		}
+	}
+	return m
+}
+
+// summarizeHTTP returns the request or status line of an HTTP message
+func summarizeHTTP(frame []byte, request bool, conn string) string {
+	m := parseHTTPMessage(frame)
+	if m == nil {
+		return ""
+	}
+	return visibleText(m.startLine)
+}
+
+// describeHTTP returns the headers and body of an HTTP message
+func describeHTTP(frame []byte, request bool, conn string) string {
+	m := parseHTTPMessage(frame)
+	if m == nil {
+		return ""
+	}
+	lines := make([]string, 0, len(m.headers)+2)
+	for _, header := range m.headers {
+		lines = append(lines, visibleText(header))
+	}
+	if len(m.body) == 0 {
+		return strings.Join(lines, "\n")
+	}
+
+	label := fmt.Sprintf("Body: %d bytes", len(m.body))
+	if m.chunked {
+		label += " (chunked)"
+	}
+	body := m.body
+	if len(body) > httpMaxBodyText {
+		body = body[:httpMaxBodyText]
+		label += fmt.Sprintf(", first %d bytes shown", httpMaxBodyText)
+	}
+	lines = append(lines, "", label)
+	for _, line := range strings.Split(strings.TrimRight(decodeText(body), "\r\n"), "\n") {
+		lines = append(lines, "  "+visibleText(strings.TrimSuffix(line, "\r")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// respondHTTP answers each request with the canned response, if any
+func respondHTTP(frame []byte) []byte {
+	if httpResponse == nil || parseHTTPMessage(frame) == nil {
+		return nil
+	}
+	return httpResponse
+}
+
+// loadHTTPResponse loads the canned response of --http-response.
+// The file holds a status line, headers, an empty line and the body, with LF or CRLF line endings.
+// A file without a status line is sent as the body of a "200 OK" response.
+// Content-Length is added when the file has neither Content-Length nor chunked encoding.
+func loadHTTPResponse(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var startLine string
+	var headers []string
+	var body []byte
+	if bytes.HasPrefix(data, []byte("HTTP/")) {
+		headerEnd := httpHeaderEnd(data)
+		if headerEnd == 0 {
+			headerEnd = len(data) // Headers only
+		}
+		startLine, headers = httpHeaderLines(data[:headerEnd])
+		body = data[headerEnd:]
+	} else {
+		startLine = "HTTP/1.1 200 OK"
+		headers = []string{"Content-Type: text/plain; charset=utf-8"}
+		body = data
+	}
+	if httpHeader(headers, "Content-Length") == "" && httpHeader(headers, "Transfer-Encoding") == "" {
+		headers = append(headers, fmt.Sprintf("Content-Length: %d", len(body)))
+	}
+
+	var response bytes.Buffer
+	response.WriteString(startLine + "\r\n")
+	for _, header := range headers {
+		response.WriteString(header + "\r\n")
+	}
+	response.WriteString("\r\n")
+	response.Write(body)
+	return response.Bytes(), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yutat23/coe/framing"
+)
+
+func TestSplitHTTP(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"headers only", "GET / HTTP/1.1\r\nHost: dev\r\n\r\n", 29},
+		{"incomplete headers", "GET / HTTP/1.1\r\nHost: dev\r\n", 0},
+		{"content length", "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET", 43},
+		{"body missing", "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhel", 0},
+		{"chunked", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", 62},
+		{"chunked missing last chunk", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n", 0},
+		{"response until close", "HTTP/1.1 200 OK\r\n\r\nhello", 0},
+		{"no content", "HTTP/1.1 204 No Content\r\n\r\n", 27},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitHTTP([]byte(tt.data)); got != tt.want {
+				t.Errorf("splitHTTP() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// A body arriving after FlushTimeout belongs to the same request
+func TestHTTPDelayedBody(t *testing.T) {
+	const head = "POST /data HTTP/1.1\r\nHost: dev\r\nContent-Length: 11\r\n\r\n"
+	const body = "hello world"
+
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte(head))
+		time.Sleep(3 * framing.FlushTimeout)
+		client.Write([]byte(body))
+		client.Close()
+	}()
+
+	type frame struct {
+		data    string
+		partial bool
+	}
+	var frames []frame
+	framing.ReadFrames(server, framing.Custom{Frame: splitHTTP}, 1024, func(data []byte, partial bool) error {
+		frames = append(frames, frame{string(data), partial})
+		return nil
+	})
+	if len(frames) != 1 || frames[0].data != head+body || frames[0].partial {
+		t.Fatalf("frames = %+v, want one complete request", frames)
+	}
+
+	httpResponse = []byte("HTTP/1.1 204 No Content\r\n\r\n")
+	defer func() { httpResponse = nil }()
+	if response := respondHTTP([]byte(frames[0].data)); string(response) != string(httpResponse) {
+		t.Errorf("respondHTTP() = %q, want %q", response, httpResponse)
+	}
+}
+
+// Responses to HEAD requests have no body even with Content-Length, and 1xx responses
+// come before the final response to the same request
+func TestSplitHTTPConn(t *testing.T) {
+	defer func(side bool) { serverSide = side }(serverSide)
+	serverSide = false
+	const conn = "127.0.0.1:80"
+	defer trackHTTP(conn, nil, false)
+	for _, request := range []string{"HEAD / HTTP/1.1\r\n\r\n", "POST / HTTP/1.1\r\nContent-Length: 0\r\n\r\n"} {
+		trackHTTP(conn, []byte(request), true)
+	}
+
+	const head = "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"
+	const interim = "HTTP/1.1 100 Continue\r\n\r\n"
+	responses := []string{head, interim, head + "hello"}
+	data := strings.Join(responses, "")
+	for _, response := range responses {
+		if got := splitHTTPConn(conn, []byte(data)); got != len(response) {
+			t.Fatalf("splitHTTPConn(%q) = %d, want %d", data, got, len(response))
+		}
+		data = data[len(response):]
+	}
+	// No request waits for a response any more
+	if got := splitHTTPConn(conn, []byte(head)); got != 0 {
+		t.Errorf("splitHTTPConn() without a request = %d, want 0 until the body arrives", got)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Input modes for typed messages
+const (
+	inputText   = "text"   // Text with escape sequences
+	inputHex    = "hex"    // Hex bytes like "02 41 03" or "0x024103"
+	inputBase64 = "b64"    // Base64
+	inputJSON   = "json"   // JSON encoded in the --decode format, like MessagePack
+	inputLayout = "layout" // name=value pairs built into a frame by the --layout file
+)
+
+var inputMode = inputText
+
+// inputError reports invalid typed input with its position
+type inputError struct {
+	pos int // 1-based position in the typed message
+	msg string
+}
+
+func (e *inputError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.msg, e.pos)
+}
+
+// parseInputMode validates an input mode name
+func parseInputMode(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case inputText:
+		return inputText, nil
+	case inputHex:
+		return inputHex, nil
+	case inputBase64, "base64":
+		return inputBase64, nil
+	case inputJSON:
+		return inputJSON, nil
+	case inputLayout:
+		return inputLayout, nil
+	}
+	return "", fmt.Errorf("input mode must be 'text', 'hex', 'b64', 'json' or 'layout'")
+}
+
+// inputPrompt returns the prompt with the input mode when it is not text
+func inputPrompt(name string) string {
+	if inputMode == inputText {
+		return name + "> "
+	}
+	return fmt.Sprintf("%s [%s]> ", name, inputMode)
+}
+
+// handleModeCommand handles '#mode [text|hex|b64|json|layout]' and prints the result
+func handleModeCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(textOutput, "Input mode: %s\n", inputMode)
+		return
+	}
+	mode, err := parseInputMode(args[0])
+	if err != nil {
+		fmt.Fprintln(textOutput, "Error:", err)
+		return
+	}
+	if mode == inputJSON && (activeDecoder == nil || activeDecoder.encode == nil) {
+		fmt.Fprintln(textOutput, "Error: json input requires --decode json, msgpack or cbor")
+		return
+	}
+	if mode == inputLayout && activeLayout == nil {
+		fmt.Fprintln(textOutput, "Error: layout input requires --layout")
+		return
+	}
+	inputMode = mode
+	fmt.Fprintf(textOutput, "Input mode: %s\n", inputMode)
+}
+
+// messageInputMode returns the input mode of a typed message and the length of its
+// mode prefix ("text:", "hex:", "b64:", "json:" or "layout:"), or the current input mode and 0
+func messageInputMode(input string) (string, int) {
+	for _, prefix := range []string{inputText, inputHex, inputBase64, inputJSON, inputLayout} {
+		if strings.HasPrefix(input, prefix+":") {
+			return prefix, len(prefix) + 1
+		}
+	}
+	return inputMode, 0
+}
+
+// convertInput converts a typed message to the bytes to send.
+// A "text:", "hex:", "b64:", "json:" or "layout:" prefix selects the input mode for this message only.
+func convertInput(input string) (string, error) {
+	mode, offset := messageInputMode(input)
+	var result string
+	var err error
+	switch mode {
+	case inputHex:
+		result, err = parseHexInput(input[offset:])
+	case inputBase64:
+		result, err = parseBase64Input(input[offset:])
+	case inputJSON:
+		result, err = encodeInput(input[offset:])
+	case inputLayout:
+		result, err = buildLayoutFrame(input[offset:])
+	default:
+		result, err = processEscapeSequences(input[offset:])
+	}
+	if inputErr, ok := err.(*inputError); ok {
+		// Report the position in the typed message including the prefix
+		inputErr.pos += offset
+	}
+	return result, err
+}
+
+// parseHexInput converts hex bytes separated by spaces, like "02 41 03" or "0x0241 03"
+func parseHexInput(input string) (string, error) {
+	var result strings.Builder
+	i := 0
+	for i < len(input) {
+		if input[i] == ' ' || input[i] == '\t' {
+			i++
+			continue
+		}
+
+		// One token of hex digits with an optional 0x prefix
+		start := i
+		if strings.HasPrefix(input[i:], "0x") || strings.HasPrefix(input[i:], "0X") {
+			i += 2
+		}
+		digits := i
+		for i < len(input) && input[i] != ' ' && input[i] != '\t' {
+			if hexValue(input[i]) < 0 {
+				return "", &inputError{pos: i + 1, msg: fmt.Sprintf("invalid hex digit %q", input[i])}
+			}
+			i++
+		}
+		if i == digits {
+			return "", &inputError{pos: start + 1, msg: "missing hex digits after 0x"}
+		}
+		if (i-digits)%2 != 0 {
+			return "", &inputError{pos: digits + 1, msg: "odd number of hex digits"}
+		}
+		for j := digits; j < i; j += 2 {
+			result.WriteByte(byte(hexValue(input[j])<<4 | hexValue(input[j+1])))
+		}
+	}
+	if result.Len() == 0 {
+		return "", &inputError{pos: 1, msg: "no hex bytes"}
+	}
+	return result.String(), nil
+}
+
+// parseBase64Input converts base64 text, with or without padding
+func parseBase64Input(input string) (string, error) {
+	trimmed := strings.TrimSpace(input)
+	leading := strings.Index(input, trimmed)
+	if trimmed == "" {
+		return "", &inputError{pos: 1, msg: "no base64 data"}
+	}
+
+	encoding := base64.StdEncoding
+	if !strings.HasSuffix(trimmed, "=") && len(trimmed)%4 != 0 {
+		encoding = base64.RawStdEncoding
+	}
+	data, err := encoding.DecodeString(trimmed)
+	if err != nil {
+		if corrupt, ok := err.(base64.CorruptInputError); ok {
+			return "", &inputError{pos: leading + int(corrupt) + 1, msg: "invalid base64 data"}
+		}
+		return "", err
+	}
+	return string(data), nil
+}
+
+// hexValue returns the value of a hex digit, or -1
+func hexValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
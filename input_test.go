@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConvertInputModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		pos   int // Position of the error, 0 if the input is valid
+	}{
+		{"hex", "hex:02 41 03", "\x02A\x03", 0},
+		{"hex with 0x", "hex:0x0241 0X03", "\x02A\x03", 0},
+		{"odd hex digits", "hex:02 413", "", 8},
+		{"invalid hex digit", "hex:02 4G", "", 9},
+		{"missing hex digits", "hex:02 0x", "", 8},
+		{"no hex bytes", "hex: ", "", 5},
+		{"base64", "b64:QUJD", "ABC", 0},
+		{"base64 without padding", "b64:QUI", "AB", 0},
+		{"base64 with padding", "b64:QUI=", "AB", 0},
+		{"base64 invalid character", "b64:QU*D", "", 7},
+		{"base64 bad padding", "b64:QUJD=", "", 9},
+		{"base64 padding inside", "b64:QQ==QUJD", "", 9},
+		{"base64 leading space", "b64: QU!D", "", 8},
+		{"no base64 data", "b64:  ", "", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertInput(tt.input)
+			if tt.pos == 0 {
+				if err != nil || got != tt.want {
+					t.Errorf("convertInput(%q) = %q, %v, want %q", tt.input, got, err, tt.want)
+				}
+				return
+			}
+			var inputErr *inputError
+			if !errors.As(err, &inputErr) {
+				t.Fatalf("convertInput(%q) = %q, %v, want an input error", tt.input, got, err)
+			}
+			if inputErr.pos != tt.pos {
+				t.Errorf("convertInput(%q) error %q, want position %d", tt.input, err, tt.pos)
+			}
+		})
+	}
+}
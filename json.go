@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var jsonDecoder = &decoder{
+	name:     "json",
+	label:    "JSON",
+	decode:   decodeJSON,
+	validate: validateJSON,
+	encode:   encodeJSON,
+}
+
+var jsonPaths []jsonPath // Fields selected with --jq (nil: the whole value)
+
+// jsonObject is a JSON object with its keys in the received order
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// jsonLiteral is a decoded value without a JSON form, shown as it is, like undefined
+type jsonLiteral string
+
+// jsonTagged is a decoded value with a type tag, shown like 1(1700000000) or timestamp("...")
+type jsonTagged struct {
+	tag   string
+	value interface{}
+}
+
+// jsonPath is a field path like ".items[0].name", applied step by step
+type jsonPath struct {
+	text  string
+	steps []jsonStep
+}
+
+// jsonStep selects an object key, an array index, or all elements (iterate)
+type jsonStep struct {
+	key     string
+	index   int
+	isIndex bool
+	iterate bool
+}
+
+// parseJSON parses one JSON value. Objects are jsonObject, numbers json.Number.
+func parseJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := readJSONValue(dec)
+	if err != nil {
+		return nil, jsonError(err, dec)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the value (offset %d)", dec.InputOffset())
+	}
+	return value, nil
+}
+
+// jsonError adds the position to a parse error
+func jsonError(err error, dec *json.Decoder) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("%v (offset %d)", err, syntaxErr.Offset)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("unexpected end of input (offset %d)", dec.InputOffset())
+	}
+	return err
+}
+
+// readJSONValue reads a value from the tokens of dec
+func readJSONValue(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonMember{key.(string), value})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return object, nil
+	case json.Delim('['):
+		array := []interface{}{}
+		for dec.More() {
+			value, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return array, nil
+	}
+	return token, nil
+}
+
+// decodeJSON formats a JSON payload indented, or the fields selected with --jq
+func decodeJSON(payload []byte, colored bool) (string, error) {
+	value, err := parseJSON(payload)
+	if err != nil {
+		return "", err
+	}
+	return formatTree(value, colored), nil
+}
+
+// formatTree formats a decoded value like JSON, or the fields selected with --jq
+func formatTree(value interface{}, colored bool) string {
+	if jsonPaths == nil {
+		return formatJSON(value, colored)
+	}
+
+	var lines []string
+	for _, path := range jsonPaths {
+		for _, selected := range path.selectValues(value) {
+			text := formatJSON(selected, colored)
+			if len(jsonPaths) > 1 {
+				// Label values when several fields are selected
+				text = path.text + ": " + text
+			}
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validateJSON checks that a typed message is one JSON value
+func validateJSON(payload []byte) error {
+	_, err := parseJSON(payload)
+	return err
+}
+
+// encodeJSON encodes a value typed in json input mode as JSON without whitespace
+func encodeJSON(value interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(formatJSON(value, false))); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// jsonNumberValue returns a number typed in json input mode as int64, uint64 or float64
+func jsonNumberValue(n json.Number) (interface{}, error) {
+	text := n.String()
+	if !strings.ContainsAny(text, ".eE") {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return u, nil
+		}
+		return nil, fmt.Errorf("integer %s does not fit in 64 bits", text)
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("number %s is out of range", text)
+	}
+	return f, nil
+}
+
+// parseJSONPaths parses the value of --jq: one or more paths separated by commas,
+// like ".name", ".items[0].id", ".items[].id" or `.["key with spaces"]`
+func parseJSONPaths(value string) ([]jsonPath, error) {
+	var paths []jsonPath
+	for _, text := range splitJSONPaths(value) {
+		text = strings.TrimSpace(text)
+		path, err := parseJSONPath(text)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// splitJSONPaths splits paths at commas outside of quoted keys
+func splitJSONPaths(value string) []string {
+	var paths []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && quoted:
+			i++
+		case value[i] == '"':
+			quoted = !quoted
+		case value[i] == ',' && !quoted:
+			paths = append(paths, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(paths, value[start:])
+}
+
+// parseJSONPath parses one path
+func parseJSONPath(text string) (jsonPath, error) {
+	path := jsonPath{text: text}
+	invalid := func(msg string) (jsonPath, error) {
+		return jsonPath{}, fmt.Errorf("invalid --jq path %q: %s", text, msg)
+	}
+	if !strings.HasPrefix(text, ".") {
+		return invalid("must start with '.'")
+	}
+	i := 0
+	if text == "." {
+		return path, nil
+	}
+	for i < len(text) {
+		switch {
+		case text[i] == '.' && i+1 < len(text) && text[i+1] != '[':
+			// .key or ."key"
+			i++
+			if text[i] == '"' {
+				key, n, err := readJSONPathString(text[i:])
+				if err != nil {
+					return invalid(err.Error())
+				}
+				path.steps = append(path.steps, jsonStep{key: key})
+				i += n
+				continue
+			}
+			start := i
+			for i < len(text) && (text[i] == '_' || text[i] == '-' || isAlnum(text[i])) {
+				i++
+			}
+			if i == start {
+				return invalid(fmt.Sprintf("unexpected %q", text[i]))
+			}
+			path.steps = append(path.steps, jsonStep{key: text[start:i]})
+		case text[i] == '.' && i+1 < len(text):
+			i++ // .[ is the same as [
+		case text[i] == '[':
+			end := strings.IndexByte(text[i:], ']')
+			if end < 0 {
+				return invalid("missing ']'")
+			}
+			inner := strings.TrimSpace(text[i+1 : i+end])
+			switch {
+			case inner == "":
+				path.steps = append(path.steps, jsonStep{iterate: true})
+				i += end + 1
+			case inner[0] == '"':
+				key, n, err := readJSONPathString(text[i+1:])
+				if err != nil {
+					return invalid(err.Error())
+				}
+				closing := i + 1 + n
+				if closing >= len(text) || text[closing] != ']' {
+					return invalid("missing ']'")
+				}
+				path.steps = append(path.steps, jsonStep{key: key})
+				i = closing + 1
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return invalid(fmt.Sprintf("index must be a number: %s", inner))
+				}
+				path.steps = append(path.steps, jsonStep{index: index, isIndex: true})
+				i += end + 1
+			}
+		default:
+			return invalid(fmt.Sprintf("unexpected %q", text[i]))
+		}
+	}
+	return path, nil
+}
+
+// readJSONPathString reads a quoted key and returns it with the length including the quotes
+func readJSONPathString(s string) (string, int, error) {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == '"' {
+			var key string
+			if err := json.Unmarshal([]byte(s[:i+1]), &key); err != nil {
+				return "", 0, fmt.Errorf("invalid quoted key %s", s[:i+1])
+			}
+			return key, i + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("missing closing quote")
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// selectValues applies the path to a value. Missing keys and indexes select null like jq,
+// iterating selects each element of an array or each value of an object.
+func (p jsonPath) selectValues(value interface{}) []interface{} {
+	values := []interface{}{value}
+	for _, step := range p.steps {
+		var next []interface{}
+		for _, v := range values {
+			switch {
+			case step.iterate:
+				switch v := v.(type) {
+				case []interface{}:
+					next = append(next, v...)
+				case jsonObject:
+					for _, member := range v {
+						next = append(next, member.value)
+					}
+				}
+			case step.isIndex:
+				array, _ := v.([]interface{})
+				index := step.index
+				if index < 0 {
+					index += len(array)
+				}
+				if index >= 0 && index < len(array) {
+					next = append(next, array[index])
+				} else {
+					next = append(next, nil)
+				}
+			default:
+				var found interface{}
+				if object, ok := v.(jsonObject); ok {
+					for _, member := range object {
+						if member.key == step.key {
+							found = member.value
+						}
+					}
+				}
+				next = append(next, found)
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// formatJSON formats a value with an indent of two spaces, colored like jq when colored is true
+func formatJSON(value interface{}, colored bool) string {
+	var b strings.Builder
+	writeJSON(&b, value, "", colored)
+	return b.String()
+}
+
+func writeJSON(b *strings.Builder, value interface{}, indent string, colored bool) {
+	paint := func(color, text string) {
+		if colored {
+			b.WriteString(color + text + colorReset)
+		} else {
+			b.WriteString(text)
+		}
+	}
+	switch v := value.(type) {
+	case jsonObject:
+		if len(v) == 0 {
+			b.WriteString("{}")
+			return
+		}
+		b.WriteString("{\n")
+		for i, member := range v {
+			b.WriteString(indent + "  ")
+			paint(colorCyan, jsonQuote(member.key))
+			b.WriteString(": ")
+			writeJSON(b, member.value, indent+"  ", colored)
+			if i < len(v)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(indent + "}")
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString("[]")
+			return
+		}
+		b.WriteString("[\n")
+		for i, element := range v {
+			b.WriteString(indent + "  ")
+			writeJSON(b, element, indent+"  ", colored)
+			if i < len(v)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(indent + "]")
+	case string:
+		paint(colorGreen, jsonQuote(v))
+	case json.Number:
+		paint(colorYellow, v.String())
+	case bool:
+		paint(colorPurple, strconv.FormatBool(v))
+	case nil:
+		paint(colorPurple, "null")
+	case []byte:
+		paint(colorPurple, "h'"+hex.EncodeToString(v)+"'") // Binary data of MessagePack and CBOR
+	case jsonLiteral:
+		paint(colorPurple, string(v))
+	case jsonTagged:
+		b.WriteString(v.tag + "(")
+		writeJSON(b, v.value, indent, colored)
+		b.WriteString(")")
+	}
+}
+
+// jsonQuote quotes a string as JSON without escaping HTML characters
+func jsonQuote(s string) string {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// jsonPathsText returns the paths of --jq for the startup output
+func jsonPathsText() string {
+	texts := make([]string, len(jsonPaths))
+	for i, path := range jsonPaths {
+		texts[i] = path.text
+	}
+	return strings.Join(texts, ", ")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONFieldSelection(t *testing.T) {
+	defer func() { jsonPaths = nil }()
+	payload := []byte(`{"name":"sensor","items":[{"id":1},{"id":2,"tags":["a","b"]}],"key with spaces":true}`)
+
+	tests := []struct {
+		paths string
+		want  string
+	}{
+		{".name", `"sensor"`},
+		{".items[0].id", "1"},
+		{".items[-1].tags[1]", `"b"`},
+		{".items[].id", "1\n2"},
+		{`.["key with spaces"]`, "true"},
+		{".missing", "null"},
+		{".items[5].id", "null"},
+		{".name.first", "null"},
+		{".name, .items[1].id", ".name: \"sensor\"\n.items[1].id: 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.paths, func(t *testing.T) {
+			paths, err := parseJSONPaths(tt.paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonPaths = paths
+			got, err := decodeJSON(payload, false)
+			if err != nil || got != tt.want {
+				t.Errorf("decodeJSON() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONPathErrors(t *testing.T) {
+	for _, paths := range []string{"name", ".items[", ".items[x]", `.["key]`, ".a..b"} {
+		if _, err := parseJSONPaths(paths); err == nil {
+			t.Errorf("parseJSONPaths(%q) succeeded, want an error", paths)
+		}
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		payload string
+		err     string // Part of the error, "" if the payload is valid
+	}{
+		{`{"a":[1,2.5,"x",null]}`, ""},
+		{`{"a":1,}`, "offset 7"},
+		{`{"a":`, "unexpected end of input"},
+		{`{"a":1} {"b":2}`, "unexpected data after the value"},
+		{`nope`, "invalid character"},
+	}
+	for _, tt := range tests {
+		err := validateJSON([]byte(tt.payload))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("validateJSON(%q) = %v", tt.payload, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("validateJSON(%q) = %v, want an error with %q", tt.payload, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,709 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var layoutDecoder = &decoder{
+	name:   "layout",
+	label:  "frame",
+	decode: decodeLayout,
+	binary: true,
+}
+
+// frameLayout is the structure of frames read from the --layout file
+type frameLayout struct {
+	Name   string        `yaml:"name"`
+	Endian string        `yaml:"endian"` // Default byte order: big (default) or little
+	Fields []layoutField `yaml:"fields"`
+}
+
+// layoutField is one field of a layout. Type is an integer (u8-u64, i8-i64), a float
+// (f32, f64), string, bytes or struct (with fields). A field with switch and cases
+// continues with the fields of the case selected by an earlier field.
+type layoutField struct {
+	Name    string                   `yaml:"name"`
+	Type    string                   `yaml:"type"`
+	Offset  *int                     `yaml:"offset"` // Position from the start of the frame (default: after the previous field)
+	Endian  string                   `yaml:"endian"`
+	Length  layoutSize               `yaml:"length"` // string and bytes: number of bytes (default: rest of the frame)
+	Count   layoutSize               `yaml:"count"`  // Array of count elements
+	Enum    map[string]string        `yaml:"enum"`   // Names of integer values
+	Format  string                   `yaml:"format"` // hex: show integers in hex
+	Value   string                   `yaml:"value"`  // Value used when building a frame without this field
+	Bits    []layoutBits             `yaml:"bits"`   // Bit fields of an integer
+	Fields  []layoutField            `yaml:"fields"` // Fields of a struct
+	Switch  string                   `yaml:"switch"` // Field selecting the case
+	Cases   map[string][]layoutField `yaml:"cases"`
+	Default []layoutField            `yaml:"default"` // Fields when no case matches
+}
+
+// layoutBits is a bit field of an integer field
+type layoutBits struct {
+	Name string            `yaml:"name"`
+	Bit  *int              `yaml:"bit"`  // Lowest bit, 0 is the least significant (default: above the previous bit field)
+	Bits int               `yaml:"bits"` // Width (default: 1)
+	Enum map[string]string `yaml:"enum"`
+}
+
+// layoutSize is a fixed size, or the name of an earlier integer field holding it
+type layoutSize struct {
+	n     int
+	field string
+	set   bool
+}
+
+func (s *layoutSize) UnmarshalYAML(node *yaml.Node) error {
+	if n, err := strconv.Atoi(node.Value); err == nil {
+		if n < 0 {
+			return fmt.Errorf("line %d: size must not be negative", node.Line)
+		}
+		*s = layoutSize{n: n, set: true}
+		return nil
+	}
+	*s = layoutSize{field: node.Value, set: true}
+	return nil
+}
+
+var (
+	layoutPath   string       // Layout file of --layout
+	activeLayout *frameLayout // nil when --layout is not given
+)
+
+// Integer and float sizes in bytes
+var layoutTypeSizes = map[string]int{
+	"u8": 1, "u16": 2, "u32": 4, "u64": 8,
+	"i8": 1, "i16": 2, "i32": 4, "i64": 8,
+	"f32": 4, "f64": 8,
+}
+
+// loadLayout reads and checks a layout file
+func loadLayout(path string) (*frameLayout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	layout := &frameLayout{}
+	if err := yaml.Unmarshal(data, layout); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(layout.Endian) {
+	case "", "big", "be":
+		layout.Endian = "big"
+	case "little", "le":
+		layout.Endian = "little"
+	default:
+		return nil, fmt.Errorf("endian must be 'big' or 'little'")
+	}
+	if len(layout.Fields) == 0 {
+		return nil, fmt.Errorf("no fields")
+	}
+	if err := checkLayoutFields(layout.Fields); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// checkLayoutFields checks the offsets, types, bit fields and endianness of fields
+func checkLayoutFields(fields []layoutField) error {
+	for i := range fields {
+		f := &fields[i]
+		if f.Switch != "" {
+			if len(f.Cases) == 0 {
+				return fmt.Errorf("field %s: switch without cases", f.Name)
+			}
+			for _, fields := range f.Cases {
+				if err := checkLayoutFields(fields); err != nil {
+					return err
+				}
+			}
+			if err := checkLayoutFields(f.Default); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.Name == "" {
+			return fmt.Errorf("field without name")
+		}
+		if f.Offset != nil && *f.Offset < 0 {
+			return fmt.Errorf("field %s: offset must not be negative", f.Name)
+		}
+		if f.Type == "" && len(f.Fields) > 0 {
+			f.Type = "struct"
+		}
+		f.Type = strings.ToLower(f.Type)
+		switch f.Type {
+		case "struct":
+			if err := checkLayoutFields(f.Fields); err != nil {
+				return err
+			}
+		case "string", "bytes":
+		default:
+			size, ok := layoutTypeSizes[f.Type]
+			if !ok {
+				return fmt.Errorf("field %s: unknown type %q (u8-u64, i8-i64, f32, f64, string, bytes, struct)", f.Name, f.Type)
+			}
+			next := 0
+			for j := range f.Bits {
+				b := &f.Bits[j]
+				if b.Bits == 0 {
+					b.Bits = 1
+				}
+				if b.Bit == nil {
+					bit := next
+					b.Bit = &bit
+				}
+				next = *b.Bit + b.Bits
+				if next > size*8 || strings.HasPrefix(f.Type, "f") {
+					return fmt.Errorf("field %s: bit field %s does not fit in %s", f.Name, b.Name, f.Type)
+				}
+			}
+		}
+		switch strings.ToLower(f.Endian) {
+		case "":
+		case "big", "be":
+			f.Endian = "big"
+		case "little", "le":
+			f.Endian = "little"
+		default:
+			return fmt.Errorf("field %s: endian must be 'big' or 'little'", f.Name)
+		}
+	}
+	return nil
+}
+
+// layoutRow is one line of a decoded frame
+type layoutRow struct {
+	offset int
+	name   string
+	value  string
+}
+
+// layoutState is the frame being decoded or built with the integer values of its fields,
+// used by length, count and switch
+type layoutState struct {
+	data   []byte
+	pos    int
+	values map[string]int64
+	enums  map[string]map[string]string // Enums of the integer fields, for case names
+	rows   []layoutRow
+}
+
+// value returns the value of an earlier integer field by its name or full name
+func (s *layoutState) value(name string) (int64, error) {
+	if v, ok := s.values[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("field %s is not set before it is used", name)
+}
+
+func (s *layoutState) setValue(fullName, name string, v int64, enum map[string]string) {
+	s.values[fullName] = v
+	s.values[name] = v
+	s.enums[fullName] = enum
+	s.enums[name] = enum
+}
+
+// decodeLayout decodes a frame into a table of fields by the --layout file
+func decodeLayout(payload []byte, colored bool) (string, error) {
+	state := &layoutState{data: payload, values: map[string]int64{}, enums: map[string]map[string]string{}}
+	if err := state.decodeFields(activeLayout.Fields, ""); err != nil {
+		return "", err
+	}
+	if state.pos < len(payload) {
+		state.rows = append(state.rows, layoutRow{state.pos, "(extra)", hexBytes(payload[state.pos:])})
+	}
+
+	width := 0
+	for _, row := range state.rows {
+		width = max(width, len(row.name))
+	}
+	lines := make([]string, len(state.rows))
+	for i, row := range state.rows {
+		name := fmt.Sprintf("%-*s", width, row.name)
+		if colored {
+			name = colorCyan + name + colorReset
+		}
+		lines[i] = fmt.Sprintf("%04X  %s  %s", row.offset, name, row.value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (s *layoutState) decodeFields(fields []layoutField, prefix string) error {
+	for _, f := range fields {
+		if f.Switch != "" {
+			selected, err := s.selectCase(f)
+			if err != nil {
+				return err
+			}
+			if err := s.decodeFields(selected, prefix); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.Offset != nil {
+			if *f.Offset > len(s.data) {
+				return fmt.Errorf("field %s: offset %d is beyond the frame of %d bytes", f.Name, *f.Offset, len(s.data))
+			}
+			s.pos = *f.Offset
+		}
+		name := prefix + f.Name
+		if !f.Count.set {
+			if err := s.decodeField(f, name); err != nil {
+				return err
+			}
+			continue
+		}
+		count, err := s.size(f.Count)
+		if err != nil {
+			return fmt.Errorf("field %s: %v", name, err)
+		}
+		for i := 0; i < count; i++ {
+			if err := s.decodeField(f, fmt.Sprintf("%s[%d]", name, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectCase returns the fields of the case selected by the switch field
+func (s *layoutState) selectCase(f layoutField) ([]layoutField, error) {
+	v, err := s.value(f.Switch)
+	if err != nil {
+		return nil, err
+	}
+	for key, fields := range f.Cases {
+		if n, err := parseLayoutInt(key, s.enums[f.Switch]); err == nil && n == v {
+			return fields, nil
+		}
+	}
+	if f.Default != nil {
+		return f.Default, nil
+	}
+	return nil, fmt.Errorf("no case for %s = %d", f.Switch, v)
+}
+
+// size returns a fixed size or the value of the field holding it
+func (s *layoutState) size(size layoutSize) (int, error) {
+	if size.field == "" {
+		return size.n, nil
+	}
+	v, err := s.value(size.field)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > int64(len(s.data)) {
+		return 0, fmt.Errorf("%s = %d is out of range", size.field, v)
+	}
+	return int(v), nil
+}
+
+func (s *layoutState) decodeField(f layoutField, name string) error {
+	start := s.pos
+	need := func(n int) ([]byte, error) {
+		if n > len(s.data)-s.pos {
+			return nil, fmt.Errorf("frame too short for %s at offset %d (%d of %d bytes)", name, s.pos, len(s.data)-s.pos, n)
+		}
+		data := s.data[s.pos : s.pos+n]
+		s.pos += n
+		return data, nil
+	}
+
+	switch f.Type {
+	case "struct":
+		return s.decodeFields(f.Fields, name+".")
+	case "string", "bytes":
+		n := len(s.data) - s.pos
+		if f.Length.set {
+			var err error
+			if n, err = s.size(f.Length); err != nil {
+				return fmt.Errorf("field %s: %v", name, err)
+			}
+		}
+		data, err := need(n)
+		if err != nil {
+			return err
+		}
+		if f.Type == "bytes" {
+			s.rows = append(s.rows, layoutRow{start, name, hexBytes(data)})
+		} else {
+			s.rows = append(s.rows, layoutRow{start, name, strconv.Quote(strings.TrimRight(string(data), "\x00"))})
+		}
+		return nil
+	}
+
+	size := layoutTypeSizes[f.Type]
+	data, err := need(size)
+	if err != nil {
+		return err
+	}
+	var raw uint64
+	if s.littleEndian(f) {
+		for i := size - 1; i >= 0; i-- {
+			raw = raw<<8 | uint64(data[i])
+		}
+	} else {
+		for _, b := range data {
+			raw = raw<<8 | uint64(b)
+		}
+	}
+
+	switch f.Type {
+	case "f32":
+		s.rows = append(s.rows, layoutRow{start, name, strconv.FormatFloat(float64(math.Float32frombits(uint32(raw))), 'g', -1, 32)})
+		return nil
+	case "f64":
+		s.rows = append(s.rows, layoutRow{start, name, strconv.FormatFloat(math.Float64frombits(raw), 'g', -1, 64)})
+		return nil
+	}
+
+	v := int64(raw)
+	if f.Type[0] == 'i' {
+		shift := 64 - 8*uint(size)
+		v = int64(raw<<shift) >> shift // Sign extension
+	}
+	s.setValue(name, f.Name, v, f.Enum)
+	s.rows = append(s.rows, layoutRow{start, name, formatLayoutInt(v, raw, size, f.Format, f.Enum)})
+	for _, b := range f.Bits {
+		bits := int64(raw>>uint(*b.Bit)) & (1<<uint(b.Bits) - 1)
+		s.setValue(name+"."+b.Name, b.Name, bits, b.Enum)
+		s.rows = append(s.rows, layoutRow{start, name + "." + b.Name, formatLayoutInt(bits, uint64(bits), 0, "", b.Enum)})
+	}
+	return nil
+}
+
+func (s *layoutState) littleEndian(f layoutField) bool {
+	if f.Endian != "" {
+		return f.Endian == "little"
+	}
+	return activeLayout.Endian == "little"
+}
+
+// formatLayoutInt formats an integer with its enum name, in hex with format hex
+func formatLayoutInt(v int64, raw uint64, size int, format string, enum map[string]string) string {
+	text := strconv.FormatInt(v, 10)
+	if strings.EqualFold(format, "hex") && size > 0 {
+		text = fmt.Sprintf("0x%0*X", size*2, raw)
+	}
+	if len(enum) > 0 {
+		for key, name := range enum {
+			if n, err := parseLayoutInt(key, nil); err == nil && n == v {
+				return text + " (" + name + ")"
+			}
+		}
+		return text + " (unknown)"
+	}
+	return text
+}
+
+// hexBytes formats bytes like "01 02 03"
+func hexBytes(data []byte) string {
+	if len(data) == 0 {
+		return "(empty)"
+	}
+	return fmt.Sprintf("% X", data)
+}
+
+// parseLayoutInt parses a decimal or 0x hex integer, or a name of enum
+func parseLayoutInt(text string, enum map[string]string) (int64, error) {
+	for key, name := range enum {
+		if name == text {
+			text = key
+			break
+		}
+	}
+	text = strings.ReplaceAll(text, "_", "")
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		u, err := strconv.ParseUint(text[2:], 16, 64)
+		return int64(u), err
+	}
+	if strings.HasPrefix(text, "-") {
+		return strconv.ParseInt(text, 10, 64)
+	}
+	u, err := strconv.ParseUint(text, 10, 64)
+	return int64(u), err
+}
+
+// layoutPatch is a length or count field filled in after the data it describes is built
+type layoutPatch struct {
+	offset int
+	field  layoutField
+}
+
+// layoutBuilder builds a frame from key=value input
+type layoutBuilder struct {
+	layoutState
+	input   map[string]string
+	used    map[string]bool
+	offsets map[string]layoutPatch // Integer fields by name, to fill in lengths and counts
+}
+
+// buildLayoutFrame builds a frame by the --layout file from input like `type=2 temp=-12 "label=a b"`.
+// Fields not in the input get the value of the layout, or 0. Length and count fields are
+// calculated when they are not given.
+func buildLayoutFrame(input string) (string, error) {
+	if activeLayout == nil {
+		return "", fmt.Errorf("layout input requires --layout")
+	}
+	args, err := splitCommandArgs(input)
+	if err != nil {
+		return "", err
+	}
+	b := &layoutBuilder{
+		layoutState: layoutState{values: map[string]int64{}, enums: map[string]map[string]string{}},
+		input:       map[string]string{},
+		used:        map[string]bool{},
+		offsets:     map[string]layoutPatch{},
+	}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return "", fmt.Errorf("expected name=value: %s", arg)
+		}
+		b.input[key] = value
+	}
+	if err := b.buildFields(activeLayout.Fields, ""); err != nil {
+		return "", err
+	}
+
+	var unknown []string
+	for key := range b.input {
+		if !b.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("unknown field: %s", strings.Join(unknown, ", "))
+	}
+	return string(b.data), nil
+}
+
+// lookup returns the input value of a field by its full name or name
+func (b *layoutBuilder) lookup(fullName, name string) (string, bool) {
+	for _, key := range []string{fullName, name} {
+		if v, ok := b.input[key]; ok {
+			b.used[key] = true
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (b *layoutBuilder) buildFields(fields []layoutField, prefix string) error {
+	for _, f := range fields {
+		if f.Switch != "" {
+			selected, err := b.selectCase(f)
+			if err != nil {
+				return err
+			}
+			if err := b.buildFields(selected, prefix); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.Offset != nil {
+			if *f.Offset < len(b.data) {
+				return fmt.Errorf("field %s: offset %d overlaps the previous fields", f.Name, *f.Offset)
+			}
+			b.data = append(b.data, make([]byte, *f.Offset-len(b.data))...)
+		}
+		name := prefix + f.Name
+		if !f.Count.set {
+			if err := b.buildField(f, name, name, f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Arrays are given as a comma separated list, or element by element like values[0]=1
+		list, listed := b.lookup(name, f.Name)
+		var elements []string
+		if listed && list != "" {
+			elements = strings.Split(list, ",")
+		}
+		count := len(elements)
+		if f.Count.field == "" {
+			count = f.Count.n
+		} else if v, ok := b.values[f.Count.field]; ok && b.given(f.Count.field) {
+			count = int(v)
+		} else {
+			for b.hasInput(fmt.Sprintf("%s[%d]", name, count)) {
+				count++
+			}
+			if err := b.patch(f.Count.field, int64(count)); err != nil {
+				return err
+			}
+		}
+		if len(elements) > count {
+			return fmt.Errorf("field %s: %d values for %d elements", name, len(elements), count)
+		}
+		for i := 0; i < count; i++ {
+			element := fmt.Sprintf("%s[%d]", name, i)
+			if i < len(elements) {
+				b.input[element] = strings.TrimSpace(elements[i])
+			}
+			if err := b.buildField(f, element, element, element); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *layoutBuilder) hasInput(key string) bool {
+	_, ok := b.input[key]
+	return ok
+}
+
+// given reports whether an integer field was in the input or has a value in the layout
+func (b *layoutBuilder) given(name string) bool {
+	patch, ok := b.offsets[name]
+	return ok && patch.offset < 0
+}
+
+// patch writes a calculated length or count into its field, unless the input sets it
+func (b *layoutBuilder) patch(name string, v int64) error {
+	patch, ok := b.offsets[name]
+	if !ok {
+		return fmt.Errorf("field %s is not set before it is used", name)
+	}
+	if patch.offset < 0 {
+		return nil // Given in the input
+	}
+	b.values[name] = v
+	encoded := b.encodeInt(patch.field, uint64(v))
+	copy(b.data[patch.offset:], encoded)
+	return nil
+}
+
+func (b *layoutBuilder) buildField(f layoutField, fullName, key, name string) error {
+	text, ok := b.lookup(key, name)
+	if !ok && f.Value != "" {
+		text, ok = f.Value, true
+	}
+
+	switch f.Type {
+	case "struct":
+		return b.buildFields(f.Fields, fullName+".")
+	case "string", "bytes":
+		var data []byte
+		if f.Type == "bytes" {
+			clean := strings.NewReplacer(" ", "", ":", "", "0x", "").Replace(text)
+			decoded, err := hex.DecodeString(clean)
+			if err != nil {
+				return fmt.Errorf("field %s: bytes must be hex digits like 01ff", fullName)
+			}
+			data = decoded
+		} else {
+			data = []byte(text)
+		}
+		if f.Length.set && f.Length.field == "" {
+			if len(data) > f.Length.n {
+				return fmt.Errorf("field %s: %d bytes do not fit in %d", fullName, len(data), f.Length.n)
+			}
+			data = append(data, make([]byte, f.Length.n-len(data))...) // Padded with NUL
+		} else if f.Length.set {
+			if err := b.patch(f.Length.field, int64(len(data))); err != nil {
+				return err
+			}
+		}
+		b.data = append(b.data, data...)
+		return nil
+	}
+
+	if strings.HasPrefix(f.Type, "f") {
+		v := 0.0
+		if ok {
+			var err error
+			if v, err = strconv.ParseFloat(text, 64); err != nil {
+				return fmt.Errorf("field %s: invalid number %q", fullName, text)
+			}
+		}
+		if f.Type == "f32" {
+			b.data = append(b.data, b.encodeInt(f, uint64(math.Float32bits(float32(v))))...)
+		} else {
+			b.data = append(b.data, b.encodeInt(f, math.Float64bits(v))...)
+		}
+		return nil
+	}
+
+	var v int64
+	if ok {
+		var err error
+		if v, err = parseLayoutInt(text, f.Enum); err != nil {
+			return fmt.Errorf("field %s: invalid value %q", fullName, text)
+		}
+	}
+	for _, bits := range f.Bits {
+		bitText, bitOK := b.lookup(fullName+"."+bits.Name, bits.Name)
+		if !bitOK {
+			continue
+		}
+		bitValue, err := parseLayoutInt(bitText, bits.Enum)
+		if err != nil || bitValue < 0 || bitValue >= 1<<uint(bits.Bits) {
+			return fmt.Errorf("field %s.%s: invalid value %q for %d bits", fullName, bits.Name, bitText, bits.Bits)
+		}
+		mask := int64(1<<uint(bits.Bits)-1) << uint(*bits.Bit)
+		v = v&^mask | bitValue<<uint(*bits.Bit)
+		ok = true
+	}
+
+	size := layoutTypeSizes[f.Type]
+	if size < 8 {
+		min, max := int64(0), int64(1)<<(8*uint(size))-1
+		if f.Type[0] == 'i' {
+			min, max = -(max+1)/2, max/2
+		}
+		if v < min || v > max {
+			return fmt.Errorf("field %s: %d is out of range for %s", fullName, v, f.Type)
+		}
+	}
+	patch := layoutPatch{offset: len(b.data), field: f}
+	if ok {
+		patch.offset = -1 // Not calculated
+	}
+	b.offsets[fullName] = patch
+	b.offsets[name] = patch
+	b.setValue(fullName, name, v, f.Enum)
+	for _, bits := range f.Bits {
+		b.setValue(fullName+"."+bits.Name, bits.Name, v>>uint(*bits.Bit)&(1<<uint(bits.Bits)-1), bits.Enum)
+	}
+	b.data = append(b.data, b.encodeInt(f, uint64(v))...)
+	return nil
+}
+
+// encodeInt encodes the bits of an integer or float field in its byte order
+func (b *layoutBuilder) encodeInt(f layoutField, v uint64) []byte {
+	size := layoutTypeSizes[f.Type]
+	data := make([]byte, 8)
+	if b.littleEndian(f) {
+		binary.LittleEndian.PutUint64(data, v)
+		return data[:size]
+	}
+	binary.BigEndian.PutUint64(data, v)
+	return data[8-size:]
+}
+
+// layoutFieldNames returns the names of the top-level fields for the startup output
+func layoutFieldNames() string {
+	var names []string
+	for _, f := range activeLayout.Fields {
+		if f.Switch != "" {
+			names = append(names, "("+f.Switch+" cases)")
+		} else {
+			names = append(names, f.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
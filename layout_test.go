@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testLayout = `name: sensor frame
+fields:
+  - name: magic
+    type: u16
+    format: hex
+    value: 0xA55A
+  - name: type
+    type: u8
+    enum: {1: status, 2: reading, 3: text}
+  - name: flags
+    type: u8
+    bits:
+      - name: ready
+      - name: error
+      - name: mode
+        bit: 4
+        bits: 2
+        enum: {0: idle, 1: run, 2: cal}
+  - switch: type
+    cases:
+      status:
+        - name: uptime
+          type: u32
+          endian: little
+      2:
+        - name: count
+          type: u8
+        - name: temp
+          type: i16
+          count: count
+      3:
+        - name: len
+          type: u8
+        - name: label
+          type: string
+          length: len
+`
+
+// useLayout loads a layout file with the content and makes it the active layout
+func useLayout(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "layout.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := loadLayout(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeLayout = layout
+	t.Cleanup(func() { activeLayout = nil })
+}
+
+func TestDecodeLayout(t *testing.T) {
+	useLayout(t, testLayout)
+	data, _ := hex.DecodeString("a55a022103fff400fa0007ff")
+	got, err := decodeLayout(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"0000  magic        0xA55A",
+		"0002  type         2 (reading)",
+		"0003  flags        33",
+		"0003  flags.ready  1",
+		"0003  flags.error  0",
+		"0003  flags.mode   2 (cal)",
+		"0004  count        3",
+		"0005  temp[0]      -12",
+		"0007  temp[1]      250",
+		"0009  temp[2]      7",
+		"000B  (extra)      FF",
+	}, "\n")
+	if got != want {
+		t.Errorf("decodeLayout() =\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := decodeLayout(data[:6], false); err == nil {
+		t.Error("decodeLayout() of a short frame succeeded")
+	}
+}
+
+func TestBuildLayoutFrame(t *testing.T) {
+	useLayout(t, testLayout)
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`type=text "label=a b" ready=1 mode=cal`, "a55a032103612062"},
+		{`type=status uptime=1`, "a55a010001000000"},
+		{`magic=0x1234 type=2 count=1 temp=-1`, "1234020001ffff"},
+	}
+	for _, tt := range tests {
+		frame, err := buildLayoutFrame(tt.input)
+		if err != nil {
+			t.Errorf("buildLayoutFrame(%s): %v", tt.input, err)
+			continue
+		}
+		if got := hex.EncodeToString([]byte(frame)); got != tt.want {
+			t.Errorf("buildLayoutFrame(%s) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"type=text nope=1", "type=9", "magic"} {
+		if _, err := buildLayoutFrame(input); err == nil {
+			t.Errorf("buildLayoutFrame(%s) succeeded", input)
+		}
+	}
+}
+
+func TestLoadLayoutNegativeOffset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "layout.yaml")
+	content := "fields:\n  - name: id\n    type: u8\n    offset: -1\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loadLayout(path)
+	if err == nil || !strings.Contains(err.Error(), "field id: offset") {
+		t.Errorf("loadLayout() with a negative offset = %v, want an error for field id", err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/yutat23/coe/framing"
+)
+
+var lengthPrefix *framing.LengthPrefix // nil when --length-prefix is not given
+
+// stripLengthPrefix removes the length prefix from a received frame
+func stripLengthPrefix(frame []byte) []byte {
+	if lengthPrefix == nil {
+		return frame
+	}
+	return lengthPrefix.Decode(frame)
+}
+
+// lengthPrefixText returns the size and byte order for the startup output
+func lengthPrefixText() string {
+	if lengthPrefix.LittleEndian {
+		return fmt.Sprintf("%d bytes, little endian", lengthPrefix.Size)
+	}
+	return fmt.Sprintf("%d bytes, big endian", lengthPrefix.Size)
+}
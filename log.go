@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log output formats
+const (
+	logFormatText   = "text"   // Colored console lines
+	logFormatJSON   = "json"   // One JSON object per line
+	logFormatLogfmt = "logfmt" // One key=value line per event
+)
+
+var logFormat = logFormatText
+
+// logField is a key and value of a structured log line, kept in output order
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// parseLogFormat validates the value of --log-format
+func parseLogFormat(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case logFormatText:
+		return logFormatText, nil
+	case logFormatJSON:
+		return logFormatJSON, nil
+	case logFormatLogfmt:
+		return logFormatLogfmt, nil
+	}
+	return "", fmt.Errorf("log format must be 'text', 'json' or 'logfmt'")
+}
+
+// structuredLog reports whether events are printed as JSON or logfmt instead of text
+func structuredLog() bool {
+	return logFormat != logFormatText
+}
+
+// dataFields returns the structured log fields of a received or sent message
+func dataFields(now time.Time, event, peer, text string, data []byte, byTimeout bool) []logField {
+	fields := []logField{
+		{"ts", now.Format(time.RFC3339Nano)},
+		{"event", event},
+		{"peer", peer},
+	}
+	if event == eventSent {
+		fields = append(fields, logField{"dir", "out"})
+	} else {
+		fields = append(fields, logField{"dir", "in"})
+	}
+	fields = append(fields,
+		logField{"len", len(data)},
+		logField{"hex", fmt.Sprintf("%x", data)},
+		logField{"text", text},
+	)
+	if event != eventSent {
+		fields = append(fields, logField{"flushed_by_timeout", byTimeout})
+	}
+	return fields
+}
+
+// eventFields returns the structured log fields of a connect, disconnect or error event
+func eventFields(now time.Time, event, peer string, err error) []logField {
+	fields := []logField{
+		{"ts", now.Format(time.RFC3339Nano)},
+		{"event", event},
+	}
+	if peer != "" {
+		fields = append(fields, logField{"peer", peer})
+	}
+	if err != nil {
+		fields = append(fields, logField{"error", err.Error()})
+	}
+	return fields
+}
+
+// textOutput is where everything but the event lines of the console is printed, like startup
+// settings, command output and the prompt
+var textOutput io.Writer = os.Stdout
+
+// separateLogOutput keeps stdout for log lines with a structured log format by printing
+// everything else to stderr
+func separateLogOutput() {
+	if structuredLog() {
+		textOutput = os.Stderr
+	}
+}
+
+// printPrompt displays an input prompt
+func printPrompt(prompt string) {
+	fmt.Fprint(textOutput, prompt)
+}
+
+// clearPromptLine clears the prompt before an asynchronous output line (text format only)
+func clearPromptLine() {
+	if !structuredLog() {
+		fmt.Fprint(textOutput, "\r\033[K")
+	}
+}
+
+// formatStructured formats fields as one JSON or logfmt line
+func formatStructured(format string, fields []logField) string {
+	var line strings.Builder
+	if format == logFormatJSON {
+		line.WriteByte('{')
+		for i, field := range fields {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			key, _ := json.Marshal(field.key)
+			value, _ := json.Marshal(field.value)
+			line.Write(key)
+			line.WriteByte(':')
+			line.Write(value)
+		}
+		line.WriteByte('}')
+	} else {
+		for i, field := range fields {
+			if i > 0 {
+				line.WriteByte(' ')
+			}
+			line.WriteString(field.key)
+			line.WriteByte('=')
+			line.WriteString(logfmtValue(field.value))
+		}
+	}
+	return line.String()
+}
+
+// logfmtValue formats a value for logfmt, quoting it when needed
+func logfmtValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || !strconv.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestParseLogFormat(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"text", logFormatText, false},
+		{"JSON", logFormatJSON, false},
+		{"logfmt", logFormatLogfmt, false},
+		{"xml", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseLogFormat(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseLogFormat(%q) = %q, %v, want %q, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLogfmtValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"127.0.0.1:8080", "127.0.0.1:8080"},
+		{5, "5"},
+		{true, "true"},
+		{"", `""`},
+		{"hello world", `"hello world"`},
+		{"a=b", `"a=b"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{"line\n", `"line\n"`},
+	}
+	for _, tt := range tests {
+		if got := logfmtValue(tt.value); got != tt.want {
+			t.Errorf("logfmtValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log file settings from the command line
+var (
+	logFilePath    string
+	logFileFormat  = logFormatText
+	logMaxSize     int64         // Rotate when the file exceeds this size (0: no limit)
+	logRotateEvery time.Duration // Rotate when the file is older than this (0: never)
+	logMaxFiles    int           // Number of rotated files to keep (0: keep all)
+	logCompress    bool          // Gzip rotated files
+	logFile        *rotatingFile
+)
+
+// parseSize parses a size like "1048576", "512KB", "10MB" or "1GB"
+func parseSize(value string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSuffix(upper, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("size must be a positive number like 1048576, 512KB or 10MB")
+	}
+	return size * multiplier, nil
+}
+
+// parseRotateInterval parses "hourly", "daily" or a duration like "30m"
+func parseRotateInterval(value string) (time.Duration, error) {
+	switch strings.ToLower(value) {
+	case "hourly":
+		return time.Hour, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("rotate interval must be 'hourly', 'daily' or a duration like 30m")
+	}
+	return interval, nil
+}
+
+// openLogFile opens the log file given by --log-file, if any
+func openLogFile() error {
+	if logFilePath == "" {
+		return nil
+	}
+	file, err := openRotatingFile(logFilePath, logMaxSize, logRotateEvery, logMaxFiles, logCompress)
+	if err != nil {
+		return err
+	}
+	logFile = file
+	return nil
+}
+
+// rotatedTimeFormat is the timestamp suffix of rotated log files
+const rotatedTimeFormat = "20060102-150405.000"
+
+// rotatingFile is an append-only log file that is rotated by size and age.
+// Rotated files are renamed to <path>.<timestamp>, optionally gzipped.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	interval time.Duration
+	maxFiles int
+	compress bool
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, interval time.Duration, maxFiles int, compress bool) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, interval: interval, maxFiles: maxFiles, compress: compress}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+// WriteLine appends a line to the file, rotating it first when needed
+func (f *rotatingFile) WriteLine(line string) error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+
+	length := int64(len(line) + 1)
+	if (f.maxSize > 0 && f.size > 0 && f.size+length > f.maxSize) ||
+		(f.interval > 0 && time.Since(f.openedAt) >= f.interval) {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := io.WriteString(f.file, line+"\n")
+	f.size += int64(n)
+	return err
+}
+
+// rotate renames the current file and opens a new one.
+// When renaming or compressing fails, the error is reported on stderr and logging goes on
+// in the file at path.
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		err = f.moveAside()
+	}
+	if openErr := f.open(); openErr != nil {
+		fmt.Fprintf(os.Stderr, "Log file error: %v\n", openErr)
+		return openErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Log file rotation error: %v\n", err)
+		// Try again after another maxSize bytes or interval instead of on every line
+		f.size = 0
+		return nil
+	}
+	f.removeOldFiles()
+	return nil
+}
+
+// moveAside renames the closed file to <path>.<timestamp> and compresses it
+func (f *rotatingFile) moveAside() error {
+	rotated := f.path + "." + time.Now().Format(rotatedTimeFormat)
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	if f.compress {
+		return gzipFile(rotated)
+	}
+	return nil
+}
+
+// removeOldFiles deletes the oldest rotated files beyond maxFiles.
+// Only files named <path>.<timestamp> or <path>.<timestamp>.gz are rotated files.
+func (f *rotatingFile) removeOldFiles() {
+	if f.maxFiles <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return
+	}
+	prefix := filepath.Base(f.path) + "."
+	var rotated []string
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(rotatedTimeFormat, strings.TrimSuffix(suffix, ".gz")); err == nil {
+			rotated = append(rotated, entry.Name())
+		}
+	}
+	// Timestamp suffixes sort in chronological order
+	sort.Strings(rotated)
+	for len(rotated) > f.maxFiles {
+		os.Remove(filepath.Join(filepath.Dir(f.path), rotated[0]))
+		rotated = rotated[1:]
+	}
+}
+
+// Close closes the log file
+func (f *rotatingFile) Close() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// gzipFile compresses path to path.gz and removes the original
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	if _, err := io.Copy(writer, in); err != nil {
+		writer.Close()
+		out.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	in.Close()
+	return os.Remove(path)
+}
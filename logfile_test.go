@@ -0,0 +1,168 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveOldFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "coe.log")
+	names := []string{
+		"coe.log",
+		"coe.log.20250701-100000.000",
+		"coe.log.20250701-110000.000.gz",
+		"coe.log.20250701-120000.000",
+		"coe.log.bak",
+		"coe.log.old.gz",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := &rotatingFile{path: path, maxFiles: 1}
+	f.removeOldFiles()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	sort.Strings(got)
+	want := []string{"coe.log", "coe.log.20250701-120000.000", "coe.log.bak", "coe.log.old.gz"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+// Logging goes on in a new file when the file to rotate cannot be renamed
+func TestRotateFailureKeepsLogging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coe.log")
+	f, err := openRotatingFile(path, 10, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := f.WriteLine("first line"); err != nil {
+		t.Fatal(err)
+	}
+	// The file is removed by someone else, so renaming it fails
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WriteLine("second line"); err != nil {
+		t.Fatalf("WriteLine() = %v after a failed rotation", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second line\n" {
+		t.Errorf("log file = %q, want %q", data, "second line\n")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"1048576", 1048576, false},
+		{"512KB", 512 << 10, false},
+		{"10mb", 10 << 20, false},
+		{"1GB", 1 << 30, false},
+		{"100B", 100, false},
+		{"0", 0, true},
+		{"-1MB", 0, true},
+		{"MB", 0, true},
+		{"10TB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseRotateInterval(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"hourly", time.Hour, false},
+		{"Daily", 24 * time.Hour, false},
+		{"30m", 30 * time.Minute, false},
+		{"0s", 0, true},
+		{"weekly", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRotateInterval(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRotateInterval(%q) = %v, %v, want %v, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "coe.log")
+	f, err := openRotatingFile(path, 20, 0, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Each line fills the file, so every line after the first rotates it
+	for _, line := range []string{"first line", "second line", "third line"} {
+		if err := f.WriteLine(line); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond) // Rotated files are named by the millisecond
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "third line\n" {
+		t.Errorf("log file = %q, want %q", data, "third line\n")
+	}
+	rotated, err := filepath.Glob(path + ".*.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 1 {
+		t.Fatalf("rotated files = %v, want 1 kept", rotated)
+	}
+	file, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second line\n" {
+		t.Errorf("rotated file = %q, want %q", data, "second line\n")
+	}
+}
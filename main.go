@@ -2,14 +2,16 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
+
+	"github.com/yutat23/coe/tcp"
 )
 
 const version = "0.1.3"
@@ -41,10 +43,12 @@ func main() {
 		runServer()
 	case "-c", "--client":
 		runClient()
+	case "replay":
+		runReplay()
 	case "-h", "--help", "help":
 		fullUsage()
 	default:
-		fmt.Println("Error: Mode must be '-s'/'--server' or '-c'/'--client'")
+		fmt.Fprintln(textOutput, "Error: Mode must be '-s'/'--server', '-c'/'--client' or 'replay'")
 		shortUsage()
 	}
 }
@@ -62,7 +66,7 @@ func showLogo() error {
 		" Version " + version,
 	}
 	for _, line := range logoLines {
-		fmt.Println(line)
+		fmt.Fprintln(textOutput, line)
 	}
 
 	return nil
@@ -70,217 +74,373 @@ func showLogo() error {
 
 func shortUsage() {
 	showLogo()
-	fmt.Println("")
-	fmt.Println("USAGE")
-	fmt.Println("  Server mode:  coe -s <port> [options]")
-	fmt.Println("  Client mode:  coe -c <IP> <port> <terminator> [options]")
-	fmt.Println("")
-	fmt.Println("Use 'coe --help' for detailed options and examples.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "USAGE")
+	fmt.Fprintln(textOutput, "  Server mode:  coe -s <port> [options]")
+	fmt.Fprintln(textOutput, "  Client mode:  coe -c <IP> <port> <terminator> [options]")
+	fmt.Fprintln(textOutput, "  Profile:      coe -s|-c --profile <name> [options]")
+	fmt.Fprintln(textOutput, "  Replay:       coe replay <file> --as client|server <addr> [options]")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "Use 'coe --help' for detailed options and examples.")
 }
 
 func fullUsage() {
 	showLogo()
-	fmt.Println("")
-	fmt.Println("USAGE")
-	fmt.Println("  Server mode:   coe -s, --server <port> [terminator] [--no-echo] [--buffer-size <size>] [--color] [--no-color]")
-	fmt.Println("  Client mode    coe -c, --client <IP> <port> <terminator> [--buffer-size <size>] [--color] [--no-color]")
-	fmt.Println("")
-	fmt.Println("OPTIONS")
-	fmt.Println("Terminator: LF (0A) or CR (0D) - Default is LF")
-	fmt.Println("--no-echo        Disable echo back (Server mode only)")
-	fmt.Println("--buffer-size    Specify buffer size (bytes) - Default is 1024")
-	fmt.Println("--color          Enable colored output for better readability (Default: enabled)")
-	fmt.Println("--no-color       Disable colored output")
-	fmt.Println("")
-	fmt.Println("COLOR CODING (when --color is enabled)")
-	fmt.Println("  Blue    - Client IP addresses")
-	fmt.Println("  Green   - Received messages")
-	fmt.Println("  Red     - Sent messages")
-	fmt.Println("  Yellow  - Timestamps")
-	fmt.Println("  Cyan    - Byte counts")
-	fmt.Println("  Purple  - Hexadecimal data")
-	fmt.Println("")
-	fmt.Println("ESCAPE SEQUENCES (in messages)")
-	fmt.Println("  \\r     - CR (0x0D)")
-	fmt.Println("  \\n     - LF (0x0A)")
-	fmt.Println("  \\t     - TAB (0x09)")
-	fmt.Println("  \\\\     - Backslash (0x5C)")
-	fmt.Println("  \\xHH   - Arbitrary byte in hex (e.g., \\x1B for ESC)")
-	fmt.Println("")
-	fmt.Println("EXAMPLES")
-	fmt.Println("  coe -s 8080")
-	fmt.Println("  coe -s 8080 CR")
-	fmt.Println("  coe -s 8080 LF --no-echo")
-	fmt.Println("  coe -s 8080 --buffer-size 2048")
-	fmt.Println("  coe -s 8080 --color")
-	fmt.Println("  coe -s 8080 --no-color")
-	fmt.Println("  coe -c 127.0.0.1 8080 LF")
-	fmt.Println("  coe --client 192.168.1.100 8080 CR --buffer-size 512 --color")
-	fmt.Println("  coe --client 192.168.1.100 8080 CR --no-color")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "USAGE")
+	fmt.Fprintln(textOutput, "  Server mode:  coe "+usageLine(modeServer))
+	fmt.Fprintln(textOutput, "  Client mode:  coe "+usageLine(modeClient))
+	fmt.Fprintln(textOutput, "  Replay:       coe "+usageLine(modeReplay))
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "OPTIONS")
+	fmt.Fprintln(textOutput, "Terminator: LF (0A), CR (0D) or CRLF (0D 0A) - Default is LF")
+	fmt.Fprintln(textOutput, "--profile        Use the options of a named profile in the config file, overridden by options on the command line")
+	fmt.Fprintln(textOutput, "--config         Config file with the profiles - Default is coe/coe.yaml in the user config directory")
+	fmt.Fprintln(textOutput, "--no-echo        Disable echo back, or protocol responses with --protocol (Server mode only)")
+	fmt.Fprintln(textOutput, "--echo           Enable echo back, e.g. when a profile disables it (Server mode only, Default: enabled)")
+	fmt.Fprintln(textOutput, "--modbus-map     Register map of the Modbus server simulator, CSV or JSON (Server mode only)")
+	fmt.Fprintln(textOutput, "--http-response  File with the HTTP response sent for each request (Server mode only)")
+	fmt.Fprintln(textOutput, "--nmea-rate      Broadcast synthetic NMEA fixes to all clients, e.g. 1Hz or 200ms (Server mode only)")
+	fmt.Fprintln(textOutput, "--query-timeout  Wait for the response to a query with --protocol scpi - Default is 5s (Client mode only)")
+	fmt.Fprintln(textOutput, "--scpi-blocks    Save IEEE 488.2 block data of received SCPI messages to files in a directory (Client mode only)")
+	fmt.Fprintln(textOutput, "--buffer-size    Specify buffer size (bytes) - Default is 1024")
+	fmt.Fprintln(textOutput, "--color          Enable colored output for better readability (Default: enabled)")
+	fmt.Fprintln(textOutput, "--no-color       Disable colored output")
+	fmt.Fprintln(textOutput, "--record         Record all session events to a JSON Lines file")
+	fmt.Fprintln(textOutput, "--pcap           Write sent and received data as TCP packets to a PCAPNG file (for Wireshark)")
+	fmt.Fprintln(textOutput, "--display        Message display: line (Default), text, hexdump, both")
+	fmt.Fprintln(textOutput, "--encoding       Character encoding of messages: utf-8 (Default), shift_jis, euc-jp, utf-16le, utf-16be, latin-1")
+	fmt.Fprintln(textOutput, "                 utf-16 is little-endian; a byte order mark is not detected")
+	fmt.Fprintln(textOutput, "--checksum       Append and verify a checksum before the terminator: <algo>[:le|be|ascii-hex]")
+	fmt.Fprintln(textOutput, "                 Algorithms: lrc, xor, crc8, crc16-modbus, crc16-ccitt, crc32")
+	fmt.Fprintln(textOutput, "--protocol       Frame and decode messages by protocol instead of the terminator: modbus (Modbus TCP), mqtt, http, resp (Redis), scpi, nmea")
+	fmt.Fprintln(textOutput, "--length-prefix  Frame messages by a length field instead of the terminator: <1|2|4>[:be|le] - Default byte order is be")
+	fmt.Fprintln(textOutput, "--decode         Show message payloads decoded below each message line: json, msgpack (MessagePack), cbor, protobuf")
+	fmt.Fprintln(textOutput, "--jq             Show only selected fields with --decode, e.g. .id,.items[].name")
+	fmt.Fprintln(textOutput, "--proto          Descriptor set (protoc -o) to decode --decode protobuf with field names")
+	fmt.Fprintln(textOutput, "--proto-message  Message type of received frames in the --proto descriptor set, e.g. sensor.Reading")
+	fmt.Fprintln(textOutput, "--layout         Decode binary frames field by field as described in a YAML layout file")
+	fmt.Fprintln(textOutput, "--telnet         Answer Telnet option negotiation and remove IAC commands from messages")
+	fmt.Fprintln(textOutput, "--telnet-accept  Telnet options accepted with --telnet, others are refused - Default is echo,sga")
+	fmt.Fprintln(textOutput, "--log-format     Output format of message and connection lines: text (Default), json, logfmt")
+	fmt.Fprintln(textOutput, "--log-file       Also write message and connection lines to a log file (without colors)")
+	fmt.Fprintln(textOutput, "--log-file-format Format of the log file: text (Default), json, logfmt")
+	fmt.Fprintln(textOutput, "--log-max-size   Rotate the log file when it exceeds this size (e.g., 10MB)")
+	fmt.Fprintln(textOutput, "--log-rotate     Rotate the log file periodically: hourly, daily or a duration (e.g., 30m)")
+	fmt.Fprintln(textOutput, "--log-max-files  Number of rotated log files to keep - Default is all")
+	fmt.Fprintln(textOutput, "--log-compress   Gzip rotated log files")
+	fmt.Fprintln(textOutput, "--as             Replay as 'client' (connect to <IP:port>) or 'server' (listen on <port>)")
+	fmt.Fprintln(textOutput, "--speed          Replay timing: '1x' original (Default), '2x' twice as fast, 'max' no waiting")
+	fmt.Fprintln(textOutput, "--timeout        Time to wait for each recorded received frame (ms) - Default is 5000")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "COLOR CODING (when --color is enabled)")
+	fmt.Fprintln(textOutput, "  Blue    - Client IP addresses")
+	fmt.Fprintln(textOutput, "  Green   - Received messages")
+	fmt.Fprintln(textOutput, "  Red     - Sent messages")
+	fmt.Fprintln(textOutput, "  Yellow  - Timestamps")
+	fmt.Fprintln(textOutput, "  Cyan    - Byte counts")
+	fmt.Fprintln(textOutput, "  Purple  - Hexadecimal data")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "ESCAPE SEQUENCES (in messages)")
+	fmt.Fprintln(textOutput, "  \\r     - CR (0x0D)")
+	fmt.Fprintln(textOutput, "  \\n     - LF (0x0A)")
+	fmt.Fprintln(textOutput, "  \\t     - TAB (0x09)")
+	fmt.Fprintln(textOutput, "  \\0     - NUL (0x00)")
+	fmt.Fprintln(textOutput, "  \\e     - ESC (0x1B)")
+	fmt.Fprintln(textOutput, "  \\a     - BEL (0x07)")
+	fmt.Fprintln(textOutput, "  \\\\     - Backslash (0x5C)")
+	fmt.Fprintln(textOutput, "  \\$     - Dollar sign (0x24), not a placeholder")
+	fmt.Fprintln(textOutput, "  \\xHH   - Arbitrary byte in hex (e.g., \\x1B for ESC)")
+	fmt.Fprintln(textOutput, "  \\dNNN  - Arbitrary byte in decimal (e.g., \\d027 for ESC)")
+	fmt.Fprintln(textOutput, "  \\uXXXX - Unicode character as UTF-8 (e.g., \\u00E9)")
+	fmt.Fprintln(textOutput, "  {N}     - Repeat the preceding escape sequence N times (e.g., \\x00{64})")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "PLACEHOLDERS (in messages)")
+	fmt.Fprintln(textOutput, "  ${seq}       - Sequence number, incremented per message")
+	fmt.Fprintln(textOutput, "  ${ts}        - Unix time in seconds (${ts:ms} for milliseconds)")
+	fmt.Fprintln(textOutput, "  ${rand:N}    - N random bytes")
+	fmt.Fprintln(textOutput, "  ${file:path} - Contents of a file")
+	fmt.Fprintln(textOutput, "  ${crc16}     - CRC-16/MODBUS of the preceding bytes (low byte first)")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "INPUT MODES (server and client prompt)")
+	fmt.Fprintln(textOutput, "  #mode text|hex|b64|json|layout - Switch how typed messages are converted (Default: text)")
+	fmt.Fprintln(textOutput, "  text:<message>       - Text with escape sequences for one message")
+	fmt.Fprintln(textOutput, "  hex:<bytes>          - Hex bytes for one message (e.g., hex:02 41 03, hex:0x024103)")
+	fmt.Fprintln(textOutput, "  b64:<data>           - Base64 for one message (e.g., b64:AkED)")
+	fmt.Fprintln(textOutput, "  json:<value>         - JSON encoded by --decode for one message (e.g., json:{\"id\": 1})")
+	fmt.Fprintln(textOutput, "  layout:<fields>      - Frame built by --layout for one message (e.g., layout:type=2 temp=-12)")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "MODBUS TCP (--protocol modbus)")
+	fmt.Fprintln(textOutput, "  Messages are framed by the MBAP header and decoded below each message line.")
+	fmt.Fprintln(textOutput, "  Server mode answers requests (FC 1, 2, 3, 4, 5, 6, 15, 16) from the register map.")
+	fmt.Fprintln(textOutput, "  Server command: #reg get <register> [count], #reg set <register> <value...>, #reg list")
+	fmt.Fprintln(textOutput, "  Client command: #modbus <operation> <unit> <address> <count|value...>")
+	fmt.Fprintln(textOutput, "    read-coils, read-discrete, read-holding, read-input <unit> <address> <count>")
+	fmt.Fprintln(textOutput, "    write-coil <unit> <address> on|off, write-register <unit> <address> <value>")
+	fmt.Fprintln(textOutput, "    write-coils <unit> <address> <on|off...>, write-registers <unit> <address> <value...>")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "MQTT (--protocol mqtt)")
+	fmt.Fprintln(textOutput, "  MQTT 3.1.1/5.0 control packets are framed by the remaining length and shown")
+	fmt.Fprintln(textOutput, "  decoded in place of the message text (e.g., PUBLISH topic, QoS and payload).")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "HTTP (--protocol http)")
+	fmt.Fprintln(textOutput, "  HTTP/1.1 requests and responses are framed by headers, Content-Length and chunked")
+	fmt.Fprintln(textOutput, "  encoding, and shown as one entry with the start line, headers and body.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "REDIS RESP (--protocol resp)")
+	fmt.Fprintln(textOutput, "  RESP2/RESP3 values are framed by their lengths (bulk strings may contain CRLF) and")
+	fmt.Fprintln(textOutput, "  shown like redis-cli. In client mode, typed commands like SET key \"hello world\" are")
+	fmt.Fprintln(textOutput, "  sent as RESP arrays; a text:, hex: or b64: prefix sends the message as it is.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "SCPI (--protocol scpi)")
+	fmt.Fprintln(textOutput, "  Messages end with LF regardless of the terminator argument, except LF bytes inside")
+	fmt.Fprintln(textOutput, "  IEEE 488.2 blocks (#<n><length><data>), which are shown by their size.")
+	fmt.Fprintln(textOutput, "  In client mode, a query (a command ending in ?) waits for its response up to --query-timeout.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "NMEA 0183 (--protocol nmea)")
+	fmt.Fprintln(textOutput, "  Sentences end with CRLF. The *HH checksum is validated and GGA, RMC, VTG and GSV")
+	fmt.Fprintln(textOutput, "  sentences are decoded into labelled fields in the message line.")
+	fmt.Fprintln(textOutput, "  Server mode broadcasts synthetic fixes to all clients with --nmea-rate.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "JSON (--decode json)")
+	fmt.Fprintln(textOutput, "  Each message is shown indented (and colored) below the message line, or flagged with the")
+	fmt.Fprintln(textOutput, "  parse error. --jq selects fields by path (.key, [index], [] for all elements, comma for")
+	fmt.Fprintln(textOutput, "  several paths). Client mode does not send typed messages that are not valid JSON.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "MESSAGEPACK AND CBOR (--decode msgpack, --decode cbor)")
+	fmt.Fprintln(textOutput, "  Binary payloads are shown as JSON-like trees; bytes, tags and extensions in CBOR")
+	fmt.Fprintln(textOutput, "  diagnostic notation (h'0102', 1(1700000000)). Messages typed in json input mode are")
+	fmt.Fprintln(textOutput, "  encoded before they are sent. Use --length-prefix for payloads framed by their length.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "PROTOBUF (--decode protobuf)")
+	fmt.Fprintln(textOutput, "  Without a schema, fields are shown by number; length-delimited fields as text, nested")
+	fmt.Fprintln(textOutput, "  message or bytes, whichever fits. With a descriptor set from protoc -o (--proto) and the")
+	fmt.Fprintln(textOutput, "  message type (--proto-message), fields are shown by name, typed and with enum names.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "BINARY LAYOUTS (--layout)")
+	fmt.Fprintln(textOutput, "  The layout file describes the fields of a frame: integers (u8-u64, i8-i64), floats (f32,")
+	fmt.Fprintln(textOutput, "  f64), string, bytes and struct, with offsets, byte order, bit fields, enums, arrays and")
+	fmt.Fprintln(textOutput, "  sections selected by an earlier field (switch). Each frame is shown as a table. In layout")
+	fmt.Fprintln(textOutput, "  input mode, frames are built from name=value pairs like 'type=reading temp=-12'.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "TELNET (--telnet)")
+	fmt.Fprintln(textOutput, "  IAC commands are removed from received messages and shown as Telnet lines, like")
+	fmt.Fprintln(textOutput, "  \"Telnet: WILL ECHO -> DO ECHO\". Options in --telnet-accept (names or numbers, all or none)")
+	fmt.Fprintln(textOutput, "  are accepted, others refused. 0xFF bytes in sent messages are doubled (IAC IAC).")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "PROFILES (--profile)")
+	fmt.Fprintln(textOutput, "  A config file keeps named device setups. Keys are option names without --, flags are")
+	fmt.Fprintln(textOutput, "  true or false, and host, port and terminator set the arguments:")
+	fmt.Fprintln(textOutput, "    profiles:")
+	fmt.Fprintln(textOutput, "      plc-sim:")
+	fmt.Fprintln(textOutput, "        port: 502")
+	fmt.Fprintln(textOutput, "        protocol: modbus")
+	fmt.Fprintln(textOutput, "        modbus-map: registers.csv")
+	fmt.Fprintln(textOutput, "        no-color: true")
+	fmt.Fprintln(textOutput, "  Arguments and options on the command line override the profile.")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "EXAMPLES")
+	fmt.Fprintln(textOutput, "  coe -s 8080")
+	fmt.Fprintln(textOutput, "  coe -s 8080 CR")
+	fmt.Fprintln(textOutput, "  coe -s 8080 LF --no-echo")
+	fmt.Fprintln(textOutput, "  coe -s 8080 --buffer-size 2048")
+	fmt.Fprintln(textOutput, "  coe -s 8080 --color")
+	fmt.Fprintln(textOutput, "  coe -s 8080 --no-color")
+	fmt.Fprintln(textOutput, "  coe -c 127.0.0.1 8080 LF")
+	fmt.Fprintln(textOutput, "  coe --client 192.168.1.100 8080 CR --buffer-size 512 --color")
+	fmt.Fprintln(textOutput, "  coe --client 192.168.1.100 8080 CR --no-color")
+	fmt.Fprintln(textOutput, "  coe -s 8080 --record session.jsonl")
+	fmt.Fprintln(textOutput, "  coe -c 127.0.0.1 8080 LF --pcap session.pcapng")
+	fmt.Fprintln(textOutput, "  coe -s 8080 --log-format json")
+	fmt.Fprintln(textOutput, "  coe -c 127.0.0.1 8080 LF --display hexdump")
+	fmt.Fprintln(textOutput, "  coe -c 192.168.1.100 8080 CR --encoding shift_jis")
+	fmt.Fprintln(textOutput, "  coe -s 8080 --log-file coe.log --log-file-format json --log-max-size 10MB --log-compress")
+	fmt.Fprintln(textOutput, "  coe -c 192.168.1.100 8080 CR --checksum crc16-modbus")
+	fmt.Fprintln(textOutput, "  coe -c 192.168.1.100 502 LF --protocol modbus")
+	fmt.Fprintln(textOutput, "  coe -s 502 --protocol modbus --modbus-map registers.csv")
+	fmt.Fprintln(textOutput, "  coe -s 1883 --protocol mqtt --no-color")
+	fmt.Fprintln(textOutput, "  coe -s 8080 --protocol http --http-response response.http")
+	fmt.Fprintln(textOutput, "  coe -c 127.0.0.1 6379 LF --protocol resp")
+	fmt.Fprintln(textOutput, "  coe -c 192.168.1.50 5025 LF --protocol scpi --scpi-blocks waveforms")
+	fmt.Fprintln(textOutput, "  coe -s 10110 --protocol nmea --nmea-rate 1Hz")
+	fmt.Fprintln(textOutput, "  coe -c 127.0.0.1 9000 LF --decode json --jq .id,.status")
+	fmt.Fprintln(textOutput, "  coe -s 9100 --length-prefix 4 --decode msgpack")
+	fmt.Fprintln(textOutput, "  coe -c 127.0.0.1 9200 LF --length-prefix 4 --decode protobuf --proto sensor.pb --proto-message sensor.Reading")
+	fmt.Fprintln(textOutput, "  coe -s 9300 --length-prefix 2 --layout frame.yaml")
+	fmt.Fprintln(textOutput, "  coe -c 192.168.1.1 23 CR --telnet --telnet-accept echo,sga,naws")
+	fmt.Fprintln(textOutput, "  coe -s --profile plc-sim")
+	fmt.Fprintln(textOutput, "  coe -c --profile scope --config lab.yaml --record scope.jsonl")
+	fmt.Fprintln(textOutput, "  coe replay session.jsonl --as client 127.0.0.1:8080 --speed 2x")
 }
 
 func runServer() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: -s, --server <port> [terminator] [--no-echo] [--buffer-size <size>] [--color] [--no-color]")
+	o, err := parseOptions(modeServer, os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(textOutput, "Error:", err)
 		return
 	}
-
-	port := os.Args[2]
-	terminator := "LF"  // Default
-	echoEnabled := true // Default echo enabled
-	bufferSize := 1024  // Default buffer size
-	colorEnabled = true // Default color enabled
-
-	// Parse arguments
-	for i := 3; i < len(os.Args); i++ {
-		arg := os.Args[i]
-		if arg == "--no-echo" {
-			echoEnabled = false
-		} else if arg == "--buffer-size" {
-			if i+1 < len(os.Args) {
-				if size, err := fmt.Sscanf(os.Args[i+1], "%d", &bufferSize); err != nil || size != 1 {
-					fmt.Println("Error: Buffer size must be a number")
-					return
-				}
-				if bufferSize <= 0 {
-					fmt.Println("Error: Buffer size must be 1 or greater")
-					return
-				}
-				i++ // Skip next argument
-			} else {
-				fmt.Println("Error: Buffer size must be specified after --buffer-size")
-				return
-			}
-		} else if arg == "--color" {
-			colorEnabled = true
-		} else if arg == "--no-color" {
-			colorEnabled = false
-		} else if terminator == "LF" && (arg == "LF" || arg == "CR") {
-			terminator = arg
+	separateLogOutput()
+	if o.port == "" {
+		fmt.Fprintln(textOutput, "Usage:", usageLine(modeServer))
+		return
+	}
+	serverSide = true
+	if err := o.setup(); err != nil {
+		fmt.Fprintln(textOutput, "Error:", err)
+		return
+	}
+	terminatorBytes := o.terminatorBytes
+
+	// The sinks are set up when the record and log files are open
+	var sinks tcp.Sinks
+	sink := tcp.SinkFunc(func(e tcp.Event) { sinks.HandleEvent(e) })
+	config := tcp.Config{
+		Codec:      newCodec(terminatorBytes),
+		BufferSize: o.bufferSize,
+		Sink:       sink,
+		WrapConn:   wrapConn(sink),
+	}
+	if o.echo {
+		config.Respond = func(addr string, frame []byte) []byte {
+			return serverResponse(frame, terminatorBytes)
 		}
 	}
-
-	// Set terminator
-	var terminatorBytes []byte
-	switch strings.ToUpper(terminator) {
-	case "LF":
-		terminatorBytes = []byte{0x0A} // LF
-	case "CR":
-		terminatorBytes = []byte{0x0D} // CR
-	default:
-		fmt.Println("Error: Terminator must be 'LF' or 'CR'")
+	server := tcp.NewServer(":"+o.port, config)
+	if err := server.Listen(); err != nil {
+		fmt.Fprintln(textOutput, "Server startup error:", err)
 		return
 	}
 
-	listener, err := net.Listen("tcp", ":"+port)
-	if err != nil {
-		fmt.Println("Server startup error:", err)
+	if o.recordPath != "" {
+		var err error
+		recorder, err = openRecorder(o.recordPath, modeServer, server.Addr().String(), o.terminator)
+		if err != nil {
+			fmt.Fprintln(textOutput, "Record file error:", err)
+			return
+		}
+		defer recorder.Close()
+	}
+	if o.pcapPath != "" {
+		var err error
+		capture, err = openPcap(o.pcapPath)
+		if err != nil {
+			fmt.Fprintln(textOutput, "PCAP file error:", err)
+			return
+		}
+		defer capture.Close()
+	}
+	if err := openLogFile(); err != nil {
+		fmt.Fprintln(textOutput, "Log file error:", err)
 		return
 	}
-	defer listener.Close()
-
-	fmt.Printf("Server started on port: %s\n", port)
-	fmt.Printf("Terminator: %s (0x%02X)\n", terminator, terminatorBytes[0])
-	fmt.Printf("Buffer size: %d bytes\n", bufferSize)
-	if echoEnabled {
-		fmt.Println("Echo back: Enabled")
+	defer logFile.Close()
+	sinks = newSinks(&consoleSink{lineFormat: lineFormat{terminator: terminatorBytes, peerLines: true}, out: os.Stdout, hideEmpty: true})
+
+	fmt.Fprintf(textOutput, "Server started on port: %s\n", o.port)
+	o.printSettings()
+	if activeProtocol == modbusProtocol {
+		if !o.echo {
+			fmt.Fprintln(textOutput, "Responses: Disabled")
+		} else if o.modbusMapPath != "" {
+			fmt.Fprintf(textOutput, "Responses: %s simulator (register map: %s)\n", protocolName(), o.modbusMapPath)
+		} else {
+			fmt.Fprintf(textOutput, "Responses: %s simulator\n", protocolName())
+		}
+	} else if httpResponse != nil {
+		if !o.echo {
+			fmt.Fprintln(textOutput, "Responses: Disabled")
+		} else {
+			fmt.Fprintf(textOutput, "Responses: %s\n", o.httpResponsePath)
+		}
+	} else if o.echo && (terminatorBytes != nil || lengthPrefix != nil) {
+		fmt.Fprintln(textOutput, "Echo back: Enabled")
 	} else {
-		fmt.Println("Echo back: Disabled")
+		fmt.Fprintln(textOutput, "Echo back: Disabled")
 	}
-	fmt.Println("Waiting for client connections...")
-	fmt.Println("Commands: '#send <clientIP> <message>' to send to specific client")
-	fmt.Println("Commands: '#broadcast <message>' to send to all clients")
-	fmt.Println("Commands: '#list' to show connected clients")
-	fmt.Println("Commands: '#help' to show available commands")
-	fmt.Println("Commands: '#quit, #exit: Shut down the server")
-	fmt.Println("----------------------------------------")
-
-	// Manage connected clients
-	var clients sync.Map
-	var clientsMutex sync.RWMutex
+	if o.nmeaPeriod > 0 {
+		fmt.Fprintf(textOutput, "NMEA generator: GGA, RMC, VTG, GSV every %s\n", o.nmeaPeriod)
+	}
+	o.printOutputs()
+	fmt.Fprintln(textOutput, "Waiting for client connections...")
+	fmt.Fprintln(textOutput, "Commands: '#send <clientIP> <message>' to send to specific client")
+	fmt.Fprintln(textOutput, "Commands: '#broadcast <message>' to send to all clients")
+	fmt.Fprintln(textOutput, "Commands: '#list' to show connected clients")
+	if activeProtocol == modbusProtocol {
+		fmt.Fprintln(textOutput, "Commands: '#reg get|set|list' to inspect and change Modbus registers")
+	}
+	fmt.Fprintln(textOutput, "Commands: '#help' to show available commands")
+	fmt.Fprintln(textOutput, "Commands: '#quit, #exit: Shut down the server")
+	fmt.Fprintln(textOutput, "----------------------------------------")
 
 	// Handle Ctrl-C (SIGINT) signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		fmt.Println("\nShutting down server...")
+		fmt.Fprintln(textOutput, "\nShutting down server...")
 		// Close all client connections
-		clientsMutex.Lock()
-		clients.Range(func(key, value interface{}) bool {
-			conn := value.(net.Conn)
-			conn.Close()
-			fmt.Printf("Disconnected client: %s\n", key)
-			return true
-		})
-		clientsMutex.Unlock()
-		listener.Close()
+		server.Stop()
+		recorder.Close()
+		capture.Close()
+		logFile.Close()
 		os.Exit(0)
 	}()
 
 	// Client connection handling
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				fmt.Println("Connection error:", err)
-				continue
-			}
-
-			clientAddr := conn.RemoteAddr().String()
-			fmt.Printf("Client connected: %s\n", clientAddr)
-
-			// Add to client list
-			clientsMutex.Lock()
-			clients.Store(clientAddr, conn)
-			clientsMutex.Unlock()
-
-			// Handle each client in separate goroutine
-			go func() {
-				handleClient(conn, terminatorBytes, echoEnabled, &clients, &clientsMutex, bufferSize)
+	defer server.Stop()
+	if err := server.Start(); err != nil {
+		fmt.Fprintln(textOutput, "Server startup error:", err)
+		return
+	}
 
-				// Remove from client list when disconnected
-				clientsMutex.Lock()
-				clients.Delete(clientAddr)
-				clientsMutex.Unlock()
-			}()
-		}
-	}()
+	// Broadcast synthetic NMEA fixes
+	if o.nmeaPeriod > 0 {
+		go generateNMEA(server, o.nmeaPeriod)
+	}
 
 	// Command input handling
 	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Print("Command> ")
+	printPrompt(inputPrompt("Command"))
 	for scanner.Scan() {
 		command := scanner.Text()
 		if command == "" {
-			fmt.Print("Command> ")
+			printPrompt(inputPrompt("Command"))
 			continue
 		}
 
 		parts := strings.Fields(command)
 		if len(parts) == 0 {
-			fmt.Print("Command> ")
+			printPrompt(inputPrompt("Command"))
 			continue
 		}
 
 		switch parts[0] {
 		case "#send":
 			if len(parts) < 3 {
-				fmt.Println("Usage: send <clientIP> <message>")
+				fmt.Fprintln(textOutput, "Usage: send <clientIP> <message>")
 			} else {
 				clientIP := parts[1]
 				message := strings.Join(parts[2:], " ")
-				sendToClient(&clients, &clientsMutex, clientIP, message, terminatorBytes)
+				sendToClient(server, clientIP, message)
 			}
 		case "#broadcast":
 			if len(parts) < 2 {
-				fmt.Println("Usage: broadcast <message>")
+				fmt.Fprintln(textOutput, "Usage: broadcast <message>")
 			} else {
 				message := strings.Join(parts[1:], " ")
-				broadcastToAll(&clients, &clientsMutex, message, terminatorBytes)
+				count, err := broadcastToAll(server, message)
+				if err != nil {
+					fmt.Fprintln(textOutput, "Error:", err)
+				} else {
+					fmt.Fprintf(textOutput, "Broadcast completed: sent to %d clients\n", count)
+				}
 			}
 		case "#list":
-			liscoeents(&clients, &clientsMutex)
+			liscoeents(server)
+		case "#mode":
+			handleModeCommand(parts[1:])
+		case "#reg":
+			handleRegCommand(parts[1:])
 		case "#help":
 			if len(parts) > 1 && parts[1] == "program" {
 				fullUsage()
@@ -288,556 +448,313 @@ func runServer() {
 				printServerHelp()
 			}
 		case "#quit", "#exit":
-			fmt.Println("Shutting down server...")
+			fmt.Fprintln(textOutput, "Shutting down server...")
 			return
 		default:
-			fmt.Printf("Unknown command: %s\n", parts[0])
-			fmt.Println("Available commands: send, broadcast, list, help, quit")
+			fmt.Fprintf(textOutput, "Unknown command: %s\n", parts[0])
+			fmt.Fprintln(textOutput, "Available commands: send, broadcast, list, mode, reg, help, quit")
 		}
 
-		fmt.Print("Command> ")
+		printPrompt(inputPrompt("Command"))
 	}
 }
 
-func handleClient(conn net.Conn, terminatorBytes []byte, echoEnabled bool, clients *sync.Map, clientsMutex *sync.RWMutex, bufferSize int) {
-	defer conn.Close()
-	defer fmt.Printf("Client disconnected: %s\n", conn.RemoteAddr().String())
-
-	// Receive with specified buffer size
-	buffer := make([]byte, bufferSize)
-	var messageBuffer strings.Builder
-	const timeoutDuration = 100 * time.Millisecond // Timeout for incomplete messages
-
-	for {
-		// Set read deadline to detect when data stops coming
-		conn.SetReadDeadline(time.Now().Add(timeoutDuration))
-		n, err := conn.Read(buffer)
-
-		// Check if it's a timeout error
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			// Timeout occurred - display buffered data if any
-			message := messageBuffer.String()
-			if message != "" {
-				timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-				messageBytes := []byte(message)
-				hexData := fmt.Sprintf("%x", messageBytes)
-				if colorEnabled {
-					fmt.Printf("%s[%s]%s %s%s%s | %sReceived:%s %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-						colorBlue, conn.RemoteAddr().String(), colorReset,
-						colorYellow, timestamp, colorReset,
-						colorGreen, colorReset, message,
-						colorCyan, len(messageBytes), colorReset,
-						colorPurple, hexData, colorReset)
-				} else {
-					fmt.Printf("[%s] %s | Received: %s (Bytes: %d, HEX: %s)\n",
-						conn.RemoteAddr().String(), timestamp, message, len(messageBytes), hexData)
-				}
-				messageBuffer.Reset()
-			}
-			continue // Continue reading
-		}
-
-		if err != nil {
-			// Display any remaining buffered data before breaking
-			message := messageBuffer.String()
-			if message != "" {
-				timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-				messageBytes := []byte(message)
-				hexData := fmt.Sprintf("%x", messageBytes)
-				if colorEnabled {
-					fmt.Printf("%s[%s]%s %s%s%s | %sReceived:%s %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-						colorBlue, conn.RemoteAddr().String(), colorReset,
-						colorYellow, timestamp, colorReset,
-						colorGreen, colorReset, message,
-						colorCyan, len(messageBytes), colorReset,
-						colorPurple, hexData, colorReset)
-				} else {
-					fmt.Printf("[%s] %s | Received: %s (Bytes: %d, HEX: %s)\n",
-						conn.RemoteAddr().String(), timestamp, message, len(messageBytes), hexData)
-				}
-			}
-			fmt.Printf("[%s] Receive error: %v\n", conn.RemoteAddr().String(), err)
-			break
-		}
-
-		if n == 0 {
-			// Display any remaining buffered data when connection is closed gracefully
-			message := messageBuffer.String()
-			if message != "" {
-				timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-				messageBytes := []byte(message)
-				hexData := fmt.Sprintf("%x", messageBytes)
-				if colorEnabled {
-					fmt.Printf("%s[%s]%s %s%s%s | %sReceived:%s %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-						colorBlue, conn.RemoteAddr().String(), colorReset,
-						colorYellow, timestamp, colorReset,
-						colorGreen, colorReset, message,
-						colorCyan, len(messageBytes), colorReset,
-						colorPurple, hexData, colorReset)
-				} else {
-					fmt.Printf("[%s] %s | Received: %s (Bytes: %d, HEX: %s)\n",
-						conn.RemoteAddr().String(), timestamp, message, len(messageBytes), hexData)
-				}
-				messageBuffer.Reset()
-			}
-			continue
-		}
-
-		// Debug: Show received data details
-		// fmt.Printf("[%s] Debug: Received bytes=%d, data=%x\n", conn.RemoteAddr().String(), n, buffer[:n])
-
-		// Process received data
-		data := buffer[:n]
-		for _, b := range data {
-			if b == terminatorBytes[0] {
-				// Display message when terminator is found
-				message := messageBuffer.String()
-				if message != "" {
-					timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-					messageBytes := []byte(message)
-					hexData := fmt.Sprintf("%x", messageBytes)
-					if colorEnabled {
-						fmt.Printf("%s[%s]%s %s%s%s | %sReceived:%s %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-							colorBlue, conn.RemoteAddr().String(), colorReset,
-							colorYellow, timestamp, colorReset,
-							colorGreen, colorReset, message,
-							colorCyan, len(messageBytes), colorReset,
-							colorPurple, hexData, colorReset)
-					} else {
-						fmt.Printf("[%s] %s | Received: %s (Bytes: %d, HEX: %s)\n",
-							conn.RemoteAddr().String(), timestamp, message, len(messageBytes), hexData)
-					}
-
-					// Echo back functionality (optional)
-					if echoEnabled {
-						response := message + string(terminatorBytes)
-						_, err := conn.Write([]byte(response))
-						if err != nil {
-							fmt.Printf("[%s] Send error: %v\n", conn.RemoteAddr().String(), err)
-							return
-						}
-						timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-						responseBytes := []byte(response)
-						hexData := fmt.Sprintf("%x", responseBytes)
-						if colorEnabled {
-							fmt.Printf("%s[%s]%s %s%s%s | %sSent:%s %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-								colorBlue, conn.RemoteAddr().String(), colorReset,
-								colorYellow, timestamp, colorReset,
-								colorRed, colorReset, message,
-								colorCyan, len(responseBytes), colorReset,
-								colorPurple, hexData, colorReset)
-						} else {
-							fmt.Printf("[%s] %s | Sent: %s (Bytes: %d, HEX: %s)\n",
-								conn.RemoteAddr().String(), timestamp, message, len(responseBytes), hexData)
-						}
-					}
-				}
-				messageBuffer.Reset()
-			} else {
-				messageBuffer.WriteByte(b)
-			}
-		}
+// serverResponse returns the answer to a received frame: the response of the protocol,
+// or the message to echo back. The terminator or length prefix is added by the codec.
+func serverResponse(frame []byte, terminatorBytes []byte) []byte {
+	message := strings.TrimSuffix(string(frame), string(terminatorBytes))
+	switch {
+	case message == "":
+		return nil
+	case activeProtocol != nil && activeProtocol.respond != nil:
+		// The protocol answers instead of echo back
+		return activeProtocol.respond(frame)
+	case lengthPrefix != nil:
+		// Echo back the payload with a new checksum
+		return []byte(checksum.appendTo(checksum.strip(string(lengthPrefix.Decode(frame)))))
+	case len(message) < len(frame):
+		// Echo back functionality (optional) for terminated messages
+		// The received checksum is replaced with one calculated for the echoed message
+		return []byte(checksum.appendTo(checksum.strip(message)))
 	}
+	return nil
 }
 
-func sendToClient(clients *sync.Map, clientsMutex *sync.RWMutex, clientIP string, message string, terminatorBytes []byte) {
-	clientsMutex.RLock()
-	defer clientsMutex.RUnlock()
-
-	// Process escape sequences in message
-	processedMessage := processEscapeSequences(message)
+func sendToClient(server *tcp.Server, clientIP string, message string) {
+	// Convert message by input mode (escape sequences, hex, base64 or JSON)
+	processedMessage, err := convertInput(message)
+	if err != nil {
+		fmt.Fprintln(textOutput, "Error:", err)
+		return
+	}
 
-	if conn, ok := clients.Load(clientIP); ok {
-		response := processedMessage + string(terminatorBytes)
-		_, err := conn.(net.Conn).Write([]byte(response))
-		if err != nil {
-			fmt.Printf("Send error [%s]: %v\n", clientIP, err)
-		} else {
-			timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-			responseBytes := []byte(response)
-			hexData := fmt.Sprintf("%x", responseBytes)
-			// Display original message (with escape sequences) for readability
-			if colorEnabled {
-				fmt.Printf("%s[%s]%s %s%s%s | %sSent:%s %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-					colorBlue, clientIP, colorReset,
-					colorYellow, timestamp, colorReset,
-					colorRed, colorReset, message,
-					colorCyan, len(responseBytes), colorReset,
-					colorPurple, hexData, colorReset)
-			} else {
-				fmt.Printf("[%s] %s | Sent: %s (Bytes: %d, HEX: %s)\n",
-					clientIP, timestamp, message, len(responseBytes), hexData)
-			}
-		}
-	} else {
-		fmt.Printf("Client not found: %s\n", clientIP)
+	// Display original message (with escape sequences) for readability
+	err = server.SendText(clientIP, []byte(checksum.appendTo(processedMessage)), message)
+	var writeErr *net.OpError
+	if errors.Is(err, tcp.ErrNoClient) {
+		fmt.Fprintf(textOutput, "Client not found: %s\n", clientIP)
+	} else if err != nil && !errors.As(err, &writeErr) {
+		fmt.Fprintln(textOutput, "Error:", err) // Write errors are shown by the Error event
 	}
 }
 
-func broadcastToAll(clients *sync.Map, clientsMutex *sync.RWMutex, message string, terminatorBytes []byte) {
-	clientsMutex.RLock()
-	defer clientsMutex.RUnlock()
-
-	// Process escape sequences in message
-	processedMessage := processEscapeSequences(message)
-
-	count := 0
-	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-	response := processedMessage + string(terminatorBytes)
-	responseBytes := []byte(response)
-	hexData := fmt.Sprintf("%x", responseBytes)
-
-	clients.Range(func(key, value interface{}) bool {
-		conn := value.(net.Conn)
-		_, err := conn.Write([]byte(response))
-		if err != nil {
-			fmt.Printf("Send error [%s]: %v\n", key, err)
-		} else {
-			if colorEnabled {
-				fmt.Printf("%s[%s]%s %s%s%s | %sSent:%s %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-					colorBlue, key, colorReset,
-					colorYellow, timestamp, colorReset,
-					colorRed, colorReset, message,
-					colorCyan, len(responseBytes), colorReset,
-					colorPurple, hexData, colorReset)
-			} else {
-				fmt.Printf("[%s] %s | Sent: %s (Bytes: %d, HEX: %s)\n",
-					key, timestamp, message, len(responseBytes), hexData)
-			}
-			count++
-		}
-		return true
-	})
-	fmt.Printf("Broadcast completed: sent to %d clients\n", count)
+// broadcastToAll sends a message to all clients and returns the number of clients it was sent to
+func broadcastToAll(server *tcp.Server, message string) (int, error) {
+	// Convert message by input mode (escape sequences, hex, base64 or JSON)
+	processedMessage, err := convertInput(message)
+	if err != nil {
+		return 0, err
+	}
+	return server.BroadcastText([]byte(checksum.appendTo(processedMessage)), message)
 }
 
-func liscoeents(clients *sync.Map, clientsMutex *sync.RWMutex) {
-	clientsMutex.RLock()
-	defer clientsMutex.RUnlock()
-
-	count := 0
-	fmt.Println("Connected clients:")
-	clients.Range(func(key, value interface{}) bool {
-		fmt.Printf("  %s\n", key)
-		count++
-		return true
-	})
-	if count == 0 {
-		fmt.Println("  No clients connected")
+func liscoeents(server *tcp.Server) {
+	clients := server.Clients()
+	fmt.Fprintln(textOutput, "Connected clients:")
+	for _, addr := range clients {
+		fmt.Fprintf(textOutput, "  %s\n", addr)
+	}
+	if len(clients) == 0 {
+		fmt.Fprintln(textOutput, "  No clients connected")
 	} else {
-		fmt.Printf("Total: %d clients\n", count)
+		fmt.Fprintf(textOutput, "Total: %d clients\n", len(clients))
 	}
 }
 
 func printServerHelp() {
-	fmt.Println("Server mode commands:")
-	fmt.Println("  #send <clientIP> <message>: Send a message to a specific client")
-	fmt.Println("  #broadcast <message>: Send a message to all connected clients")
-	fmt.Println("  #list: Show all connected clients")
-	fmt.Println("  #help: Show this help message")
-	fmt.Println("  #quit, #exit: Shut down the server")
-	fmt.Println("")
-	fmt.Println("Escape sequences in messages:")
-	fmt.Println("  \\r  → CR (0x0D)")
-	fmt.Println("  \\n  → LF (0x0A)")
-	fmt.Println("  \\t  → TAB (0x09)")
-	fmt.Println("  \\\\  → Backslash (0x5C)")
-	fmt.Println("  \\xHH → Arbitrary byte (e.g., \\x1B for ESC)")
-	fmt.Println("")
-	fmt.Println("Program help: Type 'help program' for full program usage")
-}
-
-// processEscapeSequences converts escape sequences in a string to their byte values
-func processEscapeSequences(input string) string {
-	var result strings.Builder
-	i := 0
-	for i < len(input) {
-		if input[i] == '\\' && i+1 < len(input) {
-			switch input[i+1] {
-			case 'r':
-				result.WriteByte(0x0D) // CR
-				i += 2
-			case 'n':
-				result.WriteByte(0x0A) // LF
-				i += 2
-			case 't':
-				result.WriteByte(0x09) // TAB
-				i += 2
-			case '\\':
-				result.WriteByte(0x5C) // Backslash
-				i += 2
-			case 'x':
-				// Handle \xHH format
-				if i+3 < len(input) {
-					hexStr := input[i+2 : i+4]
-					var byteVal byte
-					_, err := fmt.Sscanf(hexStr, "%02x", &byteVal)
-					if err == nil {
-						result.WriteByte(byteVal)
-						i += 4
-						continue
-					}
-				}
-				// Invalid \x sequence, keep as-is
-				result.WriteByte(input[i])
-				i++
-			default:
-				// Unknown escape sequence, keep as-is
-				result.WriteByte(input[i])
-				i++
-			}
-		} else {
-			result.WriteByte(input[i])
-			i++
-		}
-	}
-	return result.String()
+	fmt.Fprintln(textOutput, "Server mode commands:")
+	fmt.Fprintln(textOutput, "  #send <clientIP> <message>: Send a message to a specific client")
+	fmt.Fprintln(textOutput, "  #broadcast <message>: Send a message to all connected clients")
+	fmt.Fprintln(textOutput, "  #list: Show all connected clients")
+	fmt.Fprintln(textOutput, "  #mode [text|hex|b64|json|layout]: Show or switch the input mode for messages")
+	fmt.Fprintln(textOutput, "  #reg get <register> [count]: Show Modbus registers (e.g., #reg get 40001 10)")
+	fmt.Fprintln(textOutput, "  #reg set <register> <value...>: Change Modbus registers (e.g., #reg set 40001 123)")
+	fmt.Fprintln(textOutput, "  #reg list: Show all Modbus registers that are set")
+	fmt.Fprintln(textOutput, "  #help: Show this help message")
+	fmt.Fprintln(textOutput, "  #quit, #exit: Shut down the server")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "Escape sequences in messages:")
+	fmt.Fprintln(textOutput, "  \\r  → CR (0x0D)")
+	fmt.Fprintln(textOutput, "  \\n  → LF (0x0A)")
+	fmt.Fprintln(textOutput, "  \\t  → TAB (0x09)")
+	fmt.Fprintln(textOutput, "  \\0  → NUL (0x00)")
+	fmt.Fprintln(textOutput, "  \\e  → ESC (0x1B)")
+	fmt.Fprintln(textOutput, "  \\a  → BEL (0x07)")
+	fmt.Fprintln(textOutput, "  \\\\  → Backslash (0x5C)")
+	fmt.Fprintln(textOutput, "  \\$  → Dollar sign (0x24)")
+	fmt.Fprintln(textOutput, "  \\xHH → Arbitrary byte (e.g., \\x1B for ESC)")
+	fmt.Fprintln(textOutput, "  \\dNNN → Arbitrary byte in decimal (e.g., \\d027 for ESC)")
+	fmt.Fprintln(textOutput, "  \\uXXXX → Unicode character as UTF-8")
+	fmt.Fprintln(textOutput, "  {N}  → Repeat the preceding escape sequence (e.g., \\x00{64})")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "Placeholders in messages:")
+	fmt.Fprintln(textOutput, "  ${seq}, ${ts}, ${ts:ms}, ${rand:N}, ${file:path}, ${crc16}")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "Input modes (#mode, or per message with a 'text:', 'hex:', 'b64:', 'json:' or 'layout:' prefix):")
+	fmt.Fprintln(textOutput, "  text → Text with escape sequences (Default)")
+	fmt.Fprintln(textOutput, "  hex  → Hex bytes (e.g., 02 41 03 or 0x024103)")
+	fmt.Fprintln(textOutput, "  b64  → Base64 (e.g., AkED)")
+	fmt.Fprintln(textOutput, "  json → JSON encoded by --decode (e.g., {\"id\": 1} as MessagePack)")
+	fmt.Fprintln(textOutput, "  layout → Frame built by --layout from name=value pairs (e.g., type=2 temp=-12)")
+	fmt.Fprintln(textOutput, "")
+	fmt.Fprintln(textOutput, "Program help: Type 'help program' for full program usage")
 }
 
 func runClient() {
-	if len(os.Args) < 5 {
-		fmt.Println("Usage: -c, --client <IP> <port> <terminator> [--buffer-size <size>] [--color] [--no-color]")
-		fmt.Println("Terminator: LF (0A) or CR (0D)")
+	o, err := parseOptions(modeClient, os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(textOutput, "Error:", err)
 		return
 	}
+	separateLogOutput()
+	if o.host == "" || o.port == "" || o.terminator == "" {
+		fmt.Fprintln(textOutput, "Usage:", usageLine(modeClient))
+		fmt.Fprintln(textOutput, "Terminator: LF (0A), CR (0D) or CRLF (0D 0A)")
+		return
+	}
+	address := o.host + ":" + o.port
+	if err := o.setup(); err != nil {
+		fmt.Fprintln(textOutput, "Error:", err)
+		return
+	}
+	terminatorBytes := o.terminatorBytes
 
-	address := os.Args[2] + ":" + os.Args[3]
-	terminator := os.Args[4]
-	bufferSize := 1024  // Default buffer size
-	colorEnabled = true // Default color enabled
-
-	// Parse arguments
-	for i := 5; i < len(os.Args); i++ {
-		arg := os.Args[i]
-		if arg == "--buffer-size" {
-			if i+1 < len(os.Args) {
-				if size, err := fmt.Sscanf(os.Args[i+1], "%d", &bufferSize); err != nil || size != 1 {
-					fmt.Println("Error: Buffer size must be a number")
-					return
-				}
-				if bufferSize <= 0 {
-					fmt.Println("Error: Buffer size must be 1 or greater")
-					return
-				}
-				i++ // Skip next argument
-			} else {
-				fmt.Println("Error: Buffer size must be specified after --buffer-size")
-				return
-			}
-		} else if arg == "--color" {
-			colorEnabled = true
-		} else if arg == "--no-color" {
-			colorEnabled = false
+	if o.recordPath != "" {
+		var err error
+		recorder, err = openRecorder(o.recordPath, modeClient, address, o.terminator)
+		if err != nil {
+			fmt.Fprintln(textOutput, "Record file error:", err)
+			return
 		}
+		defer recorder.Close()
 	}
-
-	// Set terminator
-	var terminatorBytes []byte
-	switch strings.ToUpper(terminator) {
-	case "LF":
-		terminatorBytes = []byte{0x0A} // LF
-	case "CR":
-		terminatorBytes = []byte{0x0D} // CR
-	default:
-		fmt.Println("Error: Terminator must be 'LF' or 'CR'")
+	if o.pcapPath != "" {
+		var err error
+		capture, err = openPcap(o.pcapPath)
+		if err != nil {
+			fmt.Fprintln(textOutput, "PCAP file error:", err)
+			return
+		}
+		defer capture.Close()
+	}
+	if err := openLogFile(); err != nil {
+		fmt.Fprintln(textOutput, "Log file error:", err)
 		return
 	}
+	defer logFile.Close()
 
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		fmt.Println("Connection error:", err)
-		return
+	console := &consoleSink{lineFormat: lineFormat{terminator: terminatorBytes}, out: os.Stdout, prompt: "Send"}
+
+	// Received messages are signaled to a query waiting for its response
+	responses := make(chan struct{}, 1)
+
+	sinks := append(newSinks(console), tcp.SinkFunc(func(e tcp.Event) {
+		if e.Kind != tcp.Received {
+			return
+		}
+		if lines := saveSCPIBlocks(e.Data); len(lines) > 0 {
+			console.println(lines...)
+		}
+		select {
+		case responses <- struct{}{}:
+		default:
+		}
+	}))
+
+	client := tcp.NewClient(address, tcp.Config{
+		Codec:      newCodec(terminatorBytes),
+		BufferSize: o.bufferSize,
+		Sink:       sinks,
+		WrapConn:   wrapConn(sinks),
+	})
+	if err := client.Connect(); err != nil {
+		return // Shown by the Error event
 	}
-	defer conn.Close()
+	defer client.Stop()
+	serverAddr := client.RemoteAddr()
 
-	fmt.Println("Connection successful:", address)
-	fmt.Printf("Terminator: %s (0x%02X)\n", terminator, terminatorBytes[0])
-	fmt.Printf("Buffer size: %d bytes\n", bufferSize)
-	fmt.Println("Chat started. Enter messages:")
-	fmt.Println("----------------------------------------")
+	o.printSettings()
+	if activeProtocol != nil && activeProtocol.query != nil {
+		fmt.Fprintf(textOutput, "Query timeout: %s\n", o.queryTimeout)
+	}
+	if scpiBlockDir != "" {
+		fmt.Fprintf(textOutput, "Saving blocks to: %s\n", scpiBlockDir)
+	}
+	o.printOutputs()
+	fmt.Fprintln(textOutput, "Chat started. Enter messages:")
+	fmt.Fprintln(textOutput, "----------------------------------------")
 
 	// Handle Ctrl-C (SIGINT) signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		fmt.Println("\nDisconnecting...")
-		conn.Close()
+		fmt.Fprintln(textOutput, "\nDisconnecting...")
+		client.Stop()
+		recorder.Close()
+		capture.Close()
+		logFile.Close()
 		os.Exit(0)
 	}()
 
-	// Mutex for output synchronization
-	var outputMutex sync.Mutex
-
-	// Receive-only goroutine
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		buffer := make([]byte, bufferSize)
-		var messageBuffer strings.Builder
-		const timeoutDuration = 100 * time.Millisecond // Timeout for incomplete messages
-
-		for {
-			// Set read deadline to detect when data stops coming
-			conn.SetReadDeadline(time.Now().Add(timeoutDuration))
-			n, err := conn.Read(buffer)
-
-			// Check if it's a timeout error
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				// Timeout occurred - display buffered data if any
-				message := messageBuffer.String()
-				if message != "" {
-					outputMutex.Lock()
-					fmt.Print("\r\033[K") // Clear current line
-					timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-					messageBytes := []byte(message)
-					hexData := fmt.Sprintf("%x", messageBytes)
-					if colorEnabled {
-						fmt.Printf("%s[Recv]%s %s%s%s | %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-							colorGreen, colorReset,
-							colorYellow, timestamp, colorReset,
-							message,
-							colorCyan, len(messageBytes), colorReset,
-							colorPurple, hexData, colorReset)
-					} else {
-						fmt.Printf("[Recv] %s | %s (Bytes: %d, HEX: %s)\n",
-							timestamp, message, len(messageBytes), hexData)
-					}
-					fmt.Print("Send> ") // Re-display prompt
-					outputMutex.Unlock()
-					messageBuffer.Reset()
-				}
-				continue // Continue reading
-			}
-
-			if err != nil {
-				// Display any remaining buffered data before returning
-				message := messageBuffer.String()
-				if message != "" {
-					outputMutex.Lock()
-					fmt.Print("\r\033[K") // Clear current line
-					timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-					messageBytes := []byte(message)
-					hexData := fmt.Sprintf("%x", messageBytes)
-					if colorEnabled {
-						fmt.Printf("%s[Recv]%s %s%s%s | %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-							colorGreen, colorReset,
-							colorYellow, timestamp, colorReset,
-							message,
-							colorCyan, len(messageBytes), colorReset,
-							colorPurple, hexData, colorReset)
-					} else {
-						fmt.Printf("[Recv] %s | %s (Bytes: %d, HEX: %s)\n",
-							timestamp, message, len(messageBytes), hexData)
-					}
-					outputMutex.Unlock()
-				}
-				outputMutex.Lock()
-				fmt.Print("\r\033[K") // Clear current line before error message
-				fmt.Println("Receive error:", err)
-				outputMutex.Unlock()
-				return
-			}
-
-			if n == 0 {
-				// Display any remaining buffered data when connection is closed gracefully
-				message := messageBuffer.String()
-				if message != "" {
-					outputMutex.Lock()
-					fmt.Print("\r\033[K") // Clear current line
-					timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-					messageBytes := []byte(message)
-					hexData := fmt.Sprintf("%x", messageBytes)
-					if colorEnabled {
-						fmt.Printf("%s[Recv]%s %s%s%s | %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-							colorGreen, colorReset,
-							colorYellow, timestamp, colorReset,
-							message,
-							colorCyan, len(messageBytes), colorReset,
-							colorPurple, hexData, colorReset)
-					} else {
-						fmt.Printf("[Recv] %s | %s (Bytes: %d, HEX: %s)\n",
-							timestamp, message, len(messageBytes), hexData)
-					}
-					fmt.Print("Send> ") // Re-display prompt
-					outputMutex.Unlock()
-					messageBuffer.Reset()
-				}
-				continue
-			}
-
-			// Process received data - wait for terminator like server side
-			data := buffer[:n]
-			for _, b := range data {
-				messageBuffer.WriteByte(b)
-				if b == terminatorBytes[0] {
-					// Display message when terminator is found
-					messageBytes := []byte(messageBuffer.String())
-					if len(messageBytes) > 0 {
-						outputMutex.Lock()
-						fmt.Print("\r\033[K") // Clear current line
-						timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-						// Display message without terminator for readability
-						displayMessage := strings.TrimSuffix(string(messageBytes), string(terminatorBytes))
-						hexData := fmt.Sprintf("%x", messageBytes)
-						if colorEnabled {
-							fmt.Printf("%s[Recv]%s %s%s%s | %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-								colorGreen, colorReset,
-								colorYellow, timestamp, colorReset,
-								displayMessage,
-								colorCyan, len(messageBytes), colorReset,
-								colorPurple, hexData, colorReset)
-						} else {
-							fmt.Printf("[Recv] %s | %s (Bytes: %d, HEX: %s)\n",
-								timestamp, displayMessage, len(messageBytes), hexData)
-						}
-						fmt.Print("Send> ") // Re-display prompt
-						outputMutex.Unlock()
-					}
-					messageBuffer.Reset()
-				}
-			}
-		}
-	}()
+	// Receive in the background
+	if err := client.Start(); err != nil {
+		fmt.Fprintln(textOutput, "Error:", err)
+		return
+	}
 
 	// Send processing
 	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Print("Send> ")
+	printPrompt(inputPrompt("Send"))
 	for scanner.Scan() {
 		text := scanner.Text()
 		if text == "" {
-			fmt.Print("Send> ")
+			printPrompt(inputPrompt("Send"))
 			continue
 		}
 
-		// Process escape sequences and send with specified terminator
-		processedText := processEscapeSequences(text)
-		message := processedText + string(terminatorBytes)
-		_, err := conn.Write([]byte(message))
-		if err != nil {
-			fmt.Println("Send error:", err)
-			break
+		// Switch input mode
+		if fields := strings.Fields(text); len(fields) > 0 && fields[0] == "#mode" {
+			console.mu.Lock()
+			handleModeCommand(fields[1:])
+			printPrompt(inputPrompt("Send"))
+			console.mu.Unlock()
+			continue
 		}
 
-		outputMutex.Lock()
-		timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-		messageBytes := []byte(message)
-		hexData := fmt.Sprintf("%x", messageBytes)
-		if colorEnabled {
-			fmt.Printf("%s[Send]%s %s%s%s | %s (Bytes: %s%d%s, HEX: %s%s%s)\n",
-				colorCyan, colorReset,
-				colorYellow, timestamp, colorReset,
-				text,
-				colorCyan, len(messageBytes), colorReset,
-				colorPurple, hexData, colorReset)
+		var message []byte
+		framed := false // message is a complete frame, sent without terminator
+		if fields := strings.Fields(text); len(fields) > 0 && fields[0] == "#modbus" {
+			// Build a Modbus TCP request
+			frame, err := buildModbusRequest(fields[1:])
+			if err != nil {
+				fmt.Fprintln(textOutput, "Error:", err)
+				printPrompt(inputPrompt("Send"))
+				continue
+			}
+			message, framed = frame, true
+		} else if mode, offset := messageInputMode(text); activeProtocol != nil && activeProtocol.command != nil && mode == inputText && offset == 0 {
+			// Encode a protocol command, like a Redis command into a RESP array.
+			// A mode prefix sends the message as it is.
+			frame, err := activeProtocol.command(text)
+			if err != nil {
+				fmt.Fprintln(textOutput, "Error:", err)
+				printPrompt(inputPrompt("Send"))
+				continue
+			}
+			message, framed = frame, true
 		} else {
-			fmt.Printf("[Send] %s | %s (Bytes: %d, HEX: %s)\n",
-				timestamp, text, len(messageBytes), hexData)
+			// Convert by input mode and send with specified terminator
+			processedText, err := convertInput(text)
+			if err != nil {
+				fmt.Fprintln(textOutput, "Error:", err)
+				printPrompt(inputPrompt("Send"))
+				continue
+			}
+			// Check the message with --decode (e.g., JSON) before sending
+			payload := checksum.appendTo(processedText)
+			if err := validatePayload([]byte(payload)); err != nil {
+				fmt.Fprintln(textOutput, "Error:", err)
+				printPrompt(inputPrompt("Send"))
+				continue
+			}
+			message = []byte(payload)
+		}
+
+		// A query waits for its response, not one to an earlier message
+		mode, offset := messageInputMode(text)
+		query := activeProtocol != nil && activeProtocol.query != nil && mode == inputText && activeProtocol.query(text[offset:])
+		if query {
+			select {
+			case <-responses:
+			default:
+			}
+		}
+		send := client.SendText
+		if framed {
+			send = client.SendFrame
+		}
+		if err := send(message, text); err != nil {
+			var writeErr *net.OpError
+			if errors.As(err, &writeErr) {
+				break // Shown by the Error event
+			}
+			fmt.Fprintln(textOutput, "Error:", err)
+			printPrompt(inputPrompt("Send"))
+			continue
+		}
+
+		if query {
+			select {
+			case <-responses:
+			case <-client.Done():
+			case <-time.After(o.queryTimeout):
+				err := fmt.Errorf("no response within %s", o.queryTimeout)
+				sinks.HandleEvent(tcp.Event{Kind: tcp.Error, Time: time.Now(), Addr: serverAddr, Op: opQuery, Err: err})
+				console.showPrompt()
+			}
 		}
-		fmt.Print("Send> ")
-		outputMutex.Unlock()
 	}
 
-	wg.Wait()
+	<-client.Done()
 }
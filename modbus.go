@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Modbus function codes
+const (
+	modbusReadCoils              = 0x01
+	modbusReadDiscreteInputs     = 0x02
+	modbusReadHoldingRegisters   = 0x03
+	modbusReadInputRegisters     = 0x04
+	modbusWriteSingleCoil        = 0x05
+	modbusWriteSingleRegister    = 0x06
+	modbusWriteMultipleCoils     = 0x0F
+	modbusWriteMultipleRegisters = 0x10
+)
+
+const modbusHeaderSize = 7 // MBAP header: transaction id, protocol id, length, unit id
+
+var modbusFunctionNames = map[byte]string{
+	modbusReadCoils:              "Read Coils",
+	modbusReadDiscreteInputs:     "Read Discrete Inputs",
+	modbusReadHoldingRegisters:   "Read Holding Registers",
+	modbusReadInputRegisters:     "Read Input Registers",
+	modbusWriteSingleCoil:        "Write Single Coil",
+	modbusWriteSingleRegister:    "Write Single Register",
+	modbusWriteMultipleCoils:     "Write Multiple Coils",
+	modbusWriteMultipleRegisters: "Write Multiple Registers",
+}
+
+var modbusExceptionNames = map[byte]string{
+	0x01: "Illegal Function",
+	0x02: "Illegal Data Address",
+	0x03: "Illegal Data Value",
+	0x04: "Server Device Failure",
+	0x05: "Acknowledge",
+	0x06: "Server Device Busy",
+	0x08: "Memory Parity Error",
+	0x0A: "Gateway Path Unavailable",
+	0x0B: "Gateway Target Device Failed to Respond",
+}
+
+var modbusProtocol = &protocol{
+	name:     "modbus",
+	split:    splitModbus,
+	describe: describeModbus,
+	respond:  respondModbus,
+}
+
+var modbusTransactionID uint32 // Last transaction id of #modbus requests
+
+// splitModbus frames Modbus TCP by the length field of the MBAP header. Data without a valid
+// header, like a stray byte, is passed up to the next possible header as a frame of its own,
+// so the frames after it stay in sync.
+func splitModbus(data []byte) int {
+	length := modbusFrameLength(data)
+	if length < 0 {
+		for i := 1; i < len(data); i++ {
+			if modbusFrameLength(data[i:]) >= 0 {
+				return i
+			}
+		}
+		return len(data)
+	}
+	if length == 0 || len(data) < length {
+		return 0
+	}
+	return length
+}
+
+// modbusFrameLength returns the frame length in the MBAP header at the start of data, 0 if more
+// data is needed, or -1 if the header is invalid: protocol id not 0 or length not 2-254
+func modbusFrameLength(data []byte) int {
+	if len(data) >= 4 && binary.BigEndian.Uint16(data[2:4]) != 0 {
+		return -1
+	}
+	if len(data) < 6 {
+		return 0
+	}
+	length := int(binary.BigEndian.Uint16(data[4:6]))
+	if length < 2 || length > 254 {
+		return -1
+	}
+	return 6 + length
+}
+
+// describeModbus decodes a Modbus TCP request or response
+func describeModbus(frame []byte, request bool, conn string) string {
+	if len(frame) < modbusHeaderSize+1 {
+		return fmt.Sprintf("Modbus: incomplete frame (%d bytes)", len(frame))
+	}
+	tid := binary.BigEndian.Uint16(frame[0:2])
+	pid := binary.BigEndian.Uint16(frame[2:4])
+	length := int(binary.BigEndian.Uint16(frame[4:6]))
+	unit := frame[6]
+	fc := frame[7]
+	data := frame[8:]
+
+	header := fmt.Sprintf("Modbus: tid=%d unit=%d", tid, unit)
+	if pid != 0 {
+		header += fmt.Sprintf(" pid=%d", pid)
+	}
+	if length != len(frame)-6 {
+		header += fmt.Sprintf(" length=%d (actual %d)", length, len(frame)-6)
+	}
+
+	if fc&0x80 != 0 {
+		code := byte(0)
+		if len(data) > 0 {
+			code = data[0]
+		}
+		name := modbusExceptionNames[code]
+		if name == "" {
+			name = "Unknown"
+		}
+		return fmt.Sprintf("%s fc=0x%02X (%s) exception=0x%02X (%s)", header, fc, modbusFunctionName(fc&0x7F), code, name)
+	}
+
+	header += fmt.Sprintf(" fc=0x%02X (%s)", fc, modbusFunctionName(fc))
+	var detail string
+	if request {
+		detail = describeModbusRequest(fc, data)
+	} else {
+		detail = describeModbusResponse(fc, data)
+	}
+	if detail == "" {
+		return header
+	}
+	return header + " " + detail
+}
+
+// describeModbusRequest decodes the data of a request PDU
+func describeModbusRequest(fc byte, data []byte) string {
+	switch fc {
+	case modbusReadCoils, modbusReadDiscreteInputs, modbusReadHoldingRegisters, modbusReadInputRegisters:
+		if len(data) != 4 {
+			break
+		}
+		return fmt.Sprintf("addr=0x%04X qty=%d", binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]))
+	case modbusWriteSingleCoil:
+		if len(data) != 4 {
+			break
+		}
+		return fmt.Sprintf("addr=0x%04X value=%s", binary.BigEndian.Uint16(data), modbusCoilValue(data[2:]))
+	case modbusWriteSingleRegister:
+		if len(data) != 4 {
+			break
+		}
+		return fmt.Sprintf("addr=0x%04X value=%s", binary.BigEndian.Uint16(data), modbusRegisterValue(data[2:]))
+	case modbusWriteMultipleCoils:
+		if len(data) < 5 || int(data[4]) != len(data)-5 {
+			break
+		}
+		qty := int(binary.BigEndian.Uint16(data[2:]))
+		return fmt.Sprintf("addr=0x%04X qty=%d coils=%s", binary.BigEndian.Uint16(data), qty, modbusBits(data[5:], qty))
+	case modbusWriteMultipleRegisters:
+		if len(data) < 5 || int(data[4]) != len(data)-5 || len(data[5:])%2 != 0 {
+			break
+		}
+		return fmt.Sprintf("addr=0x%04X qty=%d values=%s", binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]), modbusRegisters(data[5:]))
+	default:
+		return fmt.Sprintf("data=%x", data)
+	}
+	return fmt.Sprintf("malformed data=%x", data)
+}
+
+// describeModbusResponse decodes the data of a response PDU
+func describeModbusResponse(fc byte, data []byte) string {
+	switch fc {
+	case modbusReadCoils, modbusReadDiscreteInputs:
+		if len(data) < 1 || int(data[0]) != len(data)-1 {
+			break
+		}
+		return fmt.Sprintf("bytes=%d bits=%s", data[0], modbusBits(data[1:], len(data[1:])*8))
+	case modbusReadHoldingRegisters, modbusReadInputRegisters:
+		if len(data) < 1 || int(data[0]) != len(data)-1 || data[0]%2 != 0 {
+			break
+		}
+		return fmt.Sprintf("bytes=%d values=%s", data[0], modbusRegisters(data[1:]))
+	case modbusWriteSingleCoil, modbusWriteSingleRegister, modbusWriteMultipleCoils, modbusWriteMultipleRegisters:
+		// Write responses echo the request or return the address and quantity
+		if len(data) != 4 {
+			break
+		}
+		if fc == modbusWriteSingleCoil || fc == modbusWriteSingleRegister {
+			return describeModbusRequest(fc, data)
+		}
+		return fmt.Sprintf("addr=0x%04X qty=%d", binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]))
+	default:
+		return fmt.Sprintf("data=%x", data)
+	}
+	return fmt.Sprintf("malformed data=%x", data)
+}
+
+// modbusFunctionName returns the name of a function code
+func modbusFunctionName(fc byte) string {
+	if name, ok := modbusFunctionNames[fc]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// modbusCoilValue formats the value of Write Single Coil (0xFF00 is ON, 0x0000 is OFF)
+func modbusCoilValue(data []byte) string {
+	switch binary.BigEndian.Uint16(data) {
+	case 0xFF00:
+		return "ON"
+	case 0x0000:
+		return "OFF"
+	}
+	return fmt.Sprintf("invalid(0x%04X)", binary.BigEndian.Uint16(data))
+}
+
+// modbusRegisterValue formats a register as decimal and hex
+func modbusRegisterValue(data []byte) string {
+	value := binary.BigEndian.Uint16(data)
+	return fmt.Sprintf("%d(0x%04X)", value, value)
+}
+
+// modbusRegisters formats registers like "[123 456]"
+func modbusRegisters(data []byte) string {
+	values := make([]string, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		values = append(values, strconv.Itoa(int(binary.BigEndian.Uint16(data[i:]))))
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
+
+// modbusBits formats the first count bits (LSB of the first byte first) like "[1 0 1]"
+func modbusBits(data []byte, count int) string {
+	bits := make([]string, 0, count)
+	for i := 0; i < count && i/8 < len(data); i++ {
+		bits = append(bits, strconv.Itoa(int(data[i/8]>>(i%8)&1)))
+	}
+	return "[" + strings.Join(bits, " ") + "]"
+}
+
+// modbusCommands maps the operations of '#modbus' to function codes
+var modbusCommands = map[string]byte{
+	"read-coils":      modbusReadCoils,
+	"read-discrete":   modbusReadDiscreteInputs,
+	"read-holding":    modbusReadHoldingRegisters,
+	"read-input":      modbusReadInputRegisters,
+	"write-coil":      modbusWriteSingleCoil,
+	"write-register":  modbusWriteSingleRegister,
+	"write-coils":     modbusWriteMultipleCoils,
+	"write-registers": modbusWriteMultipleRegisters,
+}
+
+// buildModbusRequest builds a Modbus TCP request from '#modbus <operation> <unit> <address> <values...>'
+func buildModbusRequest(args []string) ([]byte, error) {
+	if activeProtocol != modbusProtocol {
+		return nil, fmt.Errorf("#modbus requires --protocol modbus")
+	}
+	if len(args) < 4 {
+		return nil, fmt.Errorf("usage: #modbus <operation> <unit> <address> <count|value...>")
+	}
+	operation := strings.ToLower(args[0])
+	fc, ok := modbusCommands[operation]
+	if !ok {
+		return nil, fmt.Errorf("operation must be one of: read-coils, read-discrete, read-holding, read-input, write-coil, write-register, write-coils, write-registers")
+	}
+	unit, err := strconv.ParseUint(args[1], 0, 8)
+	if err != nil {
+		return nil, fmt.Errorf("unit id must be 0 to 255")
+	}
+	address, err := strconv.ParseUint(args[2], 0, 16)
+	if err != nil {
+		return nil, fmt.Errorf("address must be 0 to 65535 (e.g., 100 or 0x0064)")
+	}
+	values := args[3:]
+
+	pdu := []byte{fc}
+	pdu = binary.BigEndian.AppendUint16(pdu, uint16(address))
+	switch fc {
+	case modbusReadCoils, modbusReadDiscreteInputs, modbusReadHoldingRegisters, modbusReadInputRegisters:
+		limit := uint64(125)
+		if fc == modbusReadCoils || fc == modbusReadDiscreteInputs {
+			limit = 2000
+		}
+		if len(values) != 1 {
+			return nil, fmt.Errorf("%s takes one count", operation)
+		}
+		count, err := strconv.ParseUint(values[0], 0, 16)
+		if err != nil || count < 1 || count > limit {
+			return nil, fmt.Errorf("count must be 1 to %d", limit)
+		}
+		pdu = binary.BigEndian.AppendUint16(pdu, uint16(count))
+	case modbusWriteSingleCoil:
+		if len(values) != 1 {
+			return nil, fmt.Errorf("%s takes one value", operation)
+		}
+		on, err := parseCoil(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("coil value must be on, off, 1 or 0")
+		}
+		if on {
+			pdu = append(pdu, 0xFF, 0x00)
+		} else {
+			pdu = append(pdu, 0x00, 0x00)
+		}
+	case modbusWriteSingleRegister:
+		if len(values) != 1 {
+			return nil, fmt.Errorf("%s takes one value", operation)
+		}
+		value, err := parseRegister(values[0])
+		if err != nil {
+			return nil, err
+		}
+		pdu = binary.BigEndian.AppendUint16(pdu, value)
+	case modbusWriteMultipleCoils:
+		if len(values) > 1968 {
+			return nil, fmt.Errorf("at most 1968 coils can be written")
+		}
+		bits := make([]byte, (len(values)+7)/8)
+		for i, v := range values {
+			on, err := parseCoil(v)
+			if err != nil {
+				return nil, fmt.Errorf("coil value must be on, off, 1 or 0")
+			}
+			if on {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		pdu = binary.BigEndian.AppendUint16(pdu, uint16(len(values)))
+		pdu = append(pdu, byte(len(bits)))
+		pdu = append(pdu, bits...)
+	case modbusWriteMultipleRegisters:
+		if len(values) > 123 {
+			return nil, fmt.Errorf("at most 123 registers can be written")
+		}
+		pdu = binary.BigEndian.AppendUint16(pdu, uint16(len(values)))
+		pdu = append(pdu, byte(len(values)*2))
+		for _, v := range values {
+			value, err := parseRegister(v)
+			if err != nil {
+				return nil, err
+			}
+			pdu = binary.BigEndian.AppendUint16(pdu, value)
+		}
+	}
+
+	tid := uint16(atomic.AddUint32(&modbusTransactionID, 1))
+	return modbusFrame(tid, byte(unit), pdu), nil
+}
+
+// modbusFrame adds the MBAP header to a PDU
+func modbusFrame(tid uint16, unit byte, pdu []byte) []byte {
+	frame := binary.BigEndian.AppendUint16(nil, tid)
+	frame = append(frame, 0x00, 0x00) // Protocol id
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(pdu)+1))
+	frame = append(frame, unit)
+	return append(frame, pdu...)
+}
+
+// parseCoil parses a coil value: on, off, 1 or 0
+func parseCoil(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "1", "true":
+		return true, nil
+	case "off", "0", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid coil value %q", value)
+}
+
+// parseRegister parses a register value: 0 to 65535, 0x hex, or -32768 to -1 as signed
+func parseRegister(value string) (uint16, error) {
+	if v, err := strconv.ParseUint(value, 0, 16); err == nil {
+		return uint16(v), nil
+	}
+	if v, err := strconv.ParseInt(value, 0, 16); err == nil {
+		return uint16(v), nil
+	}
+	return 0, fmt.Errorf("register value must be 0 to 65535 or -32768 to -1: %q", value)
+}
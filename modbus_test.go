@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitModbus(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"complete", "\x00\x01\x00\x00\x00\x06\x01\x03\x00\x00\x00\x01\x00", 12},
+		{"incomplete header", "\x00\x01\x00\x00\x00", 0},
+		{"incomplete pdu", "\x00\x01\x00\x00\x00\x06\x01\x03", 0},
+		{"stray byte before a frame", "\x05\x00\x01\x00\x00\x00\x06\x01\x03\x00\x00\x00\x01", 1},
+		{"invalid protocol id", "\x00\x01\x00\x07\x00\x06\x01\x03\x00\x00\x00\x01\x00\x02\x00\x00\x00\x06", 12},
+		{"invalid length", "\x00\x01\x00\x00\x01\x00\x00\x02\x00\x00\x00\x06", 6},
+		{"invalid protocol id in an incomplete header", "\x00\x01\x00\x07", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitModbus([]byte(tt.data)); got != tt.want {
+				t.Errorf("splitModbus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModbusRequestResponse(t *testing.T) {
+	defer func(m *modbusRegisterMap) { modbusMap = m }(modbusMap)
+	modbusMap = newModbusRegisterMap()
+	defer func() { activeProtocol = nil }()
+
+	if _, err := buildModbusRequest([]string{"read-holding", "1", "100", "2"}); err == nil {
+		t.Error("#modbus succeeded without --protocol modbus")
+	}
+	activeProtocol = modbusProtocol
+
+	write, err := buildModbusRequest([]string{"write-registers", "1", "100", "0x1234", "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if description := describeModbus(write, true, ""); !strings.Contains(description, "unit=1 fc=0x10 (Write Multiple Registers)") {
+		t.Errorf("describeModbus() = %q", description)
+	}
+	if response := respondModbus(write); !bytes.Equal(response[6:], []byte{0x01, 0x10, 0x00, 0x64, 0x00, 0x02}) {
+		t.Errorf("write response = % X", response)
+	}
+
+	read, err := buildModbusRequest([]string{"read-holding", "1", "100", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := respondModbus(read)
+	if !bytes.Equal(response[:2], read[:2]) {
+		t.Errorf("response tid = % X, want % X", response[:2], read[:2])
+	}
+	if !bytes.Equal(response[6:], []byte{0x01, 0x03, 0x04, 0x12, 0x34, 0x00, 0x2A}) {
+		t.Errorf("read response = % X", response)
+	}
+
+	if _, err := buildModbusRequest([]string{"read-holding", "1", "100", "126"}); err == nil {
+		t.Error("read of 126 registers succeeded")
+	}
+}
+
+func TestModbusMapException(t *testing.T) {
+	defer func(m *modbusRegisterMap) { modbusMap = m }(modbusMap)
+	modbusMap = newModbusRegisterMap()
+	modbusMap.restricted = true
+	activeProtocol = modbusProtocol
+	defer func() { activeProtocol = nil }()
+	if err := modbusMap.define("40001-40002", "7"); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := buildModbusRequest([]string{"read-holding", "1", "1", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := respondModbus(read)
+	if !bytes.Equal(response[6:], []byte{0x01, 0x83, modbusIllegalDataAddress}) {
+		t.Errorf("response = % X, want Illegal Data Address", response)
+	}
+	if description := describeModbus(response, false, ""); !strings.Contains(description, "exception=0x02 (Illegal Data Address)") {
+		t.Errorf("describeModbus() = %q", description)
+	}
+}
+
+func TestLoadModbusMapErrorLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registers.csv")
+	data := "register,value\n# Holding registers\n\n40001,1\n40002\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loadModbusMap(path)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 5:") {
+		t.Errorf("loadModbusMap() error = %v, want it on line 5", err)
+	}
+}
+
+func TestLoadModbusMapJSONErrorPosition(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"{\n  \"40001\": 1,\n  \"40002\" 2\n}\n", "line 3, column 11:"}, // Missing colon
+		{"{\n  \"40001\": 1\n}\n[]\n", "line 4, column 1:"},              // Data after the object
+		{"[\n  1\n]\n", "line 1, column 1:"},                             // Not an object
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "registers.json")
+		if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadModbusMap(path)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("loadModbusMap(%q) error = %v, want it at %s", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestModbusRequestExtraValues(t *testing.T) {
+	activeProtocol = modbusProtocol
+	defer func() { activeProtocol = nil }()
+
+	coils := []string{"write-coils", "1", "0"}
+	for range 1969 {
+		coils = append(coils, "1")
+	}
+	registers := []string{"write-registers", "1", "0"}
+	for range 124 {
+		registers = append(registers, "1")
+	}
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"read", []string{"read-holding", "1", "0", "2", "3"}},
+		{"write-coil", []string{"write-coil", "1", "0", "on", "off"}},
+		{"write-register", []string{"write-register", "1", "0", "5", "6"}},
+		{"write-coils", coils},
+		{"write-registers", registers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := buildModbusRequest(tt.args)
+			if err == nil {
+				t.Errorf("buildModbusRequest() = % X, want an error", frame)
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Modbus data tables by the first digit of a reference number (e.g., 40001 is holding register 0)
+const (
+	modbusCoils            = '0'
+	modbusDiscreteInputs   = '1'
+	modbusInputRegisters   = '3'
+	modbusHoldingRegisters = '4'
+)
+
+// Modbus exception codes returned by the simulator
+const (
+	modbusIllegalFunction    = 0x01
+	modbusIllegalDataAddress = 0x02
+	modbusIllegalDataValue   = 0x03
+)
+
+// modbusRegisterMap is the in-memory data of the Modbus server simulator.
+// Without a map file every address exists with value 0; with a map file
+// only the addresses in the file exist and others return Illegal Data Address.
+type modbusRegisterMap struct {
+	mu         sync.Mutex
+	restricted bool
+	tables     map[byte]map[uint16]uint16
+}
+
+func newModbusRegisterMap() *modbusRegisterMap {
+	return &modbusRegisterMap{tables: map[byte]map[uint16]uint16{
+		modbusCoils:            {},
+		modbusDiscreteInputs:   {},
+		modbusInputRegisters:   {},
+		modbusHoldingRegisters: {},
+	}}
+}
+
+var modbusMap = newModbusRegisterMap()
+
+// parseModbusReference parses a reference number like 40001 or 400001
+// and returns its table and 0-based address
+func parseModbusReference(ref string) (byte, uint16, error) {
+	if len(ref) != 5 && len(ref) != 6 {
+		return 0, 0, fmt.Errorf("invalid register %q (expected a reference like 00001, 10001, 30001 or 40001)", ref)
+	}
+	table := ref[0]
+	number, err := strconv.Atoi(ref[1:])
+	if err != nil || number < 1 || number > 65536 {
+		return 0, 0, fmt.Errorf("invalid register %q (expected a reference like 00001, 10001, 30001 or 40001)", ref)
+	}
+	switch table {
+	case modbusCoils, modbusDiscreteInputs, modbusInputRegisters, modbusHoldingRegisters:
+		return table, uint16(number - 1), nil
+	}
+	return 0, 0, fmt.Errorf("invalid register %q (must start with 0, 1, 3 or 4)", ref)
+}
+
+// modbusReference formats a table and address as a reference number like 40001
+func modbusReference(table byte, address uint16) string {
+	return fmt.Sprintf("%c%04d", table, int(address)+1)
+}
+
+// parseModbusValue parses a value for a table (0/1/on/off for coils and discrete inputs)
+func parseModbusValue(table byte, value string) (uint16, error) {
+	if table == modbusCoils || table == modbusDiscreteInputs {
+		on, err := parseCoil(value)
+		if err != nil {
+			return 0, err
+		}
+		if on {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return parseRegister(value)
+}
+
+// loadModbusMap loads a register map from a CSV file ("register,value" lines)
+// or a JSON file ({"40001": 123}). A register can be a range like 40001-40010.
+func loadModbusMap(path string) (*modbusRegisterMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := newModbusRegisterMap()
+	m.restricted = true
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		var entries map[string]interface{}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, jsonErrorPosition(data, err)
+		}
+		for ref, value := range entries {
+			if err := m.define(ref, fmt.Sprint(value)); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && strings.EqualFold(record[0], "register") {
+			continue // Header
+		}
+		// Comments and empty lines are skipped by the reader, so the line is asked from it
+		line, _ := reader.FieldPos(0)
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected register,value", line)
+		}
+		if err := m.define(record[0], record[1]); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return m, nil
+}
+
+// jsonErrorPosition adds the line and column in data to a JSON syntax or type error
+func jsonErrorPosition(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+	// The offset is after the character in error
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset > 0 {
+		offset--
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d: %v", line, column, err)
+}
+
+// define adds a register or a range of registers with a value to the map
+func (m *modbusRegisterMap) define(ref, value string) error {
+	first, last, _ := strings.Cut(ref, "-")
+	if last == "" {
+		last = first
+	}
+	table, start, err := parseModbusReference(strings.TrimSpace(first))
+	if err != nil {
+		return err
+	}
+	lastTable, end, err := parseModbusReference(strings.TrimSpace(last))
+	if err != nil {
+		return err
+	}
+	if lastTable != table || end < start {
+		return fmt.Errorf("invalid register range %q", ref)
+	}
+	v, err := parseModbusValue(table, strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+	for address := int(start); address <= int(end); address++ {
+		m.tables[table][uint16(address)] = v
+	}
+	return nil
+}
+
+// read returns count values from a table, or false if an address does not exist
+func (m *modbusRegisterMap) read(table byte, address, count uint16) ([]uint16, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values := make([]uint16, count)
+	for i := range values {
+		a := int(address) + i
+		value, ok := m.tables[table][uint16(a)]
+		if a > 0xFFFF || (m.restricted && !ok) {
+			return nil, false
+		}
+		values[i] = value
+	}
+	return values, true
+}
+
+// write stores values to a table, or returns false if an address does not exist
+func (m *modbusRegisterMap) write(table byte, address uint16, values []uint16) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range values {
+		a := int(address) + i
+		if _, ok := m.tables[table][uint16(a)]; a > 0xFFFF || (m.restricted && !ok) {
+			return false
+		}
+	}
+	for i, value := range values {
+		m.tables[table][address+uint16(i)] = value
+	}
+	return true
+}
+
+// respondModbus answers a Modbus TCP request from the register map.
+// It returns nil for frames that are not valid requests.
+func respondModbus(frame []byte) []byte {
+	if len(frame) < modbusHeaderSize+1 || binary.BigEndian.Uint16(frame[2:4]) != 0 ||
+		int(binary.BigEndian.Uint16(frame[4:6])) != len(frame)-6 {
+		return nil
+	}
+	tid := binary.BigEndian.Uint16(frame[0:2])
+	unit := frame[6]
+	fc := frame[7]
+	data := frame[8:]
+
+	pdu, exception := modbusMap.handle(fc, data)
+	if exception != 0 {
+		pdu = []byte{fc | 0x80, exception}
+	}
+	return modbusFrame(tid, unit, pdu)
+}
+
+// handle executes a request PDU and returns the response PDU or an exception code
+func (m *modbusRegisterMap) handle(fc byte, data []byte) ([]byte, byte) {
+	switch fc {
+	case modbusReadCoils, modbusReadDiscreteInputs, modbusReadHoldingRegisters, modbusReadInputRegisters:
+		if len(data) != 4 {
+			return nil, modbusIllegalDataValue
+		}
+		address, count := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		bitTable := fc == modbusReadCoils || fc == modbusReadDiscreteInputs
+		limit := uint16(125)
+		if bitTable {
+			limit = 2000
+		}
+		if count < 1 || count > limit {
+			return nil, modbusIllegalDataValue
+		}
+		values, ok := m.read(modbusFunctionTable(fc), address, count)
+		if !ok {
+			return nil, modbusIllegalDataAddress
+		}
+		if bitTable {
+			bits := make([]byte, (count+7)/8)
+			for i, v := range values {
+				if v != 0 {
+					bits[i/8] |= 1 << (i % 8)
+				}
+			}
+			return append([]byte{fc, byte(len(bits))}, bits...), 0
+		}
+		pdu := []byte{fc, byte(count * 2)}
+		for _, v := range values {
+			pdu = binary.BigEndian.AppendUint16(pdu, v)
+		}
+		return pdu, 0
+
+	case modbusWriteSingleCoil, modbusWriteSingleRegister:
+		if len(data) != 4 {
+			return nil, modbusIllegalDataValue
+		}
+		address, value := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if fc == modbusWriteSingleCoil {
+			if value != 0xFF00 && value != 0x0000 {
+				return nil, modbusIllegalDataValue
+			}
+			if value == 0xFF00 {
+				value = 1
+			}
+		}
+		if !m.write(modbusFunctionTable(fc), address, []uint16{value}) {
+			return nil, modbusIllegalDataAddress
+		}
+		// The response echoes the request
+		return append([]byte{fc}, data...), 0
+
+	case modbusWriteMultipleCoils, modbusWriteMultipleRegisters:
+		if len(data) < 5 || int(data[4]) != len(data)-5 {
+			return nil, modbusIllegalDataValue
+		}
+		address, count := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		values := make([]uint16, count)
+		if fc == modbusWriteMultipleCoils {
+			if count < 1 || count > 1968 || int(data[4]) != (int(count)+7)/8 {
+				return nil, modbusIllegalDataValue
+			}
+			for i := range values {
+				values[i] = uint16(data[5+i/8] >> (i % 8) & 1)
+			}
+		} else {
+			if count < 1 || count > 123 || int(data[4]) != int(count)*2 {
+				return nil, modbusIllegalDataValue
+			}
+			for i := range values {
+				values[i] = binary.BigEndian.Uint16(data[5+i*2:])
+			}
+		}
+		if !m.write(modbusFunctionTable(fc), address, values) {
+			return nil, modbusIllegalDataAddress
+		}
+		return append([]byte{fc}, data[:4]...), 0
+	}
+	return nil, modbusIllegalFunction
+}
+
+// modbusFunctionTable returns the data table accessed by a function code
+func modbusFunctionTable(fc byte) byte {
+	switch fc {
+	case modbusReadCoils, modbusWriteSingleCoil, modbusWriteMultipleCoils:
+		return modbusCoils
+	case modbusReadDiscreteInputs:
+		return modbusDiscreteInputs
+	case modbusReadInputRegisters:
+		return modbusInputRegisters
+	}
+	return modbusHoldingRegisters
+}
+
+// handleRegCommand handles '#reg get <register> [count]', '#reg set <register> <value...>' and '#reg list'
+func handleRegCommand(args []string) {
+	if activeProtocol != modbusProtocol {
+		fmt.Fprintln(textOutput, "Error: #reg requires --protocol modbus")
+		return
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(textOutput, "Usage: #reg get <register> [count], #reg set <register> <value...>, #reg list")
+		return
+	}
+	switch args[0] {
+	case "get":
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Fprintln(textOutput, "Usage: #reg get <register> [count]")
+			return
+		}
+		table, address, err := parseModbusReference(args[1])
+		if err != nil {
+			fmt.Fprintln(textOutput, "Error:", err)
+			return
+		}
+		count := uint64(1)
+		if len(args) == 3 {
+			if count, err = strconv.ParseUint(args[2], 0, 16); err != nil || count < 1 || int(address)+int(count) > 0x10000 {
+				fmt.Fprintln(textOutput, "Error: count is out of range")
+				return
+			}
+		}
+		values, ok := modbusMap.read(table, address, uint16(count))
+		if !ok {
+			fmt.Fprintf(textOutput, "Error: register %s is not in the register map\n", args[1])
+			return
+		}
+		for i, value := range values {
+			fmt.Fprintf(textOutput, "  %s = %d (0x%04X)\n", modbusReference(table, address+uint16(i)), value, value)
+		}
+	case "set":
+		if len(args) < 3 {
+			fmt.Fprintln(textOutput, "Usage: #reg set <register> <value...>")
+			return
+		}
+		table, address, err := parseModbusReference(args[1])
+		if err != nil {
+			fmt.Fprintln(textOutput, "Error:", err)
+			return
+		}
+		values := make([]uint16, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			value, err := parseModbusValue(table, arg)
+			if err != nil {
+				fmt.Fprintln(textOutput, "Error:", err)
+				return
+			}
+			values = append(values, value)
+		}
+		if int(address)+len(values) > 0x10000 || !modbusMap.write(table, address, values) {
+			fmt.Fprintf(textOutput, "Error: register %s is not in the register map\n", args[1])
+			return
+		}
+		for i, value := range values {
+			fmt.Fprintf(textOutput, "  %s = %d (0x%04X)\n", modbusReference(table, address+uint16(i)), value, value)
+		}
+	case "list":
+		modbusMap.list()
+	default:
+		fmt.Fprintf(textOutput, "Unknown reg command: %s\n", args[0])
+		fmt.Fprintln(textOutput, "Available reg commands: get, set, list")
+	}
+}
+
+// list prints the registers in the map (registers with value 0 are omitted without a map file)
+func (m *modbusRegisterMap) list() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, table := range []byte{modbusCoils, modbusDiscreteInputs, modbusInputRegisters, modbusHoldingRegisters} {
+		addresses := make([]int, 0, len(m.tables[table]))
+		for address, value := range m.tables[table] {
+			if m.restricted || value != 0 {
+				addresses = append(addresses, int(address))
+			}
+		}
+		sort.Ints(addresses)
+		for _, address := range addresses {
+			value := m.tables[table][uint16(address)]
+			fmt.Fprintf(textOutput, "  %s = %d (0x%04X)\n", modbusReference(table, uint16(address)), value, value)
+			count++
+		}
+	}
+	if count == 0 {
+		fmt.Fprintln(textOutput, "  No registers set")
+	}
+}
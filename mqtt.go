@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MQTT control packet types
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttPubrec      = 5
+	mqttPubrel      = 6
+	mqttPubcomp     = 7
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+	mqttAuth        = 15
+)
+
+var mqttPacketNames = map[byte]string{
+	mqttConnect:     "CONNECT",
+	mqttConnack:     "CONNACK",
+	mqttPublish:     "PUBLISH",
+	mqttPuback:      "PUBACK",
+	mqttPubrec:      "PUBREC",
+	mqttPubrel:      "PUBREL",
+	mqttPubcomp:     "PUBCOMP",
+	mqttSubscribe:   "SUBSCRIBE",
+	mqttSuback:      "SUBACK",
+	mqttUnsubscribe: "UNSUBSCRIBE",
+	mqttUnsuback:    "UNSUBACK",
+	mqttPingreq:     "PINGREQ",
+	mqttPingresp:    "PINGRESP",
+	mqttDisconnect:  "DISCONNECT",
+	mqttAuth:        "AUTH",
+}
+
+const mqttMaxPayloadText = 200 // Longer PUBLISH payloads are cut in the display
+
+var mqttProtocol = &protocol{
+	name:    "mqtt",
+	split:   splitMQTT,
+	summary: summarizeMQTT,
+	track:   trackMQTT,
+}
+
+var (
+	mqttLevels   = map[string]byte{} // Protocol level of each connection (4: 3.1.1, 5: 5.0), from its CONNECT packet
+	mqttLevelsMu sync.Mutex
+)
+
+// mqttRemainingLength decodes the variable-length remaining length after the first byte.
+// It returns the length and the number of bytes it takes, 0 if more data is needed, or -1 if it is invalid.
+func mqttRemainingLength(data []byte) (int, int) {
+	length, multiplier := 0, 1
+	for i := 1; i < len(data); i++ {
+		if i > 4 {
+			return 0, -1
+		}
+		length += int(data[i]&0x7F) * multiplier
+		if data[i]&0x80 == 0 {
+			return length, i
+		}
+		multiplier *= 128
+	}
+	if len(data) > 4 {
+		return 0, -1
+	}
+	return 0, 0
+}
+
+// splitMQTT frames MQTT by the remaining length of the fixed header. A fixed header with an
+// invalid remaining length is passed on its own, so the packets after it stay in sync.
+func splitMQTT(data []byte) int {
+	length, size := mqttRemainingLength(data)
+	if size < 0 {
+		return 5 // First byte and four length bytes
+	}
+	if size == 0 || len(data) < 1+size+length {
+		return 0
+	}
+	return 1 + size + length
+}
+
+// mqttReader reads the fields of an MQTT packet
+type mqttReader struct {
+	data []byte
+	err  bool // Set when a field goes beyond the packet
+}
+
+func (r *mqttReader) byte() byte {
+	if len(r.data) < 1 {
+		r.err = true
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *mqttReader) uint16() uint16 {
+	if len(r.data) < 2 {
+		r.err = true
+		r.data = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.data)
+	r.data = r.data[2:]
+	return v
+}
+
+// bytes reads binary data or a string with a 2-byte length
+func (r *mqttReader) bytes() []byte {
+	n := int(r.uint16())
+	if len(r.data) < n {
+		r.err = true
+		r.data = nil
+		return nil
+	}
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *mqttReader) string() string {
+	return string(r.bytes())
+}
+
+// skipProperties skips MQTT 5.0 properties and returns their length
+func (r *mqttReader) skipProperties() int {
+	length, size := mqttRemainingLength(append([]byte{0}, r.data...))
+	if size <= 0 || len(r.data) < size+length {
+		r.err = true
+		r.data = nil
+		return 0
+	}
+	r.data = r.data[size+length:]
+	return length
+}
+
+// trackMQTT keeps the protocol level of a connection from its CONNECT packet
+func trackMQTT(conn string, frame []byte, request bool) {
+	mqttLevelsMu.Lock()
+	defer mqttLevelsMu.Unlock()
+	if frame == nil {
+		delete(mqttLevels, conn)
+		return
+	}
+	length, size := mqttRemainingLength(frame)
+	if size <= 0 || len(frame) != 1+size+length || frame[0]>>4 != mqttConnect {
+		return
+	}
+	r := &mqttReader{data: frame[1+size:]}
+	r.string()
+	if level := r.byte(); !r.err {
+		mqttLevels[conn] = level
+	}
+}
+
+// mqttLevel returns the protocol level of a connection, 4 (3.1.1) until its CONNECT packet is seen
+func mqttLevel(conn string) byte {
+	mqttLevelsMu.Lock()
+	defer mqttLevelsMu.Unlock()
+	if level, ok := mqttLevels[conn]; ok {
+		return level
+	}
+	return 4
+}
+
+// summarizeMQTT decodes an MQTT control packet into one line, like `PUBLISH topic="a/b" qos=1 id=10 payload="23.5"`
+func summarizeMQTT(frame []byte, request bool, conn string) string {
+	length, size := mqttRemainingLength(frame)
+	if size <= 0 || len(frame) != 1+size+length {
+		return fmt.Sprintf("MQTT: invalid packet (%d bytes)", len(frame))
+	}
+	packetType := frame[0] >> 4
+	flags := frame[0] & 0x0F
+	name, ok := mqttPacketNames[packetType]
+	if !ok {
+		return fmt.Sprintf("MQTT: reserved packet type %d", packetType)
+	}
+	r := &mqttReader{data: frame[1+size:]}
+	v5 := mqttLevel(conn) >= 5
+
+	fields := []string{"MQTT " + name}
+	add := func(format string, args ...interface{}) {
+		fields = append(fields, fmt.Sprintf(format, args...))
+	}
+	switch packetType {
+	case mqttConnect:
+		protocolName := r.string()
+		level := r.byte()
+		connectFlags := r.byte()
+		keepAlive := r.uint16()
+		v5 = level >= 5
+		add("protocol=%s level=%d (%s)", protocolName, level, mqttVersionName(level))
+		if v5 {
+			r.skipProperties()
+		}
+		add("client_id=%q", r.string())
+		add("keepalive=%d", keepAlive)
+		add("clean=%t", connectFlags&0x02 != 0)
+		if connectFlags&0x04 != 0 {
+			if v5 {
+				r.skipProperties()
+			}
+			add("will_topic=%q will_qos=%d will_retain=%t", r.string(), connectFlags>>3&0x03, connectFlags&0x20 != 0)
+			add("will_payload=%s", mqttPayloadText(r.bytes()))
+		}
+		if connectFlags&0x80 != 0 {
+			add("username=%q", r.string())
+		}
+		if connectFlags&0x40 != 0 {
+			r.bytes()
+			add("password=***")
+		}
+	case mqttConnack:
+		sessionFlags := r.byte()
+		code := r.byte()
+		add("session_present=%t", sessionFlags&0x01 != 0)
+		if v5 {
+			add("reason=%s", mqttReasonName(code))
+		} else {
+			add("return_code=%s", mqttConnackName(code))
+		}
+	case mqttPublish:
+		qos := flags >> 1 & 0x03
+		add("topic=%q", r.string())
+		add("qos=%d", qos)
+		if qos > 0 {
+			add("id=%d", r.uint16())
+		}
+		if flags&0x01 != 0 {
+			add("retain")
+		}
+		if flags&0x08 != 0 {
+			add("dup")
+		}
+		if v5 {
+			r.skipProperties()
+		}
+		if !r.err {
+			add("payload=%s", mqttPayloadText(r.data))
+		}
+	case mqttPuback, mqttPubrec, mqttPubrel, mqttPubcomp:
+		add("id=%d", r.uint16())
+		if v5 && len(r.data) > 0 {
+			add("reason=%s", mqttReasonName(r.byte()))
+		}
+	case mqttSubscribe:
+		add("id=%d", r.uint16())
+		if v5 {
+			r.skipProperties()
+		}
+		var filters []string
+		for len(r.data) > 0 && !r.err {
+			filter := r.string()
+			options := r.byte()
+			filters = append(filters, fmt.Sprintf("%q qos=%d", filter, options&0x03))
+		}
+		add("filters=[%s]", strings.Join(filters, ", "))
+	case mqttSuback, mqttUnsuback:
+		add("id=%d", r.uint16())
+		if v5 {
+			r.skipProperties()
+		}
+		if len(r.data) > 0 {
+			codes := make([]string, 0, len(r.data))
+			for _, code := range r.data {
+				if packetType == mqttSuback && !v5 {
+					codes = append(codes, mqttSubackName(code))
+				} else {
+					codes = append(codes, mqttReasonName(code))
+				}
+			}
+			add("codes=[%s]", strings.Join(codes, ", "))
+		}
+	case mqttUnsubscribe:
+		add("id=%d", r.uint16())
+		if v5 {
+			r.skipProperties()
+		}
+		var filters []string
+		for len(r.data) > 0 && !r.err {
+			filters = append(filters, fmt.Sprintf("%q", r.string()))
+		}
+		add("filters=[%s]", strings.Join(filters, ", "))
+	case mqttDisconnect, mqttAuth:
+		if len(r.data) > 0 {
+			add("reason=%s", mqttReasonName(r.byte()))
+		}
+	}
+	if r.err {
+		add("(malformed)")
+	}
+	return strings.Join(fields, " ")
+}
+
+// mqttPayloadText formats a payload as quoted text, cut after mqttMaxPayloadText bytes
+func mqttPayloadText(payload []byte) string {
+	if len(payload) > mqttMaxPayloadText {
+		return fmt.Sprintf("\"%s\"... (%d bytes)", visibleText(decodeText(payload[:mqttMaxPayloadText])), len(payload))
+	}
+	return fmt.Sprintf("\"%s\" (%d bytes)", visibleText(decodeText(payload)), len(payload))
+}
+
+// mqttVersionName returns the MQTT version of a protocol level
+func mqttVersionName(level byte) string {
+	switch level {
+	case 3:
+		return "3.1"
+	case 4:
+		return "3.1.1"
+	case 5:
+		return "5.0"
+	}
+	return "unknown"
+}
+
+// mqttConnackName returns the name of an MQTT 3.1.1 CONNACK return code
+func mqttConnackName(code byte) string {
+	names := []string{"Accepted", "Unacceptable Protocol Version", "Identifier Rejected",
+		"Server Unavailable", "Bad User Name or Password", "Not Authorized"}
+	if int(code) < len(names) {
+		return fmt.Sprintf("0x%02X (%s)", code, names[code])
+	}
+	return fmt.Sprintf("0x%02X", code)
+}
+
+// mqttSubackName returns the name of an MQTT 3.1.1 SUBACK return code
+func mqttSubackName(code byte) string {
+	if code == 0x80 {
+		return "Failure"
+	}
+	return fmt.Sprintf("qos=%d", code)
+}
+
+// mqttReasonNames are the common MQTT 5.0 reason codes
+var mqttReasonNames = map[byte]string{
+	0x00: "Success",
+	0x01: "Granted QoS 1",
+	0x02: "Granted QoS 2",
+	0x04: "Disconnect with Will Message",
+	0x10: "No Matching Subscribers",
+	0x11: "No Subscription Existed",
+	0x18: "Continue Authentication",
+	0x80: "Unspecified Error",
+	0x81: "Malformed Packet",
+	0x82: "Protocol Error",
+	0x83: "Implementation Specific Error",
+	0x84: "Unsupported Protocol Version",
+	0x85: "Client Identifier Not Valid",
+	0x86: "Bad User Name or Password",
+	0x87: "Not Authorized",
+	0x88: "Server Unavailable",
+	0x89: "Server Busy",
+	0x8A: "Banned",
+	0x8B: "Server Shutting Down",
+	0x8C: "Bad Authentication Method",
+	0x8D: "Keep Alive Timeout",
+	0x8E: "Session Taken Over",
+	0x8F: "Topic Filter Invalid",
+	0x90: "Topic Name Invalid",
+	0x91: "Packet Identifier In Use",
+	0x92: "Packet Identifier Not Found",
+	0x93: "Receive Maximum Exceeded",
+	0x95: "Packet Too Large",
+	0x97: "Quota Exceeded",
+	0x99: "Payload Format Invalid",
+	0x9A: "Retain Not Supported",
+	0x9B: "QoS Not Supported",
+	0x9C: "Use Another Server",
+	0x9D: "Server Moved",
+	0x9E: "Shared Subscriptions Not Supported",
+	0x9F: "Connection Rate Exceeded",
+	0xA0: "Maximum Connect Time",
+	0xA1: "Subscription Identifiers Not Supported",
+	0xA2: "Wildcard Subscriptions Not Supported",
+}
+
+// mqttReasonName returns the name of an MQTT 5.0 reason code
+func mqttReasonName(code byte) string {
+	if name, ok := mqttReasonNames[code]; ok {
+		return fmt.Sprintf("0x%02X (%s)", code, name)
+	}
+	return fmt.Sprintf("0x%02X", code)
+}
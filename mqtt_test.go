@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+func TestMQTTLevelPerConnection(t *testing.T) {
+	connect5 := []byte("\x10\x0e\x00\x04MQTT\x05\x02\x00\x3c\x00\x00\x01a")
+	connect4 := []byte("\x10\x0d\x00\x04MQTT\x04\x02\x00\x3c\x00\x01a")
+	// 5.0: empty properties and reason code 0x80, 3.1.1: return codes 0x00 and 0x80
+	suback := []byte("\x90\x04\x00\x01\x00\x80")
+	defer func() { mqttLevels = map[string]byte{} }()
+
+	trackMQTT("a:1", connect5, true)
+	trackMQTT("b:2", connect4, true)
+	// Summarizing a CONNECT does not change the level of the connection
+	summarizeMQTT(connect4, true, "a:1")
+
+	tests := []struct {
+		conn string
+		want string
+	}{
+		{"a:1", "MQTT SUBACK id=1 codes=[0x80 (Unspecified Error)]"},
+		{"b:2", "MQTT SUBACK id=1 codes=[qos=0, Failure]"},
+		{"c:3", "MQTT SUBACK id=1 codes=[qos=0, Failure]"},
+	}
+	for _, tt := range tests {
+		if got := summarizeMQTT(suback, false, tt.conn); got != tt.want {
+			t.Errorf("summarizeMQTT(%s) = %q, want %q", tt.conn, got, tt.want)
+		}
+	}
+
+	trackMQTT("a:1", nil, false)
+	if level := mqttLevel("a:1"); level != 4 {
+		t.Errorf("mqttLevel() = %d after the connection is closed, want 4", level)
+	}
+}
+
+func TestSplitMQTT(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"pingreq", "\xc0\x00\xc0", 2},
+		{"incomplete", "\x30\x05ab", 0},
+		{"two byte length", "\x30\x80\x01" + string(make([]byte, 128)), 131},
+		{"length missing", "\x30\x80", 0},
+		{"invalid length", "\x30\xff\xff\xff\xff\x01", 5},
+		{"invalid length before pingreq", "\x30\xff\xff\xff\xff\xc0\x00", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitMQTT([]byte(tt.data)); got != tt.want {
+				t.Errorf("splitMQTT() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeMQTT(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  string
+	}{
+		{"pingreq", "\xc0\x00", "MQTT PINGREQ"},
+		{"disconnect", "\xe0\x00", "MQTT DISCONNECT"},
+		{"connect", "\x10\x0d\x00\x04MQTT\x04\x02\x00\x3c\x00\x01a", `MQTT CONNECT protocol=MQTT level=4 (3.1.1) client_id="a" keepalive=60 clean=true`},
+		{"connack", "\x20\x02\x00\x00", "MQTT CONNACK session_present=false return_code=0x00 (Accepted)"},
+		{"publish", "\x30\x09\x00\x03a/b23.5", `MQTT PUBLISH topic="a/b" qos=0 payload="23.5" (4 bytes)`},
+		{"publish qos 1", "\x32\x0b\x00\x03a/b\x00\x0a23.5", `MQTT PUBLISH topic="a/b" qos=1 id=10 payload="23.5" (4 bytes)`},
+		{"subscribe", "\x82\x08\x00\x0a\x00\x03a/b\x01", `MQTT SUBSCRIBE id=10 filters=["a/b" qos=1]`},
+		{"suback", "\x90\x04\x00\x01\x00\x80", "MQTT SUBACK id=1 codes=[qos=0, Failure]"},
+		{"reserved type", "\x00\x00", "MQTT: reserved packet type 0"},
+		{"wrong length", "\x30\x05ab", "MQTT: invalid packet (4 bytes)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeMQTT([]byte(tt.frame), true, "a:1"); got != tt.want {
+				t.Errorf("summarizeMQTT() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
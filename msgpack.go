@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var msgpackDecoder = &decoder{
+	name:   "msgpack",
+	label:  "MessagePack",
+	decode: decodeMsgpack,
+	encode: encodeMsgpack,
+	binary: true,
+}
+
+const maxDecodeDepth = 64 // Nesting limit of binary payloads
+
+// decodeMsgpack formats a MessagePack payload as a JSON-like tree
+func decodeMsgpack(payload []byte, colored bool) (string, error) {
+	r := &byteReader{data: payload}
+	value, err := readMsgpack(r, 0)
+	if err != nil {
+		return "", err
+	}
+	if r.pos < len(payload) {
+		return "", r.errorf("unexpected data after the value")
+	}
+	return formatTree(value, colored), nil
+}
+
+// readMsgpack reads one value. Maps are jsonObject, numbers json.Number,
+// bin values []byte and ext values jsonLiteral.
+func readMsgpack(r *byteReader, depth int) (interface{}, error) {
+	if depth > maxDecodeDepth {
+		return nil, r.errorf("nested deeper than %d levels", maxDecodeDepth)
+	}
+	start := r.pos
+	b, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7F:
+		return json.Number(strconv.Itoa(int(b))), nil
+	case b >= 0xE0:
+		return json.Number(strconv.Itoa(int(int8(b)))), nil
+	case b <= 0x8F:
+		return readMsgpackMap(r, int(b&0x0F), depth)
+	case b <= 0x9F:
+		return readMsgpackArray(r, int(b&0x0F), depth)
+	case b <= 0xBF:
+		return readMsgpackString(r, int(b&0x1F))
+	}
+
+	switch b {
+	case 0xC0:
+		return nil, nil
+	case 0xC2:
+		return false, nil
+	case 0xC3:
+		return true, nil
+	case 0xC4, 0xC5, 0xC6: // bin 8, 16, 32
+		length, err := r.length(1 << (b - 0xC4))
+		if err != nil {
+			return nil, err
+		}
+		return r.next(length)
+	case 0xC7, 0xC8, 0xC9: // ext 8, 16, 32
+		length, err := r.length(1 << (b - 0xC7))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackExt(r, length)
+	case 0xCA:
+		bits, err := r.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return floatValue(float64(math.Float32frombits(uint32(bits))), 32), nil
+	case 0xCB:
+		bits, err := r.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return floatValue(math.Float64frombits(bits), 64), nil
+	case 0xCC, 0xCD, 0xCE, 0xCF: // uint 8, 16, 32, 64
+		value, err := r.uint(1 << (b - 0xCC))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(value, 10)), nil
+	case 0xD0, 0xD1, 0xD2, 0xD3: // int 8, 16, 32, 64
+		shift := 64 - 8*uint(1<<(b-0xD0))
+		value, err := r.uint(1 << (b - 0xD0))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatInt(int64(value<<shift)>>shift, 10)), nil
+	case 0xD4, 0xD5, 0xD6, 0xD7, 0xD8: // fixext 1, 2, 4, 8, 16
+		return readMsgpackExt(r, 1<<(b-0xD4))
+	case 0xD9, 0xDA, 0xDB: // str 8, 16, 32
+		length, err := r.length(1 << (b - 0xD9))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, length)
+	case 0xDC, 0xDD: // array 16, 32
+		count, err := r.length(2 << (b - 0xDC))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, count, depth)
+	case 0xDE, 0xDF: // map 16, 32
+		count, err := r.length(2 << (b - 0xDE))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, count, depth)
+	}
+	r.pos = start
+	return nil, r.errorf("invalid type byte 0x%02X", b)
+}
+
+func readMsgpackString(r *byteReader, length int) (interface{}, error) {
+	data, err := r.next(length)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func readMsgpackArray(r *byteReader, count int, depth int) (interface{}, error) {
+	array := []interface{}{}
+	for i := 0; i < count; i++ {
+		value, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+	}
+	return array, nil
+}
+
+func readMsgpackMap(r *byteReader, count int, depth int) (interface{}, error) {
+	object := jsonObject{}
+	for i := 0; i < count; i++ {
+		key, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		object = append(object, jsonMember{keyText(key), value})
+	}
+	return object, nil
+}
+
+// readMsgpackExt reads the type and data of an extension. The timestamp extension (-1)
+// is shown as a date, others like ext(5, h'0102').
+func readMsgpackExt(r *byteReader, length int) (interface{}, error) {
+	extType, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	start := r.pos
+	data, err := r.next(length)
+	if err != nil {
+		return nil, err
+	}
+	if int8(extType) != -1 {
+		return jsonLiteral(fmt.Sprintf("ext(%d, h'%x')", int8(extType), data)), nil
+	}
+	var t time.Time
+	switch length {
+	case 4:
+		t = time.Unix(int64(binary.BigEndian.Uint32(data)), 0)
+	case 8:
+		value := binary.BigEndian.Uint64(data)
+		t = time.Unix(int64(value&0x3FFFFFFFF), int64(value>>34))
+	case 12:
+		t = time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data)))
+	default:
+		r.pos = start
+		return nil, r.errorf("invalid timestamp length %d", length)
+	}
+	return jsonTagged{"timestamp", t.UTC().Format(time.RFC3339Nano)}, nil
+}
+
+// encodeMsgpack encodes a value typed in json input mode as MessagePack,
+// integers and lengths in their shortest form
+func encodeMsgpack(value interface{}) ([]byte, error) {
+	var out []byte
+	var encode func(value interface{}) error
+	encode = func(value interface{}) error {
+		switch v := value.(type) {
+		case nil:
+			out = append(out, 0xC0)
+		case bool:
+			if v {
+				out = append(out, 0xC3)
+			} else {
+				out = append(out, 0xC2)
+			}
+		case json.Number:
+			number, err := jsonNumberValue(v)
+			if err != nil {
+				return err
+			}
+			switch n := number.(type) {
+			case int64:
+				out = appendMsgpackInt(out, n)
+			case uint64:
+				out = binary.BigEndian.AppendUint64(append(out, 0xCF), n)
+			case float64:
+				out = binary.BigEndian.AppendUint64(append(out, 0xCB), math.Float64bits(n))
+			}
+		case string:
+			switch {
+			case len(v) < 32:
+				out = append(out, 0xA0|byte(len(v)))
+			case len(v) <= math.MaxUint8:
+				out = append(out, 0xD9, byte(len(v)))
+			default:
+				out = appendMsgpackLength(out, len(v), 0xDA)
+			}
+			out = append(out, v...)
+		case []interface{}:
+			if len(v) < 16 {
+				out = append(out, 0x90|byte(len(v)))
+			} else {
+				out = appendMsgpackLength(out, len(v), 0xDC)
+			}
+			for _, element := range v {
+				if err := encode(element); err != nil {
+					return err
+				}
+			}
+		case jsonObject:
+			if len(v) < 16 {
+				out = append(out, 0x80|byte(len(v)))
+			} else {
+				out = appendMsgpackLength(out, len(v), 0xDE)
+			}
+			for _, member := range v {
+				if err := encode(member.key); err != nil {
+					return err
+				}
+				if err := encode(member.value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := encode(value); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// appendMsgpackInt appends an integer in its shortest form
+func appendMsgpackInt(out []byte, n int64) []byte {
+	switch {
+	case n >= -32 && n <= 0x7F:
+		return append(out, byte(n))
+	case n > 0 && n <= math.MaxUint8:
+		return append(out, 0xCC, byte(n))
+	case n > 0 && n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(out, 0xCD), uint16(n))
+	case n > 0 && n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(out, 0xCE), uint32(n))
+	case n > 0:
+		return binary.BigEndian.AppendUint64(append(out, 0xCF), uint64(n))
+	case n >= math.MinInt8:
+		return append(out, 0xD0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(out, 0xD1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(out, 0xD2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(out, 0xD3), uint64(n))
+}
+
+// appendMsgpackLength appends the 16-bit type of a string, array or map with its length,
+// or the 32-bit type that follows it
+func appendMsgpackLength(out []byte, length int, type16 byte) []byte {
+	if length <= math.MaxUint16 {
+		return binary.BigEndian.AppendUint16(append(out, type16), uint16(length))
+	}
+	return binary.BigEndian.AppendUint32(append(out, type16+1), uint32(length))
+}
+
+// floatValue returns a decoded float, NaN and infinities as literals
+func floatValue(f float64, bits int) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return jsonLiteral(strconv.FormatFloat(f, 'g', -1, bits))
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, bits))
+}
+
+// keyText returns a decoded map key as object key: strings as they are,
+// other values in compact form like 1 or [1,2]
+func keyText(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return s
+	}
+	text := formatJSON(key, false)
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(text)); err != nil {
+		return text // Literals like h'01' are not JSON
+	}
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestMsgpack(t *testing.T) {
+	value, err := parseJSON([]byte(`{"a":1,"b":[true,null,-2,1.5,"x"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeMsgpack(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(data), "82a16101a16295c3c0fecb3ff8000000000000a178"; got != want {
+		t.Errorf("encodeMsgpack() = %s, want %s", got, want)
+	}
+	tree, err := decodeMsgpack(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := formatTree(value, false); tree != want {
+		t.Errorf("decodeMsgpack() = %s, want %s", tree, want)
+	}
+}
+
+func TestDecodeMsgpack(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+		err  bool
+	}{
+		{"uint16", "cd0100", "256", false},
+		{"negative fixint", "ff", "-1", false},
+		{"str8", "d90568656c6c6f", `"hello"`, false},
+		{"missing element", "9201", "", true},
+		{"truncated string", "a568", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			got, err := decodeMsgpack(data, false)
+			if (err != nil) != tt.err || got != tt.want {
+				t.Errorf("decodeMsgpack() = %q, %v, want %q (error %t)", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutat23/coe/tcp"
+)
+
+var nmeaProtocol = &protocol{
+	name:       "nmea",
+	terminator: "CRLF",
+	summary:    summarizeNMEA,
+}
+
+// Start position and track of the fixes of the NMEA generator
+const (
+	nmeaBaseLat   = 35.681236 // Tokyo Station
+	nmeaBaseLon   = 139.767125
+	nmeaRadius    = 50.0 // Meters of the circle driven around the start position
+	nmeaStep      = 3.0  // Degrees driven on the circle per fix
+	nmeaAltitude  = 40.0
+	nmeaMinPeriod = 10 * time.Millisecond
+)
+
+var nmeaQualityNames = map[string]string{
+	"0": "invalid",
+	"1": "GPS fix",
+	"2": "DGPS fix",
+	"3": "PPS fix",
+	"4": "RTK fixed",
+	"5": "RTK float",
+	"6": "estimated",
+	"7": "manual",
+	"8": "simulation",
+}
+
+var nmeaModeNames = map[string]string{
+	"A": "autonomous",
+	"D": "differential",
+	"E": "estimated",
+	"F": "RTK float",
+	"M": "manual",
+	"N": "not valid",
+	"P": "precise",
+	"R": "RTK fixed",
+	"S": "simulator",
+}
+
+// nmeaChecksum returns the XOR of the characters between '$' (or '!') and '*'
+func nmeaChecksum(body string) byte {
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return sum
+}
+
+// summarizeNMEA validates the *HH checksum of a sentence and decodes GGA, RMC, VTG and GSV
+// into labelled fields, like "[NMEA OK] GPGGA time=12:35:19 lat=48.117300 lon=11.516667 ...".
+// Other sentences are shown as they are after the checksum label.
+func summarizeNMEA(frame []byte, request bool, conn string) string {
+	sentence := strings.TrimRight(string(frame), "\r\n")
+	if len(sentence) < 2 || (sentence[0] != '$' && sentence[0] != '!') {
+		return ""
+	}
+
+	body, actual, hasChecksum := strings.Cut(sentence[1:], "*")
+	label := ""
+	if hasChecksum {
+		expected := fmt.Sprintf("%02X", nmeaChecksum(body))
+		if strings.EqualFold(actual, expected) {
+			label = "[NMEA OK] "
+		} else {
+			label = fmt.Sprintf("[NMEA BAD expected=%s actual=%s] ", expected, visibleText(actual))
+		}
+	}
+
+	fields := strings.Split(body, ",")
+	decoded := ""
+	if len(fields[0]) == 5 {
+		switch fields[0][2:] {
+		case "GGA":
+			decoded = decodeGGA(fields[1:])
+		case "RMC":
+			decoded = decodeRMC(fields[1:])
+		case "VTG":
+			decoded = decodeVTG(fields[1:])
+		case "GSV":
+			decoded = decodeGSV(fields[1:])
+		}
+	}
+	if decoded == "" {
+		return label + visibleText(sentence)
+	}
+	return label + fields[0] + decoded
+}
+
+// nmeaFields formats labelled fields, skipping empty values
+type nmeaFields []string
+
+func (f *nmeaFields) add(label, value string) {
+	if value != "" {
+		*f = append(*f, label+"="+value)
+	}
+}
+
+func (f nmeaFields) String() string {
+	if len(f) == 0 {
+		return ""
+	}
+	return " " + strings.Join(f, " ")
+}
+
+// nmeaField returns field i, or "" if the sentence is shorter
+func nmeaField(fields []string, i int) string {
+	if i < len(fields) {
+		return fields[i]
+	}
+	return ""
+}
+
+// nmeaTime formats hhmmss.ss as hh:mm:ss.ss
+func nmeaTime(value string) string {
+	if len(value) < 6 {
+		return value
+	}
+	return value[0:2] + ":" + value[2:4] + ":" + value[4:]
+}
+
+// nmeaDate formats ddmmyy as yyyy-mm-dd
+func nmeaDate(value string) string {
+	if len(value) != 6 {
+		return value
+	}
+	century := "20"
+	if value[4:] >= "80" {
+		century = "19"
+	}
+	return century + value[4:] + "-" + value[2:4] + "-" + value[0:2]
+}
+
+// nmeaCoordinate converts ddmm.mmmm (or dddmm.mmmm) and a hemisphere into signed decimal degrees
+func nmeaCoordinate(value, hemisphere string) string {
+	dot := strings.IndexByte(value, '.')
+	if dot < 0 {
+		dot = len(value)
+	}
+	if dot < 3 {
+		return value
+	}
+	degrees, err1 := strconv.ParseFloat(value[:dot-2], 64)
+	minutes, err2 := strconv.ParseFloat(value[dot-2:], 64)
+	if err1 != nil || err2 != nil {
+		return value
+	}
+	decimal := degrees + minutes/60
+	if hemisphere == "S" || hemisphere == "W" {
+		decimal = -decimal
+	}
+	return strconv.FormatFloat(decimal, 'f', 6, 64)
+}
+
+// nmeaNamed formats a code with its name, like "1 (GPS fix)"
+func nmeaNamed(code string, names map[string]string) string {
+	if name, ok := names[code]; ok {
+		return code + " (" + name + ")"
+	}
+	return code
+}
+
+// nmeaUnit appends a unit to a non-empty value
+func nmeaUnit(value, unit string) string {
+	if value == "" {
+		return ""
+	}
+	return value + unit
+}
+
+// decodeGGA decodes a fix: time, position, quality, satellites, HDOP, altitude and geoid separation
+func decodeGGA(fields []string) string {
+	var f nmeaFields
+	f.add("time", nmeaTime(nmeaField(fields, 0)))
+	if lat := nmeaField(fields, 1); lat != "" {
+		f.add("lat", nmeaCoordinate(lat, nmeaField(fields, 2)))
+	}
+	if lon := nmeaField(fields, 3); lon != "" {
+		f.add("lon", nmeaCoordinate(lon, nmeaField(fields, 4)))
+	}
+	f.add("quality", nmeaNamed(nmeaField(fields, 5), nmeaQualityNames))
+	f.add("sats", nmeaField(fields, 6))
+	f.add("hdop", nmeaField(fields, 7))
+	f.add("alt", nmeaUnit(nmeaField(fields, 8), strings.ToLower(nmeaField(fields, 9))))
+	f.add("geoid", nmeaUnit(nmeaField(fields, 10), strings.ToLower(nmeaField(fields, 11))))
+	f.add("dgps_age", nmeaField(fields, 12))
+	f.add("dgps_station", nmeaField(fields, 13))
+	return f.String()
+}
+
+// decodeRMC decodes the recommended minimum data: time, status, position, speed, course and date
+func decodeRMC(fields []string) string {
+	var f nmeaFields
+	f.add("time", nmeaTime(nmeaField(fields, 0)))
+	f.add("status", nmeaNamed(nmeaField(fields, 1), map[string]string{"A": "valid", "V": "warning"}))
+	if lat := nmeaField(fields, 2); lat != "" {
+		f.add("lat", nmeaCoordinate(lat, nmeaField(fields, 3)))
+	}
+	if lon := nmeaField(fields, 4); lon != "" {
+		f.add("lon", nmeaCoordinate(lon, nmeaField(fields, 5)))
+	}
+	f.add("speed", nmeaUnit(nmeaField(fields, 6), "kn"))
+	f.add("course", nmeaField(fields, 7))
+	f.add("date", nmeaDate(nmeaField(fields, 8)))
+	f.add("magvar", nmeaField(fields, 9)+nmeaField(fields, 10))
+	f.add("mode", nmeaNamed(nmeaField(fields, 11), nmeaModeNames))
+	return f.String()
+}
+
+// decodeVTG decodes the course and speed over ground
+func decodeVTG(fields []string) string {
+	var f nmeaFields
+	f.add("course_true", nmeaField(fields, 0))
+	f.add("course_mag", nmeaField(fields, 2))
+	f.add("speed", nmeaUnit(nmeaField(fields, 4), "kn"))
+	f.add("speed_kmh", nmeaField(fields, 6))
+	f.add("mode", nmeaNamed(nmeaField(fields, 8), nmeaModeNames))
+	return f.String()
+}
+
+// decodeGSV decodes the satellites in view: PRN, elevation, azimuth and SNR of up to 4 satellites
+func decodeGSV(fields []string) string {
+	var f nmeaFields
+	if total, number := nmeaField(fields, 0), nmeaField(fields, 1); total != "" && number != "" {
+		f.add("msg", number+"/"+total)
+	}
+	f.add("in_view", nmeaField(fields, 2))
+	var sats []string
+	for i := 3; i+3 < len(fields); i += 4 {
+		snr := fields[i+3]
+		if snr == "" {
+			snr = "-"
+		}
+		sats = append(sats, fmt.Sprintf("%s el=%s az=%s snr=%s", fields[i], fields[i+1], fields[i+2], snr))
+	}
+	if len(sats) > 0 {
+		f = append(f, "sats=["+strings.Join(sats, ", ")+"]")
+	}
+	return f.String()
+}
+
+// parseNMEARate parses the rate of --nmea-rate, like "1Hz", "5hz" or a period like "200ms"
+func parseNMEARate(value string) (time.Duration, error) {
+	var period time.Duration
+	if hz, ok := strings.CutSuffix(strings.ToLower(value), "hz"); ok {
+		rate, err := strconv.ParseFloat(hz, 64)
+		if err != nil || rate <= 0 {
+			return 0, fmt.Errorf("NMEA rate must be a frequency like 1Hz or a period like 200ms")
+		}
+		period = time.Duration(float64(time.Second) / rate)
+	} else {
+		var err error
+		period, err = time.ParseDuration(value)
+		if err != nil || period <= 0 {
+			return 0, fmt.Errorf("NMEA rate must be a frequency like 1Hz or a period like 200ms")
+		}
+	}
+	if period < nmeaMinPeriod {
+		return 0, fmt.Errorf("NMEA rate must be 100Hz (10ms) or slower")
+	}
+	return period, nil
+}
+
+// nmeaSentence adds '$', the checksum and no terminator to the fields of a sentence
+func nmeaSentence(fields ...string) string {
+	body := strings.Join(fields, ",")
+	return fmt.Sprintf("$%s*%02X", body, nmeaChecksum(body))
+}
+
+// nmeaLatitude formats decimal degrees as ddmm.mmmm and a hemisphere
+func nmeaLatitude(degrees float64) (string, string) {
+	hemisphere := "N"
+	if degrees < 0 {
+		hemisphere, degrees = "S", -degrees
+	}
+	return nmeaDegreesMinutes(degrees, 2), hemisphere
+}
+
+// nmeaLongitude formats decimal degrees as dddmm.mmmm and a hemisphere
+func nmeaLongitude(degrees float64) (string, string) {
+	hemisphere := "E"
+	if degrees < 0 {
+		hemisphere, degrees = "W", -degrees
+	}
+	return nmeaDegreesMinutes(degrees, 3), hemisphere
+}
+
+// nmeaDegreesMinutes formats positive decimal degrees as degrees with width digits and minutes
+// with 4 decimals. The minutes are rounded first, so 59.99996 carries into the degrees
+// instead of becoming 60.0000.
+func nmeaDegreesMinutes(degrees float64, width int) string {
+	minutes := int64(math.Round(degrees * 60 * 10000)) // Ten-thousandths of a minute
+	return fmt.Sprintf("%0*d%02d.%04d", width, minutes/600000, minutes%600000/10000, minutes%10000)
+}
+
+// nmeaFix returns the GGA, RMC, VTG and GSV sentences of the n-th synthetic fix,
+// driving a circle around the start position
+func nmeaFix(n int, now time.Time, period time.Duration) []string {
+	angle := math.Mod(float64(n)*nmeaStep, 360)
+	rad := angle * math.Pi / 180
+	lat := nmeaBaseLat + nmeaRadius*math.Cos(rad)/111320
+	lon := nmeaBaseLon + nmeaRadius*math.Sin(rad)/(111320*math.Cos(nmeaBaseLat*math.Pi/180))
+	speed := nmeaRadius * nmeaStep * math.Pi / 180 / period.Seconds() // m/s
+	knots := fmt.Sprintf("%.1f", speed*1.943844)
+	kmh := fmt.Sprintf("%.1f", speed*3.6)
+	course := fmt.Sprintf("%.1f", math.Mod(angle+90, 360))
+
+	utc := now.UTC()
+	timeText := utc.Format("150405") + fmt.Sprintf(".%02d", utc.Nanosecond()/1e7)
+	latText, ns := nmeaLatitude(lat)
+	lonText, ew := nmeaLongitude(lon)
+	return []string{
+		nmeaSentence("GPGGA", timeText, latText, ns, lonText, ew, "1", "08", "0.9",
+			fmt.Sprintf("%.1f", nmeaAltitude), "M", "39.5", "M", "", ""),
+		nmeaSentence("GPRMC", timeText, "A", latText, ns, lonText, ew, knots, course, utc.Format("020106"), "", "", "A"),
+		nmeaSentence("GPVTG", course, "T", "", "M", knots, "N", kmh, "K", "A"),
+		nmeaSentence("GPGSV", "1", "1", "04", "05", "45", "120", "42", "12", "30", "210", "38",
+			"18", "60", "045", "45", "25", "15", "300", "30"),
+	}
+}
+
+// generateNMEA broadcasts synthetic fixes to all clients every period
+func generateNMEA(server *tcp.Server, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	n := 0
+	for now := range ticker.C {
+		for _, sentence := range nmeaFix(n, now, period) {
+			// Sentences are sent as generated, without the input mode, --checksum and --encoding
+			if _, err := server.BroadcastText([]byte(sentence), sentence); err != nil {
+				fmt.Fprintln(textOutput, "NMEA generator error:", err)
+				return
+			}
+		}
+		n++
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarizeNMEA(t *testing.T) {
+	tests := []struct {
+		name     string
+		sentence string
+		want     []string // Parts of the summary
+	}{
+		{"valid checksum", "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47\r\n",
+			[]string{"[NMEA OK] GPGGA", "lat=48.117300", "lon=11.516667"}},
+		{"lowercase checksum", "$GPVTG,054.7,T,034.4,M,005.5,N,010.2,K,N*2a\r\n", []string{"[NMEA OK] GPVTG"}},
+		{"bad checksum", "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48\r\n",
+			[]string{"[NMEA BAD expected=47 actual=48]"}},
+		{"no checksum", "$GPGGA,123519,4807.038,S,01131.000,W,1,08,0.9,545.4,M,46.9,M,,\r\n",
+			[]string{"lat=-48.117300", "lon=-11.516667"}},
+		{"unknown sentence", "$PXXXX,1,2*53\r\n", []string{"[NMEA OK] $PXXXX,1,2*53"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarizeNMEA([]byte(tt.sentence), false, "")
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("summarizeNMEA() = %q, want %q in it", got, want)
+				}
+			}
+		})
+	}
+	if got := summarizeNMEA([]byte("hello\r\n"), false, ""); got != "" {
+		t.Errorf("summarizeNMEA(not a sentence) = %q, want \"\"", got)
+	}
+}
+
+func TestNMEACoordinate(t *testing.T) {
+	tests := []struct {
+		value, hemisphere, want string
+	}{
+		{"3540.9011", "N", "35.681685"},
+		{"3540.9011", "S", "-35.681685"},
+		{"13946.0275", "E", "139.767125"},
+		{"13946.0275", "W", "-139.767125"},
+		{"4807", "N", "48.116667"},
+		{"12.5", "N", "12.5"},
+		{"ab07.5", "N", "ab07.5"},
+		{"", "N", ""},
+	}
+	for _, tt := range tests {
+		if got := nmeaCoordinate(tt.value, tt.hemisphere); got != tt.want {
+			t.Errorf("nmeaCoordinate(%q, %q) = %q, want %q", tt.value, tt.hemisphere, got, tt.want)
+		}
+	}
+}
+
+func TestNMEALatitudeLongitude(t *testing.T) {
+	tests := []struct {
+		degrees    float64
+		longitude  bool
+		want, side string
+	}{
+		{35.681685, false, "3540.9011", "N"},
+		{-35.681685, false, "3540.9011", "S"},
+		{139.767125, true, "13946.0275", "E"},
+		{34 + 59.99996/60, false, "3500.0000", "N"},     // Minutes round up to the next degree
+		{-(139 + 59.99996/60), true, "14000.0000", "W"}, // Minutes round up to the next degree
+		{34 + 59.99994/60, false, "3459.9999", "N"},
+	}
+	for _, tt := range tests {
+		format := nmeaLatitude
+		if tt.longitude {
+			format = nmeaLongitude
+		}
+		if got, side := format(tt.degrees); got != tt.want || side != tt.side {
+			t.Errorf("format(%v) = %s %s, want %s %s", tt.degrees, got, side, tt.want, tt.side)
+		}
+	}
+}
+
+func TestParseNMEARate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration // 0 if the rate is invalid
+	}{
+		{"1Hz", time.Second},
+		{"5hz", 200 * time.Millisecond},
+		{"100Hz", 10 * time.Millisecond},
+		{"200ms", 200 * time.Millisecond},
+		{"10ms", 10 * time.Millisecond},
+		{"101Hz", 0},
+		{"9ms", 0},
+		{"0Hz", 0},
+		{"-1s", 0},
+		{"fast", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseNMEARate(tt.value)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("parseNMEARate(%q) = %v, want an error", tt.value, got)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("parseNMEARate(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestNMEAFixChecksums(t *testing.T) {
+	for _, sentence := range nmeaFix(3, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), time.Second) {
+		if got := summarizeNMEA([]byte(sentence+"\r\n"), false, ""); !strings.HasPrefix(got, "[NMEA OK] ") {
+			t.Errorf("generated %q summarized as %q", sentence, got)
+		}
+	}
+}
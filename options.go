@@ -0,0 +1,689 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutat23/coe/framing"
+	"gopkg.in/yaml.v3"
+)
+
+// Modes that options are available in
+const (
+	modeServer = "server"
+	modeClient = "client"
+	modeReplay = "replay"
+)
+
+// options are the settings of server, client and replay mode, from a profile and the command line.
+// Settings used while formatting and framing messages, like --protocol and --encoding, are
+// set in the globals of their files; options holds the rest. setup checks the combination.
+type options struct {
+	mode        string
+	configPath  string // Config file of the profile
+	profileName string // "" when no profile is used
+
+	host             string // Client: server IP address
+	port             string
+	terminator       string // "LF", "CR" or "CRLF" after setup
+	terminatorBytes  []byte // Set by setup (nil: messages are framed by the protocol or length prefix)
+	echo             bool
+	bufferSize       int
+	recordPath       string
+	pcapPath         string
+	modbusMapPath    string
+	httpResponsePath string
+	nmeaPeriod       time.Duration
+	queryTimeout     time.Duration
+
+	replayPath    string        // Replay: record file
+	role          string        // Replay: modeServer or modeClient
+	address       string        // Replay: address to connect to or listen on
+	speed         float64       // Replay: timing factor (0: no waiting)
+	replayTimeout time.Duration // Replay: wait for each recorded received frame
+}
+
+// optionSpec describes an option. On the command line it is given as --<name>,
+// in profiles as <name>: <value>.
+type optionSpec struct {
+	value  string // What the value is, for errors ("" for flags, which are true or false in profiles)
+	arg    string // Value in usage lines, like "<file>" ("" for flags and options given as arguments)
+	mode   string // modeServer, modeClient or modeReplay when the option is available in one mode only
+	replay bool   // Available in replay mode as well as server and client mode
+	set    func(o *options, value string) error
+}
+
+// availableIn reports whether the option can be used in mode
+func (s optionSpec) availableIn(mode string) bool {
+	if mode == modeReplay {
+		return s.mode == modeReplay || s.replay
+	}
+	return s.mode == "" || s.mode == mode
+}
+
+// optionSpecs are the options of server, client and replay mode
+var optionSpecs = map[string]optionSpec{
+	"config": {value: "File path", arg: "<file>", replay: true, set: func(o *options, v string) error {
+		o.configPath = v
+		return nil
+	}},
+	"profile": {value: "Profile name", arg: "<name>", replay: true, set: func(o *options, v string) error {
+		o.profileName = v
+		return nil
+	}},
+	"as": {value: "Role", mode: modeReplay, set: func(o *options, v string) error {
+		if v != modeClient && v != modeServer {
+			return errors.New("--as must be 'client' or 'server'")
+		}
+		o.role = v
+		return nil
+	}},
+	"speed": {value: "Speed", arg: "<n>x|max", mode: modeReplay, set: func(o *options, v string) error {
+		speed, err := parseSpeed(v)
+		if err != nil {
+			return err
+		}
+		o.speed = speed
+		return nil
+	}},
+	"timeout": {value: "Timeout", arg: "<ms>", mode: modeReplay, set: func(o *options, v string) error {
+		ms, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || ms <= 0 {
+			return errors.New("Timeout must be a number of milliseconds (1 or greater)")
+		}
+		o.replayTimeout = time.Duration(ms) * time.Millisecond
+		return nil
+	}},
+	"host": {value: "IP address", mode: modeClient, set: func(o *options, v string) error {
+		o.host = v
+		return nil
+	}},
+	"port": {value: "Port", set: func(o *options, v string) error {
+		o.port = v
+		return nil
+	}},
+	"terminator": {value: "Terminator", set: func(o *options, v string) error {
+		o.terminator = v
+		return nil
+	}},
+	"echo": {mode: modeServer, set: flag(func(o *options, on bool) {
+		o.echo = on
+	})},
+	"no-echo": {mode: modeServer, set: flag(func(o *options, on bool) {
+		o.echo = !on
+	})},
+	"modbus-map": {value: "File path", arg: "<file>", mode: modeServer, set: func(o *options, v string) error {
+		o.modbusMapPath = v
+		return nil
+	}},
+	"http-response": {value: "File path", arg: "<file>", mode: modeServer, set: func(o *options, v string) error {
+		o.httpResponsePath = v
+		return nil
+	}},
+	"nmea-rate": {value: "Rate", arg: "<rate>", mode: modeServer, set: func(o *options, v string) error {
+		period, err := parseNMEARate(v)
+		if err != nil {
+			return err
+		}
+		o.nmeaPeriod = period
+		return nil
+	}},
+	"query-timeout": {value: "Duration", arg: "<duration>", mode: modeClient, set: func(o *options, v string) error {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return errors.New("Query timeout must be a duration like 5s or 500ms")
+		}
+		o.queryTimeout = timeout
+		return nil
+	}},
+	"scpi-blocks": {value: "Directory", arg: "<dir>", mode: modeClient, set: func(o *options, v string) error {
+		scpiBlockDir = v
+		return nil
+	}},
+	"buffer-size": {value: "Buffer size", arg: "<size>", replay: true, set: func(o *options, v string) error {
+		size, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return errors.New("Buffer size must be a number")
+		}
+		if size <= 0 {
+			return errors.New("Buffer size must be 1 or greater")
+		}
+		o.bufferSize = size
+		return nil
+	}},
+	"color": {replay: true, set: flag(func(o *options, on bool) {
+		colorEnabled = on
+	})},
+	"no-color": {replay: true, set: flag(func(o *options, on bool) {
+		colorEnabled = !on
+	})},
+	"record": {value: "File path", arg: "<file>", set: func(o *options, v string) error {
+		o.recordPath = v
+		return nil
+	}},
+	"pcap": {value: "File path", arg: "<file>", set: func(o *options, v string) error {
+		o.pcapPath = v
+		return nil
+	}},
+	"display": {value: "Display mode", arg: "<mode>", replay: true, set: func(o *options, v string) error {
+		mode, err := parseDisplayMode(v)
+		if err != nil {
+			return err
+		}
+		displayMode = mode
+		return nil
+	}},
+	"encoding": {value: "Encoding", arg: "<name>", replay: true, set: func(o *options, v string) error {
+		name, enc, err := parseEncoding(v)
+		if err != nil {
+			return err
+		}
+		encodingName, textEncoding = name, enc
+		return nil
+	}},
+	"checksum": {value: "Checksum algorithm", arg: "<algo>", replay: true, set: func(o *options, v string) error {
+		spec, err := parseChecksum(v)
+		if err != nil {
+			return err
+		}
+		checksum = spec
+		return nil
+	}},
+	"protocol": {value: "Protocol", arg: "<name>", set: func(o *options, v string) error {
+		p, err := parseProtocol(v)
+		if err != nil {
+			return err
+		}
+		activeProtocol = p
+		return nil
+	}},
+	"length-prefix": {value: "Length prefix size", arg: "<size>", set: func(o *options, v string) error {
+		spec, err := framing.ParseLengthPrefix(v)
+		if err != nil {
+			return err
+		}
+		lengthPrefix = spec
+		return nil
+	}},
+	"decode": {value: "Format", arg: "<format>", replay: true, set: func(o *options, v string) error {
+		d, err := parseDecoder(v)
+		if err != nil {
+			return err
+		}
+		activeDecoder = d
+		return nil
+	}},
+	"jq": {value: "Field path", arg: "<path>", replay: true, set: func(o *options, v string) error {
+		paths, err := parseJSONPaths(v)
+		if err != nil {
+			return err
+		}
+		jsonPaths = paths
+		return nil
+	}},
+	"proto": {value: "Descriptor set file", arg: "<file>", replay: true, set: func(o *options, v string) error {
+		protoPath = v
+		return nil
+	}},
+	"proto-message": {value: "Message type", arg: "<name>", replay: true, set: func(o *options, v string) error {
+		protoMessageName = v
+		return nil
+	}},
+	"layout": {value: "Layout file", arg: "<file>", replay: true, set: func(o *options, v string) error {
+		layoutPath = v
+		return nil
+	}},
+	"telnet": {set: flag(func(o *options, on bool) {
+		telnetEnabled = on
+	})},
+	"telnet-accept": {value: "Telnet options", arg: "<options>", set: func(o *options, v string) error {
+		accept, err := parseTelnetAccept(v)
+		if err != nil {
+			return err
+		}
+		telnetAccept = accept
+		return nil
+	}},
+	"log-format": {value: "Log format", arg: "<format>", replay: true, set: func(o *options, v string) error {
+		format, err := parseLogFormat(v)
+		if err != nil {
+			return err
+		}
+		logFormat = format
+		return nil
+	}},
+	"log-file": {value: "File path", arg: "<file>", set: func(o *options, v string) error {
+		logFilePath = v
+		return nil
+	}},
+	"log-file-format": {value: "Log format", arg: "<format>", set: func(o *options, v string) error {
+		format, err := parseLogFormat(v)
+		if err != nil {
+			return err
+		}
+		logFileFormat = format
+		return nil
+	}},
+	"log-max-size": {value: "Size", arg: "<size>", set: func(o *options, v string) error {
+		size, err := parseSize(v)
+		if err != nil {
+			return err
+		}
+		logMaxSize = size
+		return nil
+	}},
+	"log-rotate": {value: "Rotate interval", arg: "<interval>", set: func(o *options, v string) error {
+		interval, err := parseRotateInterval(v)
+		if err != nil {
+			return err
+		}
+		logRotateEvery = interval
+		return nil
+	}},
+	"log-max-files": {value: "Count", arg: "<count>", set: func(o *options, v string) error {
+		count, err := strconv.Atoi(v)
+		if err != nil || count < 0 {
+			return fmt.Errorf("--log-max-files must be a number (0 or greater)")
+		}
+		logMaxFiles = count
+		return nil
+	}},
+	"log-compress": {set: flag(func(o *options, on bool) {
+		logCompress = on
+	})},
+}
+
+// flag returns the setter of a flag option: true on the command line, true or false in profiles
+func flag(set func(o *options, on bool)) func(o *options, value string) error {
+	return func(o *options, value string) error {
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("value must be true or false, not '%s'", value)
+		}
+		set(o, on)
+		return nil
+	}
+}
+
+// parseOptions parses the arguments of server, client or replay mode after -s, -c or replay.
+// The values of a --profile are set first, so options on the command line override them.
+func parseOptions(mode string, args []string) (*options, error) {
+	o := &options{mode: mode, echo: true, bufferSize: 1024, queryTimeout: 5 * time.Second,
+		speed: 1, replayTimeout: 5 * time.Second}
+	if mode == modeServer {
+		o.terminator = "LF" // Default
+	}
+	colorEnabled = true // Default color enabled
+
+	// Arguments are set after the profile is read, --config and --profile right away
+	var given []func() error
+	positional := 0 // Arguments that are not options
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			position := positional
+			given = append(given, func() error { return o.setArgument(arg, position) })
+			positional++
+			continue
+		}
+
+		name := strings.TrimPrefix(arg, "--")
+		value := "true" // Flags
+		if spec, ok := optionSpecs[name]; ok && spec.value != "" {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s must be specified after %s", spec.value, arg)
+			}
+			value = args[i+1]
+			i++ // Skip next argument
+		}
+		if name == "config" || name == "profile" {
+			if err := o.set(name, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		given = append(given, func() error { return o.set(name, value) })
+	}
+
+	if err := o.applyProfile(); err != nil {
+		return nil, err
+	}
+	for _, set := range given {
+		if err := set(); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// setArgument sets an argument that is not an option: the port and terminator in server
+// mode, the IP address, port and terminator in client mode, the record file and the address
+// in replay mode. LF, CR and CRLF are terminators wherever they are given in server and client mode.
+func (o *options) setArgument(arg string, position int) error {
+	if o.mode == modeReplay {
+		switch position {
+		case 0:
+			o.replayPath = arg
+		case 1:
+			o.address = arg
+		default:
+			return fmt.Errorf("Unexpected argument: %s", arg)
+		}
+		return nil
+	}
+	if upper := strings.ToUpper(arg); upper == "LF" || upper == "CR" || upper == "CRLF" {
+		o.terminator = arg
+		return nil
+	}
+	switch {
+	case o.mode == modeServer && position == 0:
+		o.port = arg
+	case o.mode == modeClient && position == 0:
+		o.host = arg
+	case o.mode == modeClient && position == 1:
+		o.port = arg
+	case o.mode == modeClient && position == 2:
+		o.terminator = arg
+	default:
+		return fmt.Errorf("Unexpected argument: %s", arg)
+	}
+	return nil
+}
+
+// set sets an option by name
+func (o *options) set(name, value string) error {
+	spec, ok := optionSpecs[name]
+	if !ok {
+		return fmt.Errorf("Unknown option: --%s", name)
+	}
+	if !spec.availableIn(o.mode) {
+		if o.mode == modeReplay {
+			return fmt.Errorf("--%s is not available in replay mode", name)
+		}
+		return fmt.Errorf("--%s is only available in %s mode", name, spec.mode)
+	}
+	return spec.set(o, value)
+}
+
+// usageLine returns the arguments and options of server, client or replay mode, like
+// "-s, --server <port> [terminator] [--no-echo] ...". The options of the mode only come first,
+// then the others, each sorted by name.
+func usageLine(mode string) string {
+	line := map[string]string{
+		modeServer: "-s, --server <port> [terminator]",
+		modeClient: "-c, --client <IP> <port> <terminator>",
+		modeReplay: "replay <file> --as client|server <addr>",
+	}[mode]
+	var own, shared []string
+	for name, spec := range optionSpecs {
+		if !spec.availableIn(mode) || (spec.value != "" && spec.arg == "") {
+			continue // Given as an argument
+		}
+		if spec.mode == mode {
+			own = append(own, name)
+		} else {
+			shared = append(shared, name)
+		}
+	}
+	sort.Strings(own)
+	sort.Strings(shared)
+	for _, name := range append(own, shared...) {
+		if arg := optionSpecs[name].arg; arg != "" {
+			line += fmt.Sprintf(" [--%s %s]", name, arg)
+		} else {
+			line += fmt.Sprintf(" [--%s]", name)
+		}
+	}
+	return line
+}
+
+// applyProfile sets the values of the profile given by --profile, from the file given
+// by --config or the default config file
+func (o *options) applyProfile() error {
+	if o.profileName == "" {
+		if o.configPath != "" {
+			return fmt.Errorf("--config requires --profile")
+		}
+		return nil
+	}
+	if o.configPath == "" {
+		path, err := defaultConfigPath()
+		if err != nil {
+			return err
+		}
+		o.configPath = path
+	}
+
+	values, err := loadProfile(o.configPath, o.profileName)
+	if err != nil {
+		return fmt.Errorf("Config file %s: %v", o.configPath, err)
+	}
+	for _, v := range values {
+		if v.name == "config" || v.name == "profile" {
+			return fmt.Errorf("Profile %s, line %d: %s cannot be set in a profile", o.profileName, v.line, v.name)
+		}
+		if err := o.set(v.name, v.value); err != nil {
+			return fmt.Errorf("Profile %s, line %d: %v", o.profileName, v.line, err)
+		}
+	}
+	return nil
+}
+
+// setup resolves the terminator, which the protocol may select, checks the combination of
+// options and loads the files they name, before the session starts
+func (o *options) setup() error {
+	o.terminator = strings.ToUpper(protocolTerminator(o.terminator))
+	switch o.terminator {
+	case "LF":
+		o.terminatorBytes = []byte{0x0A} // LF
+	case "CR":
+		o.terminatorBytes = []byte{0x0D} // CR
+	case "CRLF":
+		o.terminatorBytes = []byte{0x0D, 0x0A} // CRLF
+	default:
+		return fmt.Errorf("Terminator must be 'LF', 'CR' or 'CRLF'")
+	}
+
+	// The protocol frames messages, no terminator is added or removed
+	if protocolFraming() && activeProtocol.terminator == "" {
+		o.terminatorBytes = nil
+	}
+	if lengthPrefix != nil {
+		if activeProtocol != nil {
+			return fmt.Errorf("--length-prefix cannot be combined with --protocol")
+		}
+		// Messages are framed by their length instead of the terminator
+		o.terminatorBytes = nil
+	}
+	messageTerminator = o.terminatorBytes
+
+	if layoutPath != "" {
+		if activeDecoder != nil {
+			return fmt.Errorf("--layout cannot be combined with --decode")
+		}
+		if jsonPaths != nil {
+			return fmt.Errorf("--jq cannot be combined with --layout")
+		}
+		layout, err := loadLayout(layoutPath)
+		if err != nil {
+			return fmt.Errorf("Layout file %s: %v", layoutPath, err)
+		}
+		activeLayout = layout
+		activeDecoder = layoutDecoder
+	}
+	if jsonPaths != nil && activeDecoder == nil {
+		return fmt.Errorf("--jq requires --decode")
+	}
+	if protoMessageName != "" && protoPath == "" {
+		return fmt.Errorf("--proto-message requires --proto")
+	}
+	if protoPath != "" {
+		if activeDecoder != protobufDecoder {
+			return fmt.Errorf("--proto requires --decode protobuf")
+		}
+		message, err := loadProtoDescriptors(protoPath, protoMessageName)
+		if err != nil {
+			return fmt.Errorf("Descriptor set %s: %v", protoPath, err)
+		}
+		protoRoot = message
+	}
+
+	// Options of server mode
+	if o.modbusMapPath != "" {
+		if activeProtocol != modbusProtocol {
+			return fmt.Errorf("--modbus-map requires --protocol modbus")
+		}
+		registerMap, err := loadModbusMap(o.modbusMapPath)
+		if err != nil {
+			return fmt.Errorf("Register map %s: %v", o.modbusMapPath, err)
+		}
+		modbusMap = registerMap
+	}
+	if o.nmeaPeriod > 0 && activeProtocol != nmeaProtocol {
+		return fmt.Errorf("--nmea-rate requires --protocol nmea")
+	}
+	if o.httpResponsePath != "" {
+		if activeProtocol != httpProtocol {
+			return fmt.Errorf("--http-response requires --protocol http")
+		}
+		response, err := loadHTTPResponse(o.httpResponsePath)
+		if err != nil {
+			return fmt.Errorf("HTTP response file %s: %v", o.httpResponsePath, err)
+		}
+		httpResponse = response
+	}
+
+	// Options of client mode
+	if scpiBlockDir != "" {
+		if activeProtocol != scpiProtocol {
+			return fmt.Errorf("--scpi-blocks requires --protocol scpi")
+		}
+		if info, err := os.Stat(scpiBlockDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("Directory not found: %s", scpiBlockDir)
+		}
+	}
+	return nil
+}
+
+// printSettings prints the settings of the session shared by server and client mode
+func (o *options) printSettings() {
+	if o.profileName != "" {
+		fmt.Fprintf(textOutput, "Profile: %s (%s)\n", o.profileName, o.configPath)
+	}
+	if activeProtocol != nil {
+		fmt.Fprintf(textOutput, "Protocol: %s\n", protocolName())
+	}
+	if o.terminatorBytes != nil {
+		fmt.Fprintf(textOutput, "Terminator: %s (0x%X)\n", o.terminator, o.terminatorBytes)
+	}
+	if lengthPrefix != nil {
+		fmt.Fprintf(textOutput, "Length prefix: %s\n", lengthPrefixText())
+	}
+	fmt.Fprintf(textOutput, "Buffer size: %d bytes\n", o.bufferSize)
+	if textEncoding != nil {
+		fmt.Fprintf(textOutput, "Encoding: %s\n", encodingName)
+	}
+	if checksum != nil {
+		fmt.Fprintf(textOutput, "Checksum: %s\n", checksum)
+	}
+	if activeLayout != nil {
+		fmt.Fprintf(textOutput, "Layout: %s (%s)\n", layoutPath, layoutFieldNames())
+	} else if activeDecoder != nil {
+		fmt.Fprintf(textOutput, "Decode: %s\n", activeDecoder.name)
+	}
+	if protoRoot != nil {
+		fmt.Fprintf(textOutput, "Message type: %s (%s)\n", protoRoot.name, protoPath)
+	}
+	if jsonPaths != nil {
+		fmt.Fprintf(textOutput, "Fields: %s\n", jsonPathsText())
+	}
+	if telnetEnabled {
+		fmt.Fprintf(textOutput, "Telnet: enabled (accept: %s)\n", telnetAcceptText())
+	}
+}
+
+// printOutputs prints the files the session is written to
+func (o *options) printOutputs() {
+	if o.recordPath != "" {
+		fmt.Fprintf(textOutput, "Recording to: %s\n", o.recordPath)
+	}
+	if o.pcapPath != "" {
+		fmt.Fprintf(textOutput, "Packet capture to: %s\n", o.pcapPath)
+	}
+	if logFilePath != "" {
+		fmt.Fprintf(textOutput, "Log file: %s (%s)\n", logFilePath, logFileFormat)
+	}
+}
+
+// defaultConfigPath returns coe.yaml in the user's config directory, like ~/.config/coe/coe.yaml
+func defaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "coe", "coe.yaml"), nil
+}
+
+// configFile is the config file with named profiles.
+// Only the selected profile is decoded, so errors in others don't matter.
+type configFile struct {
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+}
+
+// profile is the option values of a named profile, in file order
+type profile []profileValue
+
+type profileValue struct {
+	name  string // Option name without --
+	value string
+	line  int
+}
+
+func (p *profile) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: profile must map option names to values", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: value of %s must be a single value", value.Line, key.Value)
+		}
+		*p = append(*p, profileValue{name: key.Value, value: value.Value, line: key.Line})
+	}
+	return nil
+}
+
+// loadProfile reads a profile from a config file
+func loadProfile(path, name string) (profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config configFile
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	node, ok := config.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(config.Profiles))
+		for n := range config.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("profile not found: %s (no profiles)", name)
+		}
+		return nil, fmt.Errorf("profile not found: %s (profiles: %s)", name, strings.Join(names, ", "))
+	}
+	var p profile
+	if err := node.Decode(&p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file with profiles and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "coe.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseOptionsProfile(t *testing.T) {
+	path := writeConfig(t, `profiles:
+  scope:
+    host: 192.168.1.50
+    port: 5025
+    terminator: CR
+    buffer-size: 2048
+    record: scope.jsonl
+`)
+	tests := []struct {
+		name    string
+		args    []string
+		host    string
+		port    string
+		term    string
+		buffer  int
+		record  string
+		profile string
+	}{
+		{"profile only", []string{"--profile", "scope", "--config", path},
+			"192.168.1.50", "5025", "CR", 2048, "scope.jsonl", "scope"},
+		{"arguments override the profile", []string{"10.0.0.1", "--config", path, "--buffer-size", "512", "--profile", "scope", "LF"},
+			"10.0.0.1", "5025", "LF", 512, "scope.jsonl", "scope"},
+		{"option value like --profile", []string{"127.0.0.1", "8080", "CRLF", "--record", "--profile"},
+			"127.0.0.1", "8080", "CRLF", 1024, "--profile", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := parseOptions(modeClient, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.host != tt.host || o.port != tt.port || o.terminator != tt.term || o.bufferSize != tt.buffer ||
+				o.recordPath != tt.record || o.profileName != tt.profile {
+				t.Errorf("options = %+v", o)
+			}
+		})
+	}
+}
+
+func TestParseOptionsErrors(t *testing.T) {
+	path := writeConfig(t, `profiles:
+  nested:
+    profile: other
+  server-only:
+    no-echo: true
+`)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--config", path}, "--config requires --profile"},
+		{[]string{"--profile", "nested", "--config", path}, "profile cannot be set in a profile"},
+		{[]string{"--profile", "server-only", "--config", path}, "only available in server mode"},
+		{[]string{"--profile", "missing", "--config", path}, "profile not found: missing (profiles: nested, server-only)"},
+		{[]string{"127.0.0.1", "8080", "LF", "--profile"}, "Profile name must be specified after --profile"},
+		{[]string{"127.0.0.1", "8080", "LF", "--nope"}, "Unknown option: --nope"},
+	}
+	for _, tt := range tests {
+		_, err := parseOptions(modeClient, tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseOptions(%q) = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestSetupTerminator(t *testing.T) {
+	tests := []struct {
+		terminator string
+		want       string
+	}{
+		{"lf", "\n"},
+		{"CR", "\r"},
+		{"crlf", "\r\n"},
+	}
+	for _, tt := range tests {
+		o := &options{mode: modeClient, terminator: tt.terminator}
+		if err := o.setup(); err != nil {
+			t.Fatalf("%s: %v", tt.terminator, err)
+		}
+		if string(o.terminatorBytes) != tt.want || o.terminator != strings.ToUpper(tt.terminator) {
+			t.Errorf("%s: terminator %q (%q), want %q", tt.terminator, o.terminator, o.terminatorBytes, tt.want)
+		}
+	}
+
+	o := &options{mode: modeClient, terminator: "NUL"}
+	if err := o.setup(); err == nil || err.Error() != "Terminator must be 'LF', 'CR' or 'CRLF'" {
+		t.Errorf("setup() = %v for an unknown terminator", err)
+	}
+}
+
+func TestParseOptionsReplay(t *testing.T) {
+	path := writeConfig(t, `profiles:
+  fast:
+    speed: max
+    buffer-size: 4096
+  device:
+    host: 192.168.1.50
+    length-prefix: 2
+`)
+	o, err := parseOptions(modeReplay, []string{"session.jsonl", "--as", "client", "127.0.0.1:8080",
+		"--profile", "fast", "--config", path, "--timeout", "250"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.replayPath != "session.jsonl" || o.role != modeClient || o.address != "127.0.0.1:8080" ||
+		o.speed != 0 || o.bufferSize != 4096 || o.replayTimeout != 250*time.Millisecond {
+		t.Errorf("options = %+v", o)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"session.jsonl", "--as", "peer", "127.0.0.1:8080"}, "--as must be 'client' or 'server'"},
+		{[]string{"session.jsonl", "--as", "client", "127.0.0.1:8080", "--length-prefix", "2"}, "--length-prefix is not available in replay mode"},
+		{[]string{"session.jsonl", "--as", "client", "127.0.0.1:8080", "--profile", "device", "--config", path}, "Profile device, line 6: --host is not available in replay mode"},
+		{[]string{"session.jsonl", "--as", "client", "127.0.0.1:8080", "extra"}, "Unexpected argument: extra"},
+		{[]string{"session.jsonl", "--speed", "0x"}, "speed must be a positive factor"},
+		{[]string{"session.jsonl", "--timeout", "0"}, "Timeout must be a number of milliseconds"},
+	}
+	for _, tt := range tests {
+		_, err := parseOptions(modeReplay, tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseOptions(%q) = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+
+	if _, err := parseOptions(modeClient, []string{"127.0.0.1", "8080", "LF", "--speed", "2x"}); err == nil ||
+		err.Error() != "--speed is only available in replay mode" {
+		t.Errorf("parseOptions(client, --speed) = %v", err)
+	}
+}
+
+func TestUsageLine(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    []string
+		notWant []string
+	}{
+		{modeServer, []string{"-s, --server <port> [terminator] [--echo]", "[--modbus-map <file>]", "[--profile <name>]", "[--telnet]"},
+			[]string{"--query-timeout", "--speed", "--port", "--terminator"}},
+		{modeClient, []string{"-c, --client <IP> <port> <terminator> [--query-timeout <duration>]", "[--log-file <file>]"},
+			[]string{"--no-echo", "--host", "--as"}},
+		{modeReplay, []string{"replay <file> --as client|server <addr> [--speed <n>x|max]", "[--decode <format>]"},
+			[]string{"--as client|server <addr> [--as", "--record", "--telnet"}},
+	}
+	for _, tt := range tests {
+		line := usageLine(tt.mode)
+		for _, want := range tt.want {
+			if !strings.Contains(line, want) {
+				t.Errorf("%s: usage line %q does not contain %q", tt.mode, line, want)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(line, notWant) {
+				t.Errorf("%s: usage line %q contains %q", tt.mode, line, notWant)
+			}
+		}
+	}
+
+	// Every option with a value is shown with it, unless it is given as an argument
+	arguments := map[string]bool{"host": true, "port": true, "terminator": true, "as": true}
+	for name, spec := range optionSpecs {
+		if spec.value != "" && spec.arg == "" && !arguments[name] {
+			t.Errorf("--%s has no value in usage lines", name)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// PCAPNG block types
+const (
+	pcapngSectionHeader    = 0x0A0D0D0A
+	pcapngInterface        = 0x00000001
+	pcapngEnhancedPacket   = 0x00000006
+	pcapngByteOrderMagic   = 0x1A2B3C4D
+	pcapngLinkTypeEthernet = 1
+)
+
+// TCP flags
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+const pcapMaxSegment = 1460 // Payload per synthesized TCP segment
+
+// Locally administered MAC addresses for the synthesized Ethernet headers
+var (
+	pcapLocalMAC  = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	pcapRemoteMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// pcapFlow holds the TCP state of one connection
+type pcapFlow struct {
+	local, remote       *net.TCPAddr
+	localSeq, remoteSeq uint32
+}
+
+// pcapWriter writes the traffic coe sends and receives as synthesized
+// Ethernet/IP/TCP packets to a PCAPNG file, without capturing from the network.
+// A nil writer discards all packets.
+type pcapWriter struct {
+	mu    sync.Mutex
+	file  *os.File
+	out   *bufio.Writer
+	flows map[net.Conn]*pcapFlow
+}
+
+var capture *pcapWriter
+
+// openPcap creates the PCAPNG file and writes the section and interface headers
+func openPcap(path string) (*pcapWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &pcapWriter{file: file, out: bufio.NewWriter(file), flows: make(map[net.Conn]*pcapFlow)}
+
+	// Section Header Block
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1) // Major version
+	binary.LittleEndian.PutUint16(shb[6:], 0) // Minor version
+	binary.LittleEndian.PutUint64(shb[8:], 0xFFFFFFFFFFFFFFFF)
+	p.writeBlock(pcapngSectionHeader, shb)
+
+	// Interface Description Block with nanosecond timestamps (if_tsresol = 9)
+	idb := make([]byte, 8, 20)
+	binary.LittleEndian.PutUint16(idb[0:], pcapngLinkTypeEthernet)
+	binary.LittleEndian.PutUint32(idb[4:], 0) // No snap length limit
+	idb = append(idb, 9, 0, 1, 0, 9, 0, 0, 0) // if_tsresol
+	idb = append(idb, 0, 0, 0, 0)             // opt_endofopt
+	p.writeBlock(pcapngInterface, idb)
+
+	if err := p.out.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// connected writes the TCP handshake of a new connection.
+// outbound is true when coe opened the connection (client mode).
+func (p *pcapWriter) connected(conn net.Conn, outbound bool) {
+	if p == nil {
+		return
+	}
+	local, ok1 := conn.LocalAddr().(*net.TCPAddr)
+	remote, ok2 := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	flow := &pcapFlow{local: local, remote: remote, localSeq: 1000, remoteSeq: 5000}
+	p.flows[conn] = flow
+
+	// The initial sequence numbers are arbitrary; SYN consumes one
+	if outbound {
+		p.writeSegment(flow, true, tcpSYN, nil)
+		flow.localSeq++
+		p.writeSegment(flow, false, tcpSYN|tcpACK, nil)
+		flow.remoteSeq++
+		p.writeSegment(flow, true, tcpACK, nil)
+	} else {
+		p.writeSegment(flow, false, tcpSYN, nil)
+		flow.remoteSeq++
+		p.writeSegment(flow, true, tcpSYN|tcpACK, nil)
+		flow.localSeq++
+		p.writeSegment(flow, false, tcpACK, nil)
+	}
+	p.out.Flush()
+}
+
+// sent writes data that coe wrote to conn
+func (p *pcapWriter) sent(conn net.Conn, data []byte) {
+	p.payload(conn, true, data)
+}
+
+// received writes data that coe read from conn
+func (p *pcapWriter) received(conn net.Conn, data []byte) {
+	p.payload(conn, false, data)
+}
+
+func (p *pcapWriter) payload(conn net.Conn, fromLocal bool, data []byte) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	flow, ok := p.flows[conn]
+	if !ok {
+		return
+	}
+
+	for len(data) > 0 {
+		segment := data
+		if len(segment) > pcapMaxSegment {
+			segment = segment[:pcapMaxSegment]
+		}
+		p.writeSegment(flow, fromLocal, tcpPSH|tcpACK, segment)
+		if fromLocal {
+			flow.localSeq += uint32(len(segment))
+		} else {
+			flow.remoteSeq += uint32(len(segment))
+		}
+		data = data[len(segment):]
+	}
+	p.out.Flush()
+}
+
+// disconnected writes the FIN exchange of a closed connection
+func (p *pcapWriter) disconnected(conn net.Conn) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	flow, ok := p.flows[conn]
+	if !ok {
+		return
+	}
+	delete(p.flows, conn)
+
+	p.writeSegment(flow, true, tcpFIN|tcpACK, nil)
+	flow.localSeq++
+	p.writeSegment(flow, false, tcpFIN|tcpACK, nil)
+	flow.remoteSeq++
+	p.writeSegment(flow, true, tcpACK, nil)
+	p.out.Flush()
+}
+
+// Close flushes and closes the PCAPNG file
+func (p *pcapWriter) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	p.out.Flush()
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+// writeSegment writes one TCP segment as an Enhanced Packet Block
+func (p *pcapWriter) writeSegment(flow *pcapFlow, fromLocal bool, flags byte, payload []byte) {
+	if p.file == nil {
+		return
+	}
+	src, dst := flow.local, flow.remote
+	srcMAC, dstMAC := pcapLocalMAC, pcapRemoteMAC
+	seq, ack := flow.localSeq, flow.remoteSeq
+	if !fromLocal {
+		src, dst = dst, src
+		srcMAC, dstMAC = dstMAC, srcMAC
+		seq, ack = ack, seq
+	}
+	if flags&tcpSYN != 0 && flags&tcpACK == 0 {
+		ack = 0
+	}
+
+	// TCP header
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4 // Header length: 5 words
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // Window
+	tcp = append(tcp, payload...)
+
+	// IP header and TCP checksum
+	var frame []byte
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		ip := make([]byte, 20)
+		ip[0] = 0x45 // Version 4, header length 5 words
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		ip[6] = 0x40 // Don't fragment
+		ip[8] = 64   // TTL
+		ip[9] = 6    // TCP
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], internetChecksum(ip, 0))
+
+		pseudo := append(append([]byte{}, src4...), dst4...)
+		pseudo = append(pseudo, 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+		binary.BigEndian.PutUint16(tcp[16:], internetChecksum(tcp, checksumSum(pseudo)))
+
+		frame = append(ethernetHeader(dstMAC, srcMAC, 0x0800), ip...)
+	} else {
+		ip := make([]byte, 40)
+		ip[0] = 0x60 // Version 6
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6  // Next header: TCP
+		ip[7] = 64 // Hop limit
+		copy(ip[8:], src.IP.To16())
+		copy(ip[24:], dst.IP.To16())
+
+		pseudo := append(append([]byte{}, ip[8:40]...), 0, 0, byte(len(tcp)>>8), byte(len(tcp)), 0, 0, 0, 6)
+		binary.BigEndian.PutUint16(tcp[16:], internetChecksum(tcp, checksumSum(pseudo)))
+
+		frame = append(ethernetHeader(dstMAC, srcMAC, 0x86DD), ip...)
+	}
+	frame = append(frame, tcp...)
+
+	// Enhanced Packet Block
+	ts := uint64(time.Now().UnixNano())
+	epb := make([]byte, 20, 20+len(frame)+3)
+	binary.LittleEndian.PutUint32(epb[0:], 0) // Interface ID
+	binary.LittleEndian.PutUint32(epb[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(len(frame)))
+	epb = append(epb, frame...)
+	p.writeBlock(pcapngEnhancedPacket, epb)
+}
+
+// writeBlock writes a PCAPNG block, padding the body to 32 bits
+func (p *pcapWriter) writeBlock(blockType uint32, body []byte) {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	length := uint32(12 + len(body))
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header[0:], blockType)
+	binary.LittleEndian.PutUint32(header[4:], length)
+	trailer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(trailer, length)
+	p.out.Write(header)
+	p.out.Write(body)
+	p.out.Write(trailer)
+}
+
+func ethernetHeader(dst, src []byte, etherType uint16) []byte {
+	header := make([]byte, 14)
+	copy(header[0:], dst)
+	copy(header[6:], src)
+	binary.BigEndian.PutUint16(header[12:], etherType)
+	return header
+}
+
+// checksumSum returns the one's complement sum of data as 16-bit words
+func checksumSum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// internetChecksum calculates the IP/TCP checksum of data, starting from initial (pseudo header sum)
+func internetChecksum(data []byte, initial uint32) uint16 {
+	sum := initial + checksumSum(data)
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pcapTestConn is a connection with TCP addresses only
+type pcapTestConn struct {
+	net.Conn
+}
+
+func (pcapTestConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+}
+
+func (pcapTestConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+
+func TestPcapBlockLayout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.pcapng")
+	p, err := openPcap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &pcapTestConn{}
+	p.connected(conn, false)
+	p.received(conn, []byte("hello"))
+	p.sent(conn, bytes.Repeat([]byte{'x'}, 3000))
+	p.disconnected(conn)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []uint32
+	var payloads [][]byte
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block: % X", data)
+		}
+		blockType := binary.LittleEndian.Uint32(data[0:])
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatalf("block %d: invalid length %d", len(types), length)
+		}
+		if trailer := binary.LittleEndian.Uint32(data[length-4:]); trailer != length {
+			t.Fatalf("block %d: trailing length %d, want %d", len(types), trailer, length)
+		}
+		types = append(types, blockType)
+		if blockType == pcapngEnhancedPacket {
+			captured := binary.LittleEndian.Uint32(data[20:])
+			frame := data[28 : 28+captured]
+			ip := frame[14:34]
+			if internetChecksum(ip, 0) != 0 {
+				t.Errorf("block %d: bad IP checksum", len(types)-1)
+			}
+			payloads = append(payloads, frame[54:])
+		}
+		data = data[length:]
+	}
+
+	if types[0] != pcapngSectionHeader || types[1] != pcapngInterface {
+		t.Fatalf("first blocks = %X, want section header and interface", types[:2])
+	}
+	// Handshake, one received segment, three sent segments and the FIN exchange
+	wantSizes := []int{0, 0, 0, 5, 1460, 1460, 80, 0, 0, 0}
+	if len(payloads) != len(wantSizes) {
+		t.Fatalf("got %d packets, want %d", len(payloads), len(wantSizes))
+	}
+	for i, size := range wantSizes {
+		if len(payloads[i]) != size {
+			t.Errorf("packet %d: payload of %d bytes, want %d", i, len(payloads[i]), size)
+		}
+	}
+	if string(payloads[3]) != "hello" {
+		t.Errorf("received payload = %q", payloads[3])
+	}
+}
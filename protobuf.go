@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var protobufDecoder = &decoder{
+	name:   "protobuf",
+	label:  "protobuf",
+	decode: decodeProtobuf,
+	binary: true,
+}
+
+// Protobuf wire types
+const (
+	protoVarint = 0
+	protoI64    = 1
+	protoLen    = 2
+	protoSGroup = 3
+	protoEGroup = 4
+	protoI32    = 5
+)
+
+// Field types of FieldDescriptorProto.Type
+const (
+	protoTypeDouble   = 1
+	protoTypeFloat    = 2
+	protoTypeInt64    = 3
+	protoTypeUint64   = 4
+	protoTypeInt32    = 5
+	protoTypeFixed64  = 6
+	protoTypeFixed32  = 7
+	protoTypeBool     = 8
+	protoTypeString   = 9
+	protoTypeGroup    = 10
+	protoTypeMessage  = 11
+	protoTypeBytes    = 12
+	protoTypeUint32   = 13
+	protoTypeEnum     = 14
+	protoTypeSfixed32 = 15
+	protoTypeSfixed64 = 16
+	protoTypeSint32   = 17
+	protoTypeSint64   = 18
+)
+
+const protoLabelRepeated = 3
+
+// protoEntry is one field read from the wire
+type protoEntry struct {
+	number   int
+	wireType int
+	value    uint64       // Varint, I64 and I32 values
+	data     []byte       // LEN data
+	group    []protoEntry // Fields of a group
+}
+
+// protoMessage is a message type of a descriptor set
+type protoMessage struct {
+	name   string // Full name like "sensor.Reading"
+	fields map[int]*protoField
+}
+
+// protoField is a field of a message type
+type protoField struct {
+	name     string
+	kind     int // One of protoType*
+	repeated bool
+	typeName string         // Message or enum type like ".sensor.Unit"
+	message  *protoMessage  // Resolved message type
+	enum     map[int]string // Resolved enum values
+}
+
+var (
+	protoPath        string        // Descriptor set file of --proto
+	protoMessageName string        // Message type of --proto-message
+	protoRoot        *protoMessage // Message type of received frames (nil: decode without names)
+)
+
+// decodeProtobuf formats a protobuf payload as a JSON-like tree. Without --proto, fields are
+// named by number and LEN fields shown as text, nested message or bytes, whichever fits.
+func decodeProtobuf(payload []byte, colored bool) (string, error) {
+	value, err := decodeProtoMessage(payload, protoRoot, 0)
+	if err != nil {
+		return "", err
+	}
+	return formatTree(value, colored), nil
+}
+
+// readProtoFields reads the fields of a message, or of a group up to its end when group > 0
+func readProtoFields(r *byteReader, group int, depth int) ([]protoEntry, error) {
+	if depth > maxDecodeDepth {
+		return nil, r.errorf("nested deeper than %d levels", maxDecodeDepth)
+	}
+	var entries []protoEntry
+	for r.pos < len(r.data) {
+		start := r.pos
+		tag, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		entry := protoEntry{number: int(tag >> 3), wireType: int(tag & 7)}
+		if tag>>3 == 0 || tag>>3 > 1<<29-1 {
+			r.pos = start
+			return nil, r.errorf("invalid field number %d", tag>>3)
+		}
+		switch entry.wireType {
+		case protoVarint:
+			entry.value, err = r.varint()
+		case protoI64:
+			entry.value, err = r.littleEndian(8)
+		case protoI32:
+			entry.value, err = r.littleEndian(4)
+		case protoLen:
+			var length uint64
+			if length, err = r.varint(); err == nil {
+				if length > uint64(len(r.data)-r.pos) {
+					err = r.errorf("length %d exceeds the payload", length)
+				} else {
+					entry.data, err = r.next(int(length))
+				}
+			}
+		case protoSGroup:
+			entry.group, err = readProtoFields(r, entry.number, depth+1)
+		case protoEGroup:
+			if entry.number != group {
+				r.pos = start
+				return nil, r.errorf("unexpected end of group %d", entry.number)
+			}
+			return entries, nil
+		default:
+			r.pos = start
+			return nil, r.errorf("invalid wire type %d", entry.wireType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if group > 0 {
+		return nil, r.errorf("missing end of group %d", group)
+	}
+	return entries, nil
+}
+
+// varint reads a base 128 varint
+func (r *byteReader) varint() (uint64, error) {
+	var value uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b&0x7F) << shift
+		if b < 0x80 {
+			return value, nil
+		}
+	}
+	return 0, r.errorf("varint longer than 10 bytes")
+}
+
+// littleEndian reads a fixed size little-endian number
+func (r *byteReader) littleEndian(size int) (uint64, error) {
+	data, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for i := size - 1; i >= 0; i-- {
+		value = value<<8 | uint64(data[i])
+	}
+	return value, nil
+}
+
+// decodeProtoMessage decodes a message into an object. Fields that occur more than once
+// or are repeated in the message type become arrays.
+func decodeProtoMessage(data []byte, message *protoMessage, depth int) (jsonObject, error) {
+	entries, err := readProtoFields(&byteReader{data: data}, 0, depth)
+	if err != nil {
+		return nil, err
+	}
+	return protoObject(entries, message, depth), nil
+}
+
+func protoObject(entries []protoEntry, message *protoMessage, depth int) jsonObject {
+	object := jsonObject{}
+	index := map[string]int{} // Position of each key in object
+	for _, entry := range entries {
+		var field *protoField
+		if message != nil {
+			field = message.fields[entry.number]
+		}
+		key := strconv.Itoa(entry.number)
+		if field != nil {
+			key = field.name
+		}
+		values := protoValues(entry, field, depth)
+
+		i, seen := index[key]
+		if !seen {
+			index[key] = len(object)
+			if len(values) == 1 && (field == nil || !field.repeated) {
+				object = append(object, jsonMember{key, values[0]})
+			} else {
+				object = append(object, jsonMember{key, values})
+			}
+			continue
+		}
+		if array, ok := object[i].value.([]interface{}); ok {
+			object[i].value = append(array, values...)
+		} else {
+			object[i].value = append([]interface{}{object[i].value}, values...)
+		}
+	}
+	return object
+}
+
+// protoValues returns the value of a field, or several for a packed repeated field
+func protoValues(entry protoEntry, field *protoField, depth int) []interface{} {
+	if field != nil {
+		if value, ok := protoTypedValue(entry, field, depth); ok {
+			return []interface{}{value}
+		}
+		if entry.wireType == protoLen && field.repeated && protoPackable(field.kind) {
+			if values, ok := protoPacked(entry.data, field); ok {
+				return values
+			}
+		}
+		// The wire type does not match the message type, decode without it
+	}
+
+	switch entry.wireType {
+	case protoVarint:
+		if entry.value > math.MaxInt64 {
+			return []interface{}{json.Number(strconv.FormatInt(int64(entry.value), 10))} // Negative int32 or int64
+		}
+		return []interface{}{json.Number(strconv.FormatUint(entry.value, 10))}
+	case protoI64:
+		return []interface{}{jsonLiteral(fmt.Sprintf("0x%016x", entry.value))}
+	case protoI32:
+		return []interface{}{jsonLiteral(fmt.Sprintf("0x%08x", entry.value))}
+	case protoSGroup:
+		return []interface{}{protoObject(entry.group, nil, depth+1)}
+	}
+	return []interface{}{protoGuess(entry.data, depth)}
+}
+
+// protoGuess decodes LEN data without a message type: printable text as string,
+// otherwise a nested message if it parses as one, otherwise bytes
+func protoGuess(data []byte, depth int) interface{} {
+	if len(data) > 0 && printableText(data) {
+		return string(data)
+	}
+	if len(data) > 0 {
+		if object, err := decodeProtoMessage(data, nil, depth+1); err == nil {
+			return object
+		}
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	return data
+}
+
+// printableText reports whether data is UTF-8 text without control characters other than whitespace
+func printableText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// protoTypedValue decodes an entry by the type of its field. ok is false when
+// the wire type does not match.
+func protoTypedValue(entry protoEntry, field *protoField, depth int) (interface{}, bool) {
+	v := entry.value
+	switch entry.wireType {
+	case protoVarint:
+		switch field.kind {
+		case protoTypeInt64:
+			return json.Number(strconv.FormatInt(int64(v), 10)), true
+		case protoTypeInt32:
+			return json.Number(strconv.FormatInt(int64(int32(v)), 10)), true
+		case protoTypeUint64, protoTypeUint32:
+			return json.Number(strconv.FormatUint(v, 10)), true
+		case protoTypeSint32, protoTypeSint64:
+			return json.Number(strconv.FormatInt(int64(v>>1)^-int64(v&1), 10)), true
+		case protoTypeBool:
+			return v != 0, true
+		case protoTypeEnum:
+			if name, ok := field.enum[int(int32(v))]; ok {
+				return name, true
+			}
+			return json.Number(strconv.FormatInt(int64(int32(v)), 10)), true
+		}
+	case protoI64:
+		switch field.kind {
+		case protoTypeDouble:
+			return floatValue(math.Float64frombits(v), 64), true
+		case protoTypeFixed64:
+			return json.Number(strconv.FormatUint(v, 10)), true
+		case protoTypeSfixed64:
+			return json.Number(strconv.FormatInt(int64(v), 10)), true
+		}
+	case protoI32:
+		switch field.kind {
+		case protoTypeFloat:
+			return floatValue(float64(math.Float32frombits(uint32(v))), 32), true
+		case protoTypeFixed32:
+			return json.Number(strconv.FormatUint(v, 10)), true
+		case protoTypeSfixed32:
+			return json.Number(strconv.FormatInt(int64(int32(v)), 10)), true
+		}
+	case protoLen:
+		switch field.kind {
+		case protoTypeString:
+			return string(entry.data), true
+		case protoTypeBytes:
+			return entry.data, true
+		case protoTypeMessage:
+			object, err := decodeProtoMessage(entry.data, field.message, depth+1)
+			if err != nil {
+				return nil, false
+			}
+			return object, true
+		}
+	case protoSGroup:
+		if field.kind == protoTypeGroup {
+			return protoObject(entry.group, field.message, depth+1), true
+		}
+	}
+	return nil, false
+}
+
+// protoPackable reports whether a repeated field of this type can be packed
+func protoPackable(kind int) bool {
+	switch kind {
+	case protoTypeString, protoTypeBytes, protoTypeMessage, protoTypeGroup:
+		return false
+	}
+	return true
+}
+
+// protoPacked decodes the elements of a packed repeated field
+func protoPacked(data []byte, field *protoField) ([]interface{}, bool) {
+	r := &byteReader{data: data}
+	values := []interface{}{}
+	for r.pos < len(data) {
+		entry := protoEntry{}
+		var err error
+		switch field.kind {
+		case protoTypeDouble, protoTypeFixed64, protoTypeSfixed64:
+			entry.wireType = protoI64
+			entry.value, err = r.littleEndian(8)
+		case protoTypeFloat, protoTypeFixed32, protoTypeSfixed32:
+			entry.wireType = protoI32
+			entry.value, err = r.littleEndian(4)
+		default:
+			entry.wireType = protoVarint
+			entry.value, err = r.varint()
+		}
+		if err != nil {
+			return nil, false
+		}
+		value, _ := protoTypedValue(entry, field, 0)
+		values = append(values, value)
+	}
+	return values, true
+}
+
+// loadProtoDescriptors reads a descriptor set written by protoc --descriptor_set_out (-o)
+// and returns the message type with the given name. The name can be the full name
+// ("sensor.Reading") or the message name alone ("Reading"). With an empty name the file
+// must contain one top-level message type.
+func loadProtoDescriptors(path, name string) (*protoMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set, err := readProtoFields(&byteReader{data: data}, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %v", err)
+	}
+
+	messages := map[string]*protoMessage{}
+	enums := map[string]map[int]string{}
+	var fields []*protoField
+	var topLevel []string
+	for _, file := range set {
+		if file.number != 1 || file.wireType != protoLen {
+			continue
+		}
+		entries, err := readProtoFields(&byteReader{data: file.data}, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid descriptor set: %v", err)
+		}
+		pkg := ""
+		for _, entry := range entries {
+			if entry.number == 2 && entry.wireType == protoLen {
+				pkg = string(entry.data)
+			}
+		}
+		for _, entry := range entries {
+			if entry.wireType != protoLen {
+				continue
+			}
+			switch entry.number {
+			case 4: // message_type
+				full, err := readProtoMessageType(entry.data, pkg, messages, enums, &fields)
+				if err != nil {
+					return nil, err
+				}
+				topLevel = append(topLevel, full)
+			case 5: // enum_type
+				if err := readProtoEnumType(entry.data, pkg, enums); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// Resolve the types of message and enum fields
+	for _, field := range fields {
+		typeName := strings.TrimPrefix(field.typeName, ".")
+		field.message = messages[typeName]
+		field.enum = enums[typeName]
+	}
+
+	if name == "" {
+		if len(topLevel) == 1 {
+			return messages[topLevel[0]], nil
+		}
+		sort.Strings(topLevel)
+		return nil, fmt.Errorf("select a message type with --proto-message: %s", strings.Join(topLevel, ", "))
+	}
+	if message, ok := messages[strings.TrimPrefix(name, ".")]; ok {
+		return message, nil
+	}
+	var matches []string
+	for full := range messages {
+		if strings.HasSuffix(full, "."+name) {
+			matches = append(matches, full)
+		}
+	}
+	if len(matches) == 1 {
+		return messages[matches[0]], nil
+	}
+	names := make([]string, 0, len(messages))
+	for full := range messages {
+		names = append(names, full)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("message type %s not found, available: %s", name, strings.Join(names, ", "))
+}
+
+// readProtoMessageType reads a DescriptorProto with its nested types and returns its full name
+func readProtoMessageType(data []byte, scope string, messages map[string]*protoMessage, enums map[string]map[int]string, fields *[]*protoField) (string, error) {
+	entries, err := readProtoFields(&byteReader{data: data}, 0, 0)
+	if err != nil {
+		return "", fmt.Errorf("invalid descriptor set: %v", err)
+	}
+	message := &protoMessage{fields: map[int]*protoField{}}
+	for _, entry := range entries {
+		if entry.number == 1 && entry.wireType == protoLen {
+			message.name = protoFullName(scope, string(entry.data))
+		}
+	}
+	messages[message.name] = message
+
+	for _, entry := range entries {
+		if entry.wireType != protoLen {
+			continue
+		}
+		switch entry.number {
+		case 2: // field
+			field, number, err := readProtoFieldType(entry.data)
+			if err != nil {
+				return "", err
+			}
+			message.fields[number] = field
+			*fields = append(*fields, field)
+		case 3: // nested_type
+			if _, err := readProtoMessageType(entry.data, message.name, messages, enums, fields); err != nil {
+				return "", err
+			}
+		case 4: // enum_type
+			if err := readProtoEnumType(entry.data, message.name, enums); err != nil {
+				return "", err
+			}
+		}
+	}
+	return message.name, nil
+}
+
+// readProtoFieldType reads a FieldDescriptorProto
+func readProtoFieldType(data []byte) (*protoField, int, error) {
+	entries, err := readProtoFields(&byteReader{data: data}, 0, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid descriptor set: %v", err)
+	}
+	field := &protoField{}
+	number := 0
+	for _, entry := range entries {
+		switch {
+		case entry.number == 1 && entry.wireType == protoLen:
+			field.name = string(entry.data)
+		case entry.number == 3 && entry.wireType == protoVarint:
+			number = int(entry.value)
+		case entry.number == 4 && entry.wireType == protoVarint:
+			field.repeated = entry.value == protoLabelRepeated
+		case entry.number == 5 && entry.wireType == protoVarint:
+			field.kind = int(entry.value)
+		case entry.number == 6 && entry.wireType == protoLen:
+			field.typeName = string(entry.data)
+		}
+	}
+	return field, number, nil
+}
+
+// readProtoEnumType reads an EnumDescriptorProto
+func readProtoEnumType(data []byte, scope string, enums map[string]map[int]string) error {
+	entries, err := readProtoFields(&byteReader{data: data}, 0, 0)
+	if err != nil {
+		return fmt.Errorf("invalid descriptor set: %v", err)
+	}
+	name := ""
+	values := map[int]string{}
+	for _, entry := range entries {
+		switch {
+		case entry.number == 1 && entry.wireType == protoLen:
+			name = string(entry.data)
+		case entry.number == 2 && entry.wireType == protoLen:
+			valueEntries, err := readProtoFields(&byteReader{data: entry.data}, 0, 0)
+			if err != nil {
+				return fmt.Errorf("invalid descriptor set: %v", err)
+			}
+			valueName := ""
+			number := 0
+			for _, v := range valueEntries {
+				if v.number == 1 && v.wireType == protoLen {
+					valueName = string(v.data)
+				} else if v.number == 2 && v.wireType == protoVarint {
+					number = int(int32(v.value))
+				}
+			}
+			values[number] = valueName
+		}
+	}
+	enums[protoFullName(scope, name)] = values
+	return nil
+}
+
+// protoFullName joins a package or message name and a type name
+func protoFullName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestDecodeProtobuf(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+		err  bool
+	}{
+		{"varint", "\x08\x96\x01", "{\n  \"1\": 150\n}", false},
+		{"string", "\x12\x05hello", "{\n  \"2\": \"hello\"\n}", false},
+		{"nested message", "\x1a\x02\x08\x01", "{\n  \"3\": {\n    \"1\": 1\n  }\n}", false},
+		{"bytes", "\x1a\x03\x08\x01\x10", "{\n  \"3\": h'080110'\n}", false},
+		{"truncated varint", "\x08\x96", "", true},
+		{"length beyond the payload", "\x12\x05hel", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeProtobuf([]byte(tt.data), false)
+			if (err != nil) != tt.err || got != tt.want {
+				t.Errorf("decodeProtobuf() = %q, %v, want %q (error %t)", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
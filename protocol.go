@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yutat23/coe/framing"
+	"github.com/yutat23/coe/tcp"
+)
+
+// protocol is an application protocol selected with --protocol
+type protocol struct {
+	name string
+
+	// terminator is added to sent messages instead of the terminator argument, "LF", "CR" or "CRLF"
+	// ("": the protocol frames sent messages itself)
+	terminator string
+
+	// split frames the received data instead of the terminator (nil: use the terminator)
+	split framing.SplitFunc
+
+	// splitConn frames the received data of a connection instead of split, for frames that
+	// depend on what was sent on it. It is called once for each frame in order (nil: use split).
+	splitConn func(conn string, data []byte) int
+
+	// summary decodes a frame into one line shown in place of the message text.
+	// request reports whether the frame was sent by the client, and conn is the remote
+	// address of the connection. It has no side effects, as it runs once per sink.
+	summary func(frame []byte, request bool, conn string) string
+
+	// describe decodes a frame into lines shown below the message line.
+	// It returns "" when there is nothing to show.
+	describe func(frame []byte, request bool, conn string) string
+
+	// track updates the state kept for a connection, like the MQTT protocol level, from a
+	// sent or received frame before it is shown. It is called with a nil frame when the
+	// connection is closed (nil: no state).
+	track func(conn string, frame []byte, request bool)
+
+	// respond returns the answer of the server to a received frame instead of
+	// echo back, or nil for no answer (nil: no answers)
+	respond func(frame []byte) []byte
+
+	// command encodes a line typed in client mode into the frame to send
+	// (nil: send the line with the terminator)
+	command func(line string) ([]byte, error)
+
+	// query reports whether a line typed in client mode expects a response,
+	// so the client waits for it before sending the next line (nil: never)
+	query func(line string) bool
+}
+
+// Supported protocols for --protocol
+var protocols = map[string]*protocol{
+	"modbus": modbusProtocol,
+	"mqtt":   mqttProtocol,
+	"http":   httpProtocol,
+	"resp":   respProtocol,
+	"scpi":   scpiProtocol,
+	"nmea":   nmeaProtocol,
+}
+
+var activeProtocol *protocol // nil when --protocol is not given
+
+var serverSide bool // Frames received by this side are requests (server mode)
+
+// parseProtocol validates the value of --protocol
+func parseProtocol(value string) (*protocol, error) {
+	if p, ok := protocols[strings.ToLower(value)]; ok {
+		return p, nil
+	}
+	names := make([]string, 0, len(protocols))
+	for n := range protocols {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("protocol must be one of: %s", strings.Join(names, ", "))
+}
+
+// protocolName returns the name of the selected protocol, or "" for plain messages
+func protocolName() string {
+	if activeProtocol == nil {
+		return ""
+	}
+	return activeProtocol.name
+}
+
+// protocolTerminator returns the terminator argument, or the terminator of the selected protocol
+func protocolTerminator(terminator string) string {
+	if activeProtocol != nil && activeProtocol.terminator != "" {
+		return activeProtocol.terminator
+	}
+	return terminator
+}
+
+// protocolFraming reports whether messages are framed by the protocol instead of the terminator
+func protocolFraming() bool {
+	return activeProtocol != nil && activeProtocol.split != nil
+}
+
+// protocolSink passes the frames of each connection to the track function of the protocol.
+// It is the first of the sinks, so the state is updated before the sinks showing the frame.
+type protocolSink struct{}
+
+func (protocolSink) HandleEvent(e tcp.Event) {
+	if activeProtocol == nil || activeProtocol.track == nil {
+		return
+	}
+	switch e.Kind {
+	case tcp.Received:
+		activeProtocol.track(e.Addr, e.Data, serverSide)
+	case tcp.Sent:
+		activeProtocol.track(e.Addr, e.Data, !serverSide)
+	case tcp.Disconnected:
+		activeProtocol.track(e.Addr, nil, false)
+	}
+}
+
+// frameText returns the text shown for a message: the frame summary of the protocol, otherwise text
+func frameText(frame []byte, request bool, conn string, text string) string {
+	if activeProtocol == nil || activeProtocol.summary == nil {
+		return text
+	}
+	if summary := activeProtocol.summary(frame, request, conn); summary != "" {
+		return summary
+	}
+	return text
+}
+
+// describeFrame returns the decoded frame as indented lines following a message line,
+// or "" when no protocol is selected
+func describeFrame(frame []byte, request bool, conn string, colored bool) string {
+	if activeProtocol == nil {
+		return ""
+	}
+	var lines []string
+	if activeProtocol.summary != nil && displayMode == displayHexdump {
+		// The hexdump display has no message text to show the summary in
+		lines = append(lines, activeProtocol.summary(frame, request, conn))
+	}
+	if activeProtocol.describe != nil {
+		lines = append(lines, activeProtocol.describe(frame, request, conn))
+	}
+	description := strings.TrimSuffix(strings.Join(lines, "\n"), "\n")
+	if strings.TrimSpace(description) == "" {
+		return ""
+	}
+	if colored {
+		return "\n  " + colorPurple + strings.ReplaceAll(description, "\n", "\n  ") + colorReset
+	}
+	return "\n  " + strings.ReplaceAll(description, "\n", "\n  ")
+}
+
+// decodedFields returns the structured log field of a decoded frame
+func decodedFields(frame []byte, request bool, conn string) []logField {
+	if activeProtocol == nil {
+		return nil
+	}
+	var lines []string
+	if activeProtocol.summary != nil {
+		lines = append(lines, activeProtocol.summary(frame, request, conn))
+	}
+	if activeProtocol.describe != nil {
+		lines = append(lines, activeProtocol.describe(frame, request, conn))
+	}
+	if description := strings.Join(lines, "\n"); strings.TrimSpace(description) != "" {
+		return []logField{{"decoded", description}}
+	}
+	return nil
+}
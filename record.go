@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yutat23/coe/tcp"
+)
+
+// Session event types written to the record file
+const (
+	eventStart      = "start"
+	eventConnect    = "connect"
+	eventDisconnect = "disconnect"
+	eventReceived   = "received"
+	eventSent       = "sent"
+	eventFlush      = "flush" // Incomplete message flushed by timeout
+	eventError      = "error"
+	eventTelnet     = "telnet" // Telnet command received, with the answers sent
+)
+
+// recordEntry is one line of a session record file (JSON Lines)
+type recordEntry struct {
+	Time         string `json:"ts"`
+	Event        string `json:"event"`
+	Peer         string `json:"peer,omitempty"`
+	Direction    string `json:"dir,omitempty"`
+	Data         []byte `json:"data,omitempty"` // Encoded as base64
+	Length       int    `json:"len,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Command      string `json:"command,omitempty"` // Telnet command, like "DO ECHO"
+	Mode         string `json:"mode,omitempty"`
+	Address      string `json:"addr,omitempty"`
+	Terminator   string `json:"terminator,omitempty"`
+	Protocol     string `json:"protocol,omitempty"`
+	LengthPrefix string `json:"length_prefix,omitempty"` // Size and byte order, like "2:be"
+	Telnet       bool   `json:"telnet,omitempty"`        // Telnet commands were removed from received data
+}
+
+// sessionRecorder writes every session event to a capture file.
+// A nil recorder discards all events, so callers don't need to check whether recording is enabled.
+type sessionRecorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+var recorder *sessionRecorder
+
+// openRecorder creates the record file and writes the start event
+func openRecorder(path, mode, address, terminator string) (*sessionRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &sessionRecorder{file: file, encoder: json.NewEncoder(file)}
+	entry := recordEntry{Event: eventStart, Mode: mode, Address: address, Terminator: terminator, Protocol: protocolName(), Telnet: telnetEnabled}
+	if lengthPrefix != nil {
+		entry.LengthPrefix = lengthPrefix.String()
+	}
+	r.write(entry, time.Now())
+	return r, nil
+}
+
+// record writes a connect, disconnect, received, sent or flush event.
+// data is the payload as it was seen on the wire.
+func (r *sessionRecorder) record(event, peer string, data []byte, t time.Time) {
+	if r == nil {
+		return
+	}
+	entry := recordEntry{Event: event, Peer: peer}
+	switch event {
+	case eventReceived, eventFlush:
+		entry.Direction = "in"
+	case eventSent:
+		entry.Direction = "out"
+	}
+	if entry.Direction != "" {
+		entry.Data = data
+		entry.Length = len(data)
+	}
+	r.write(entry, t)
+}
+
+// HandleEvent records an event of the session at the time it happened
+func (r *sessionRecorder) HandleEvent(e tcp.Event) {
+	switch e.Kind {
+	case tcp.Connected:
+		r.record(eventConnect, e.Addr, nil, e.Time)
+	case tcp.Disconnected:
+		r.record(eventDisconnect, e.Addr, nil, e.Time)
+	case tcp.Received:
+		r.record(eventReceived, e.Addr, e.Data, e.Time)
+	case tcp.FlushedPartial:
+		r.record(eventFlush, e.Addr, e.Data, e.Time)
+	case tcp.Sent:
+		r.record(eventSent, e.Addr, e.Data, e.Time)
+	case tcp.Error:
+		r.recordError(e.Addr, e.Err, e.Time)
+	case tcp.Info:
+		if e.Op == opTelnet {
+			r.recordTelnet(e.Addr, e.Text, e.Data, e.Time)
+		}
+	}
+}
+
+// recordError writes an error event
+func (r *sessionRecorder) recordError(peer string, err error, t time.Time) {
+	if r == nil {
+		return
+	}
+	r.write(recordEntry{Event: eventError, Peer: peer, Error: err.Error()}, t)
+}
+
+// recordTelnet writes a received Telnet command and the answers written to the wire
+func (r *sessionRecorder) recordTelnet(peer, command string, answers []byte, t time.Time) {
+	if r == nil {
+		return
+	}
+	entry := recordEntry{Event: eventTelnet, Peer: peer, Command: command}
+	if len(answers) > 0 {
+		entry.Direction = "out"
+		entry.Data = answers
+		entry.Length = len(answers)
+	}
+	r.write(entry, t)
+}
+
+func (r *sessionRecorder) write(entry recordEntry, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	entry.Time = t.Format(time.RFC3339Nano)
+	r.encoder.Encode(entry)
+}
+
+// Close closes the record file
+func (r *sessionRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSessionRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	peer := "127.0.0.1:8080"
+	r, err := openRecorder(path, "client", peer, "LF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 7, 1, 10, 0, 0, 0, time.UTC)
+	r.record(eventConnect, peer, nil, start)
+	r.record(eventSent, peer, []byte("ping\n"), start.Add(time.Second))
+	r.record(eventReceived, peer, []byte("pong\n"), start.Add(1500*time.Millisecond))
+	r.record(eventFlush, peer, []byte("po"), start.Add(2*time.Second))
+	r.recordError(peer, errors.New("connection reset"), start.Add(3*time.Second))
+	r.record(eventDisconnect, peer, nil, start.Add(3*time.Second))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Events after closing are discarded
+	r.record(eventSent, peer, []byte("late\n"), start.Add(4*time.Second))
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var got []recordEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry recordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %d: %v", len(got)+1, err)
+		}
+		if len(got) == 0 {
+			// The start entry is stamped when the file is opened
+			entry.Time = ""
+		}
+		got = append(got, entry)
+	}
+
+	want := []recordEntry{
+		{Event: eventStart, Mode: "client", Address: peer, Terminator: "LF"},
+		{Time: "2025-07-01T10:00:00Z", Event: eventConnect, Peer: peer},
+		{Time: "2025-07-01T10:00:01Z", Event: eventSent, Peer: peer, Direction: "out", Data: []byte("ping\n"), Length: 5},
+		{Time: "2025-07-01T10:00:01.5Z", Event: eventReceived, Peer: peer, Direction: "in", Data: []byte("pong\n"), Length: 5},
+		{Time: "2025-07-01T10:00:02Z", Event: eventFlush, Peer: peer, Direction: "in", Data: []byte("po"), Length: 2},
+		{Time: "2025-07-01T10:00:03Z", Event: eventError, Peer: peer, Error: "connection reset"},
+		{Time: "2025-07-01T10:00:03Z", Event: eventDisconnect, Peer: peer},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recorded\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *sessionRecorder
+	r.record(eventSent, "127.0.0.1:8080", []byte("ping\n"), time.Now())
+	r.recordError("127.0.0.1:8080", errors.New("connection reset"), time.Now())
+	if err := r.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+}
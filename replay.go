@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutat23/coe/framing"
+	"github.com/yutat23/coe/tcp"
+)
+
+// replayEvent is a sent or received frame loaded from a record file
+type replayEvent struct {
+	offset   time.Duration // Time since the first frame of the session
+	outgoing bool
+	telnet   bool // Answers to Telnet commands, written as they are
+	data     []byte
+}
+
+func runReplay() {
+	o, err := parseOptions(modeReplay, os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(textOutput, "Error:", err)
+		os.Exit(1)
+	}
+	separateLogOutput()
+	if o.replayPath == "" || o.role == "" || o.address == "" {
+		fmt.Fprintln(textOutput, "Usage:", usageLine(modeReplay))
+		os.Exit(1)
+	}
+
+	events, terminator, peer, err := loadReplayEvents(o.replayPath, o.role)
+	if err != nil {
+		fmt.Fprintln(textOutput, "Record file error:", err)
+		os.Exit(1)
+	}
+
+	// The record file selects the terminator, and the protocol and length prefix that frame messages
+	o.terminator = terminator
+	if o.terminator == "" {
+		o.terminator = "LF"
+	}
+	if err := o.setup(); err != nil {
+		fmt.Fprintln(textOutput, "Error:", err)
+		os.Exit(1)
+	}
+	terminatorBytes := o.terminatorBytes
+
+	var conn net.Conn
+	address := o.address
+	serverSide = o.role == modeServer
+	if o.role == modeClient {
+		conn, err = net.Dial("tcp", address)
+		if err != nil {
+			fmt.Fprintln(textOutput, "Connection error:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(textOutput, "Connection successful:", address)
+	} else {
+		// Accept a bare port number like server mode
+		if !strings.Contains(address, ":") {
+			address = ":" + address
+		}
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			fmt.Fprintln(textOutput, "Server startup error:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(textOutput, "Waiting for client connection on %s...\n", listener.Addr())
+		conn, err = listener.Accept()
+		listener.Close()
+		if err != nil {
+			fmt.Fprintln(textOutput, "Connection error:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(textOutput, "Client connected: %s\n", conn.RemoteAddr())
+	}
+	defer conn.Close()
+	peerAddr := conn.RemoteAddr().String()
+
+	sinks := newSinks(&consoleSink{lineFormat: lineFormat{terminator: terminatorBytes}, out: os.Stdout})
+
+	// Telnet commands are removed from received data like in the recorded session, and sent
+	// data is escaped. The commands are not answered, as the recorded answers are sent.
+	wire := conn
+	if telnetEnabled {
+		telnet := newTelnetSession(conn, sinks)
+		telnet.silent = true
+		wire = &wireConn{Conn: conn, telnet: telnet}
+	}
+
+	fmt.Fprintf(textOutput, "Replaying: %s (%d frames", o.replayPath, len(events))
+	if peer != "" {
+		fmt.Fprintf(textOutput, ", peer %s", peer)
+	}
+	fmt.Fprintln(textOutput, ")")
+	o.printSettings()
+	if o.speed == 0 {
+		fmt.Fprintln(textOutput, "Speed: as fast as possible")
+	} else {
+		fmt.Fprintf(textOutput, "Speed: %gx\n", o.speed)
+	}
+	fmt.Fprintln(textOutput, "----------------------------------------")
+
+	// Receive frames in the background and compare them in recorded order
+	frames := make(chan []byte, 256)
+	go func() {
+		defer close(frames)
+		framing.ReadFrames(wire, newCodec(terminatorBytes), o.bufferSize, func(frame []byte, partial bool) error {
+			frames <- frame
+			return nil
+		})
+	}()
+
+	sent, matched, mismatches := 0, 0, 0
+	start := time.Now()
+	for _, event := range events {
+		due := start
+		if o.speed > 0 {
+			due = start.Add(time.Duration(float64(event.offset) / o.speed))
+		}
+
+		if event.outgoing {
+			time.Sleep(time.Until(due))
+			w := wire
+			if event.telnet {
+				w = conn
+			}
+			if _, err := w.Write(event.data); err != nil {
+				fmt.Fprintln(textOutput, "Send error:", err)
+				os.Exit(1)
+			}
+			sinks.HandleEvent(tcp.Event{Kind: tcp.Sent, Time: time.Now(), Addr: peerAddr, Data: event.data})
+			sent++
+			continue
+		}
+
+		// Wait for the next received frame
+		wait := time.Until(due)
+		if wait < 0 {
+			wait = 0
+		}
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				printMismatch("Missing", event.data, nil, terminatorBytes)
+				mismatches++
+				continue
+			}
+			sinks.HandleEvent(tcp.Event{Kind: tcp.Received, Time: time.Now(), Addr: peerAddr, Data: frame})
+			if bytes.Equal(frame, event.data) {
+				matched++
+			} else {
+				printMismatch("Mismatch", event.data, frame, terminatorBytes)
+				mismatches++
+			}
+		case <-time.After(wait + o.replayTimeout):
+			printMismatch("Missing", event.data, nil, terminatorBytes)
+			mismatches++
+		}
+	}
+
+	// Frames that were not in the recording
+drain:
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				break drain
+			}
+			sinks.HandleEvent(tcp.Event{Kind: tcp.Received, Time: time.Now(), Addr: peerAddr, Data: frame})
+			printMismatch("Unexpected", nil, frame, terminatorBytes)
+			mismatches++
+		case <-time.After(2 * framing.FlushTimeout):
+			break drain
+		}
+	}
+
+	fmt.Fprintln(textOutput, "----------------------------------------")
+	fmt.Fprintf(textOutput, "Replay completed: %d sent, %d matched, %d mismatches\n", sent, matched, mismatches)
+	if mismatches > 0 {
+		conn.Close()
+		os.Exit(1)
+	}
+}
+
+// loadReplayEvents reads the sent and received frames of one peer from a record file.
+// Server recordings can contain several clients; only the first one is replayed.
+// When role differs from the recorded mode, the frames the recorded peer sent are the
+// outgoing ones, so a client recording can be replayed as the server and vice versa.
+func loadReplayEvents(path, role string) (events []replayEvent, terminator string, peer string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer file.Close()
+
+	var first time.Time
+	swapped := false // The recorded mode is the other side of role
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry recordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, "", "", fmt.Errorf("line %d: %v", line, err)
+		}
+
+		switch entry.Event {
+		case eventStart:
+			terminator = strings.ToUpper(entry.Terminator)
+			switch entry.Mode {
+			case "", role:
+			case modeServer, modeClient:
+				swapped = true
+			default:
+				return nil, "", "", fmt.Errorf("line %d: unknown mode: %s", line, entry.Mode)
+			}
+			if entry.Protocol != "" {
+				p, err := parseProtocol(entry.Protocol)
+				if err != nil {
+					return nil, "", "", fmt.Errorf("line %d: %v", line, err)
+				}
+				activeProtocol = p
+			}
+			if entry.LengthPrefix != "" {
+				prefix, err := framing.ParseLengthPrefix(entry.LengthPrefix)
+				if err != nil {
+					return nil, "", "", fmt.Errorf("line %d: %v", line, err)
+				}
+				lengthPrefix = prefix
+			}
+			if entry.Telnet {
+				telnetEnabled = true
+			}
+			continue
+		case eventSent, eventReceived, eventFlush, eventTelnet:
+		default:
+			continue
+		}
+
+		if peer == "" {
+			peer = entry.Peer
+		} else if entry.Peer != peer {
+			continue
+		}
+
+		ts, err := time.Parse(time.RFC3339Nano, entry.Time)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("line %d: %v", line, err)
+		}
+		outgoing := (entry.Event == eventSent) != swapped
+		if entry.Event == eventTelnet {
+			// Answers to Telnet commands are sent again by the side that recorded them;
+			// the commands themselves were removed from the received data
+			telnetEnabled = true // Recordings without the telnet start field
+			if swapped || len(entry.Data) == 0 {
+				continue
+			}
+			outgoing = true
+		}
+		if first.IsZero() {
+			first = ts
+		}
+		events = append(events, replayEvent{
+			offset:   ts.Sub(first),
+			outgoing: outgoing,
+			telnet:   entry.Event == eventTelnet,
+			data:     entry.Data,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", "", err
+	}
+	if len(events) == 0 {
+		return nil, "", "", fmt.Errorf("no sent or received frames in %s", path)
+	}
+	return events, terminator, peer, nil
+}
+
+// parseSpeed parses a replay speed such as "2x", "0.5" or "max" (0 means no waiting)
+func parseSpeed(value string) (float64, error) {
+	if strings.EqualFold(value, "max") {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(value), "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("speed must be a positive factor like '2x' or 'max'")
+	}
+	return speed, nil
+}
+
+// displayFrame returns a frame as text without its terminator
+func displayFrame(frame []byte, terminatorBytes []byte) string {
+	return strings.TrimSuffix(string(frame), string(terminatorBytes))
+}
+
+// printMismatch reports a difference between a recorded and a received frame
+func printMismatch(kind string, expected, actual []byte, terminatorBytes []byte) {
+	label := kind + ":"
+	if colorEnabled {
+		label = colorRed + label + colorReset
+	}
+	switch {
+	case actual == nil:
+		fmt.Fprintf(textOutput, "%s expected %s (Bytes: %d, HEX: %x), nothing received\n",
+			label, displayFrame(expected, terminatorBytes), len(expected), expected)
+	case expected == nil:
+		fmt.Fprintf(textOutput, "%s %s (Bytes: %d, HEX: %x) is not in the recording\n",
+			label, displayFrame(actual, terminatorBytes), len(actual), actual)
+	default:
+		fmt.Fprintf(textOutput, "%s expected %s (Bytes: %d, HEX: %x), got %s (Bytes: %d, HEX: %x)\n",
+			label, displayFrame(expected, terminatorBytes), len(expected), expected,
+			displayFrame(actual, terminatorBytes), len(actual), actual)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yutat23/coe/tcp"
+)
+
+func TestLoadReplayEventsRole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	record := `{"ts":"2025-07-01T10:00:00Z","event":"start","mode":"server","addr":"[::]:8080","terminator":"LF"}
+{"ts":"2025-07-01T10:00:01Z","event":"connect","peer":"127.0.0.1:50123"}
+{"ts":"2025-07-01T10:00:01Z","event":"received","peer":"127.0.0.1:50123","dir":"in","data":"cGluZwo=","len":5}
+{"ts":"2025-07-01T10:00:02Z","event":"sent","peer":"127.0.0.1:50123","dir":"out","data":"cG9uZwo=","len":5}
+`
+	if err := os.WriteFile(path, []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		role string
+		want []bool // outgoing of "ping" and "pong"
+	}{
+		{"server", []bool{false, true}},
+		{"client", []bool{true, false}},
+	}
+	for _, tt := range tests {
+		events, terminator, peer, err := loadReplayEvents(path, tt.role)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.role, err)
+		}
+		if terminator != "LF" || peer != "127.0.0.1:50123" || len(events) != 2 {
+			t.Fatalf("%s: terminator %q, peer %q, %d events", tt.role, terminator, peer, len(events))
+		}
+		for i, event := range events {
+			if event.outgoing != tt.want[i] {
+				t.Errorf("%s: event %d (%q) outgoing = %t, want %t", tt.role, i, event.data, event.outgoing, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestLoadReplayEventsLengthPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	record := `{"ts":"2025-07-01T10:00:00Z","event":"start","mode":"client","addr":"127.0.0.1:8080","terminator":"LF","length_prefix":"2:le"}
+{"ts":"2025-07-01T10:00:01Z","event":"sent","peer":"127.0.0.1:8080","dir":"out","data":"AgBoaQ==","len":4}
+`
+	if err := os.WriteFile(path, []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { lengthPrefix = nil }()
+
+	if _, _, _, err := loadReplayEvents(path, "client"); err != nil {
+		t.Fatal(err)
+	}
+	if lengthPrefix == nil || lengthPrefix.Size != 2 || !lengthPrefix.LittleEndian {
+		t.Errorf("lengthPrefix = %v, want 2:le", lengthPrefix)
+	}
+}
+
+func TestRecordedEventTimesAndTelnet(t *testing.T) {
+	defer func() { telnetEnabled = false }()
+	telnetEnabled = true
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	r, err := openRecorder(path, modeClient, "127.0.0.1:23", "CR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	telnetEnabled = false
+	peer := "127.0.0.1:23"
+	start := time.Date(2025, 7, 1, 10, 0, 0, 0, time.UTC)
+	r.HandleEvent(tcp.Event{Kind: tcp.Connected, Time: start, Addr: peer})
+	r.HandleEvent(tcp.Event{Kind: tcp.Info, Time: start.Add(10 * time.Millisecond), Addr: peer, Op: opTelnet, Text: "DO TTYPE", Data: []byte{telnetIAC, telnetWILL, telnetTTYPE}})
+	r.HandleEvent(tcp.Event{Kind: tcp.Info, Time: start.Add(20 * time.Millisecond), Addr: peer, Op: opTelnet, Text: "NOP"})
+	r.HandleEvent(tcp.Event{Kind: tcp.Received, Time: start.Add(500 * time.Millisecond), Addr: peer, Data: []byte("login: ")})
+	r.HandleEvent(tcp.Event{Kind: tcp.Sent, Time: start.Add(1500 * time.Millisecond), Addr: peer, Data: []byte("user\r")})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, _, _, err := loadReplayEvents(path, modeClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !telnetEnabled {
+		t.Error("telnetEnabled is false after loading a Telnet recording")
+	}
+	want := []struct {
+		offset   time.Duration
+		outgoing bool
+		telnet   bool
+		data     string
+	}{
+		{0, true, true, "\xff\xfb\x18"},
+		{490 * time.Millisecond, false, false, "login: "},
+		{1490 * time.Millisecond, true, false, "user\r"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.offset != w.offset || e.outgoing != w.outgoing || e.telnet != w.telnet || string(e.data) != w.data {
+			t.Errorf("event %d = %v %t %t %q, want %v %t %t %q", i, e.offset, e.outgoing, e.telnet, e.data, w.offset, w.outgoing, w.telnet, w.data)
+		}
+	}
+
+	// The answers to Telnet commands are not expected from the other side
+	events, _, _, err = loadReplayEvents(path, modeServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("got %d events replaying as the server, want 2", len(events))
+	}
+}
+
+// Replaying a Telnet recording removes the commands from received data without answering them
+func TestReplayTelnetFilter(t *testing.T) {
+	defer func() { telnetEnabled = false }()
+	telnetEnabled = true
+	peer, conn := net.Pipe()
+	defer peer.Close()
+	telnet := newTelnetSession(conn, tcp.Sinks{})
+	telnet.silent = true
+	wire := &wireConn{Conn: conn, telnet: telnet}
+	defer wire.Close()
+
+	go peer.Write([]byte("\xff\xfd\x18login: "))
+	buffer := make([]byte, 64)
+	n, err := wire.Read(buffer)
+	if err != nil || string(buffer[:n]) != "login: " {
+		t.Errorf("Read() = %q, %v, want %q", buffer[:n], err, "login: ")
+	}
+
+	// Nothing but the sent message arrives at the peer
+	go wire.Write([]byte("a\xffb\r"))
+	n, err = peer.Read(buffer)
+	if err != nil || string(buffer[:n]) != "a\xff\xffb\r" {
+		t.Errorf("peer Read() = %q, %v, want the escaped message", buffer[:n], err)
+	}
+}
+
+func TestLoadReplayEventsEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	record := `{"ts":"2025-07-01T10:00:00Z","event":"start","mode":"client","addr":"127.0.0.1:8080","terminator":"LF"}
+`
+	if err := os.WriteFile(path, []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := loadReplayEvents(path, "client"); err == nil {
+		t.Error("loadReplayEvents() succeeded without frames")
+	}
+}
+
+func TestParseSpeed(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"1x", 1, false},
+		{"2X", 2, false},
+		{"0.5", 0.5, false},
+		{"max", 0, false},
+		{"MAX", 0, false},
+		{"0x", 0, true},
+		{"-1x", 0, true},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSpeed(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSpeed(%q) = %v, %v, want %v, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
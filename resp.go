@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const respMaxDepth = 64 // Deeper nesting is treated as invalid
+
+var respProtocol = &protocol{
+	name:     "resp",
+	split:    splitRESP,
+	summary:  summarizeRESP,
+	describe: describeRESP,
+	command:  encodeRESPCommand,
+}
+
+// respValue is a parsed RESP2/RESP3 value
+type respValue struct {
+	kind     byte   // Type byte like '+', '$' or '*'
+	text     string // Value of simple types and strings
+	null     bool
+	elements []respValue // Elements of arrays, sets and pushes; keys and values of maps
+	attrs    *respValue  // Attributes preceding the value (RESP3 '|')
+}
+
+// parseRESP parses one value at the start of data.
+// It returns the value and its length, 0 if more data is needed, or -1 if the data is invalid.
+func parseRESP(data []byte, depth int) (respValue, int) {
+	if len(data) == 0 {
+		return respValue{}, 0
+	}
+	if depth > respMaxDepth {
+		return respValue{}, -1
+	}
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		return respValue{}, 0
+	}
+	if end == 0 {
+		return respValue{}, -1 // Blank line without a type byte
+	}
+	kind := data[0]
+	line := string(data[1:end])
+	pos := end + 2
+	v := respValue{kind: kind}
+
+	switch kind {
+	case '+', '-', ':', ',', '#', '(':
+		v.text = line
+		return v, pos
+	case '_':
+		v.null = true
+		return v, pos
+	case '$', '!', '=':
+		length, err := strconv.Atoi(line)
+		if err != nil || length < -1 {
+			return v, -1
+		}
+		if length == -1 {
+			v.null = true
+			return v, pos
+		}
+		if len(data) < pos+length+2 {
+			return v, 0
+		}
+		if string(data[pos+length:pos+length+2]) != "\r\n" {
+			return v, -1
+		}
+		v.text = string(data[pos : pos+length])
+		return v, pos + length + 2
+	case '*', '~', '>', '%', '|':
+		count, err := strconv.Atoi(line)
+		if err != nil || count < -1 {
+			return v, -1
+		}
+		if count == -1 {
+			v.null = true
+			return v, pos
+		}
+		if kind == '%' || kind == '|' {
+			count *= 2 // Keys and values
+		}
+		for i := 0; i < count; i++ {
+			element, n := parseRESP(data[pos:], depth+1)
+			if n <= 0 {
+				return v, n
+			}
+			v.elements = append(v.elements, element)
+			pos += n
+		}
+		if kind == '|' {
+			// Attributes are followed by the value they describe
+			value, n := parseRESP(data[pos:], depth+1)
+			if n <= 0 {
+				return v, n
+			}
+			attrs := v
+			value.attrs = &attrs
+			return value, pos + n
+		}
+		return v, pos
+	}
+	return v, -1
+}
+
+// splitRESP frames one RESP value, including nested arrays and bulk strings with CRLF inside.
+// Inline commands (lines not starting with a type byte) are framed by LF. An invalid value is
+// passed up to the end of its first line, so the values after it stay in sync.
+func splitRESP(data []byte) int {
+	if len(data) > 0 && !strings.ContainsRune("+-:$*_,#!=(%~>|", rune(data[0])) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return i + 1
+		}
+		return 0
+	}
+	_, n := parseRESP(data, 0)
+	if n < 0 {
+		// parseRESP only reports invalid data after a complete first line
+		return bytes.Index(data, []byte("\r\n")) + 2
+	}
+	return n
+}
+
+// summarizeRESP returns a value on one line. Commands (arrays of bulk strings sent by the client)
+// are shown like "SET k v".
+func summarizeRESP(frame []byte, request bool, conn string) string {
+	v, n := parseRESP(frame, 0)
+	if n != len(frame) {
+		return "" // Inline command or invalid data
+	}
+	if request && v.kind == '*' && !v.null {
+		args := make([]string, 0, len(v.elements))
+		for _, element := range v.elements {
+			if element.kind != '$' || element.null {
+				return respCompact(v)
+			}
+			args = append(args, respQuoteArg(element.text))
+		}
+		return strings.Join(args, " ")
+	}
+	return respCompact(v)
+}
+
+// describeRESP returns replies with elements in the style of redis-cli, one element per line
+func describeRESP(frame []byte, request bool, conn string) string {
+	v, n := parseRESP(frame, 0)
+	if request || n != len(frame) || len(v.elements) == 0 {
+		return ""
+	}
+	return strings.Join(respLines(v), "\n")
+}
+
+// respCompact formats a value on one line, like ["a", 1] or {"k": "v"}
+func respCompact(v respValue) string {
+	var s string
+	switch {
+	case v.null:
+		s = "(nil)"
+	case v.kind == '+':
+		s = visibleText(v.text)
+	case v.kind == '-' || v.kind == '!':
+		s = "(error) " + visibleText(v.text)
+	case v.kind == ':':
+		s = "(integer) " + v.text
+	case v.kind == ',':
+		s = "(double) " + v.text
+	case v.kind == '(':
+		s = "(big number) " + v.text
+	case v.kind == '#':
+		s = "(" + map[string]string{"t": "true", "f": "false"}[v.text] + ")"
+	case v.kind == '$' || v.kind == '=':
+		s = respQuote(v)
+	case v.kind == '%':
+		pairs := make([]string, 0, len(v.elements)/2)
+		for i := 0; i+1 < len(v.elements); i += 2 {
+			pairs = append(pairs, respCompact(v.elements[i])+": "+respCompact(v.elements[i+1]))
+		}
+		s = "{" + strings.Join(pairs, ", ") + "}"
+	default:
+		elements := make([]string, 0, len(v.elements))
+		for _, element := range v.elements {
+			elements = append(elements, respCompact(element))
+		}
+		s = "[" + strings.Join(elements, ", ") + "]"
+		if v.kind == '~' {
+			s = "(set) " + s
+		} else if v.kind == '>' {
+			s = "(push) " + s
+		}
+	}
+	if v.attrs != nil {
+		s = "(attribute) " + respCompact(*v.attrs) + " " + s
+	}
+	return s
+}
+
+// respLines formats a value in the style of redis-cli: numbered elements, nested ones indented
+func respLines(v respValue) []string {
+	if len(v.elements) == 0 {
+		if v.kind == '*' || v.kind == '~' || v.kind == '>' || v.kind == '%' {
+			if !v.null {
+				return []string{"(empty array)"}
+			}
+		}
+		return []string{respCompact(v)}
+	}
+
+	var lines []string
+	if v.kind == '%' {
+		for i := 0; i+1 < len(v.elements); i += 2 {
+			prefix := fmt.Sprintf("%d# %s => ", i/2+1, respCompact(v.elements[i]))
+			lines = append(lines, respPrefixLines(prefix, respLines(v.elements[i+1]))...)
+		}
+		return lines
+	}
+	for i, element := range v.elements {
+		prefix := fmt.Sprintf("%d) ", i+1)
+		lines = append(lines, respPrefixLines(prefix, respLines(element))...)
+	}
+	if v.kind == '>' {
+		lines = append([]string{"(push)"}, lines...)
+	}
+	return lines
+}
+
+// respPrefixLines puts prefix before the first line and indents the others by its width
+func respPrefixLines(prefix string, lines []string) []string {
+	result := make([]string, len(lines))
+	indent := strings.Repeat(" ", len(prefix))
+	for i, line := range lines {
+		if i == 0 {
+			result[i] = prefix + line
+		} else {
+			result[i] = indent + line
+		}
+	}
+	return result
+}
+
+// respQuote formats a bulk or verbatim string in double quotes
+func respQuote(v respValue) string {
+	text := v.text
+	if v.kind == '=' && len(text) >= 4 && text[3] == ':' {
+		text = text[4:] // Verbatim string format like "txt:"
+	}
+	return `"` + strings.ReplaceAll(visibleText(decodeText([]byte(text))), `"`, `\"`) + `"`
+}
+
+// respQuoteArg quotes a command argument when it is empty or contains spaces or quotes
+func respQuoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\r\n\"'") {
+		return visibleText(arg)
+	}
+	return `"` + strings.ReplaceAll(visibleText(arg), `"`, `\"`) + `"`
+}
+
+// encodeRESPCommand encodes an inline command like `SET key "hello world"` as a RESP array of bulk strings
+func encodeRESPCommand(line string) ([]byte, error) {
+	args, err := splitCommandArgs(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	var frame bytes.Buffer
+	fmt.Fprintf(&frame, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&frame, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return frame.Bytes(), nil
+}
+
+// splitCommandArgs splits a command line into arguments like redis-cli.
+// Double-quoted arguments support escape sequences, single-quoted arguments are taken as-is.
+func splitCommandArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+		switch line[i] {
+		case '"':
+			start := i
+			i++
+			for {
+				if i >= len(line) {
+					return nil, &inputError{pos: start + 1, msg: "unterminated double quote"}
+				}
+				if line[i] == '"' {
+					i++
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '"' {
+					arg.WriteByte('"')
+					i += 2
+					continue
+				}
+				if line[i] == '\\' {
+					value, size, err := escapeSequence(line[i:])
+					if err != nil {
+						return nil, &inputError{pos: i + 1, msg: err.Error()}
+					}
+					arg.Write(value)
+					i += size
+					continue
+				}
+				arg.WriteByte(line[i])
+				i++
+			}
+		case '\'':
+			start := i
+			i++
+			for {
+				if i >= len(line) {
+					return nil, &inputError{pos: start + 1, msg: "unterminated single quote"}
+				}
+				if line[i] == '\'' {
+					i++
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					arg.WriteByte('\'')
+					i += 2
+					continue
+				}
+				arg.WriteByte(line[i])
+				i++
+			}
+		default:
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				arg.WriteByte(line[i])
+				i++
+			}
+		}
+		if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			return nil, &inputError{pos: i + 1, msg: "closing quote must be followed by a space"}
+		}
+		args = append(args, arg.String())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitRESP(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"simple string", "+OK\r\n+", 5},
+		{"bulk string with CRLF", "$4\r\na\r\nb\r\n", 10},
+		{"incomplete bulk string", "$4\r\na\r\n", 0},
+		{"nested array", "*2\r\n*1\r\n:1\r\n$1\r\nx\r\n", 19},
+		{"incomplete array", "*2\r\n:1\r\n", 0},
+		{"null", "_\r\n", 3},
+		{"inline command", "PING\r\nPING", 6},
+		{"blank line", "\r\n", 2},
+		{"blank line before array", "\r\n*1\r\n$4\r\nPING\r\n", 2},
+		{"invalid length", "$x\r\n+OK\r\n", 4},
+		{"invalid element", "*2\r\n:1\r\n?\r\n", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitRESP([]byte(tt.data)); got != tt.want {
+				t.Errorf("splitRESP() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRESPCommand(t *testing.T) {
+	frame, err := encodeRESPCommand(`SET key "hello world"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$11\r\nhello world\r\n"
+	if string(frame) != want {
+		t.Errorf("encodeRESPCommand() = %q, want %q", frame, want)
+	}
+	if got := summarizeRESP(frame, true, ""); got != `SET key "hello world"` {
+		t.Errorf("summarizeRESP() = %q", got)
+	}
+	if _, err := encodeRESPCommand(`GET "key`); err == nil {
+		t.Error("encodeRESPCommand() with an unterminated quote succeeded")
+	}
+}
+
+func TestRESPBlankLine(t *testing.T) {
+	for _, frame := range []string{"\r\n", "\r\n*1\r\n$4\r\nPING\r\n"} {
+		if got := summarizeRESP([]byte(frame), false, ""); got != "" {
+			t.Errorf("summarizeRESP(%q) = %q, want \"\"", frame, got)
+		}
+		if got := describeRESP([]byte(frame), false, ""); got != "" {
+			t.Errorf("describeRESP(%q) = %q, want \"\"", frame, got)
+		}
+	}
+}
+
+// Values after invalid data are framed as they are
+func TestSplitRESPAfterInvalid(t *testing.T) {
+	data := []byte("$x\r\n*2\r\n:1\r\n?\r\n+OK\r\n$3\r\nfoo\r\n")
+	var frames []string
+	for len(data) > 0 {
+		n := splitRESP(data)
+		if n <= 0 {
+			t.Fatalf("splitRESP(%q) = %d", data, n)
+		}
+		frames = append(frames, string(data[:n]))
+		data = data[n:]
+	}
+	want := []string{"$x\r\n", "*2\r\n", ":1\r\n", "?\r\n", "+OK\r\n", "$3\r\nfoo\r\n"}
+	if strings.Join(frames, "|") != strings.Join(want, "|") {
+		t.Errorf("frames = %q, want %q", frames, want)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var scpiProtocol = &protocol{
+	name:       "scpi",
+	terminator: "LF",
+	split:      splitSCPI,
+	summary:    summarizeSCPI,
+	query:      isSCPIQuery,
+}
+
+var (
+	scpiBlockDir   string // Directory to save block payloads of --scpi-blocks ("": not saved)
+	scpiBlockCount uint32 // Number of saved blocks, used in the file names
+)
+
+// scpiBlock is an IEEE 488.2 arbitrary block in a message
+type scpiBlock struct {
+	start int // Position of '#'
+	data  int // Position of the payload
+	end   int // Position after the payload
+}
+
+// scpiBlocks finds the blocks of the message at the start of data, like "#42000<2000 bytes>" (definite length)
+// or "#0<bytes until LF>" (indefinite length).
+// It returns the blocks and the length of the message including LF, or 0 if more data is needed.
+func scpiBlocks(data []byte) ([]scpiBlock, int) {
+	var blocks []scpiBlock
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == '\n':
+			return blocks, i + 1
+		case data[i] != '#' || (i > 0 && !strings.ContainsRune(" ,;:", rune(data[i-1]))):
+			// A block starts a message or a data element
+			continue
+		case i+1 >= len(data):
+			return nil, 0
+		case data[i+1] == '0':
+			// Indefinite length, the payload ends with the message
+			end := bytes.IndexByte(data[i+2:], '\n')
+			if end < 0 {
+				return nil, 0
+			}
+			blocks = append(blocks, scpiBlock{start: i, data: i + 2, end: i + 2 + end})
+			return blocks, i + 2 + end + 1
+		case data[i+1] >= '1' && data[i+1] <= '9':
+			digits := int(data[i+1] - '0')
+			if len(data) < i+2+digits {
+				return nil, 0
+			}
+			length, err := strconv.Atoi(string(data[i+2 : i+2+digits]))
+			if err != nil || length < 0 {
+				continue // Not a block, like "#1A"
+			}
+			block := scpiBlock{start: i, data: i + 2 + digits, end: i + 2 + digits + length}
+			if len(data) < block.end {
+				return nil, 0
+			}
+			blocks = append(blocks, block)
+			i = block.end - 1
+		}
+	}
+	return nil, 0
+}
+
+// splitSCPI frames SCPI messages by LF, skipping LF bytes inside blocks of binary data
+func splitSCPI(data []byte) int {
+	_, n := scpiBlocks(data)
+	return n
+}
+
+// summarizeSCPI shows the blocks of a message by their size instead of the binary data.
+// It returns "" for messages without blocks.
+func summarizeSCPI(frame []byte, request bool, conn string) string {
+	blocks, n := scpiBlocks(frame)
+	if len(blocks) == 0 || n != len(frame) {
+		return ""
+	}
+	var summary strings.Builder
+	pos := 0
+	for _, block := range blocks {
+		summary.WriteString(visibleText(decodeText(frame[pos:block.data])))
+		fmt.Fprintf(&summary, "<block: %d bytes>", block.end-block.data)
+		pos = block.end
+	}
+	summary.WriteString(visibleText(decodeText(bytes.TrimSuffix(frame[pos:], []byte("\n")))))
+	return summary.String()
+}
+
+// isSCPIQuery reports whether a command line has a query, like "*IDN?" or "MEAS:VOLT? CH1;*OPC?"
+func isSCPIQuery(line string) bool {
+	for _, command := range strings.Split(line, ";") {
+		if fields := strings.Fields(command); len(fields) > 0 && strings.HasSuffix(fields[0], "?") {
+			return true
+		}
+	}
+	return false
+}
+
+// saveSCPIBlocks writes the block payloads of a received message to files in scpiBlockDir
+// and returns the lines reporting them
+func saveSCPIBlocks(frame []byte) []string {
+	if scpiBlockDir == "" {
+		return nil
+	}
+	blocks, _ := scpiBlocks(frame)
+	var lines []string
+	for _, block := range blocks {
+		count := atomic.AddUint32(&scpiBlockCount, 1)
+		name := fmt.Sprintf("block-%s-%03d.bin", time.Now().Format("20060102-150405"), count)
+		path := filepath.Join(scpiBlockDir, name)
+		if err := os.WriteFile(path, frame[block.data:block.end], 0644); err != nil {
+			lines = append(lines, fmt.Sprintf("Block save error: %v", err))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("Saved block: %s (%d bytes)", path, block.end-block.data))
+	}
+	return lines
+}
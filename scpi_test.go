@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yutat23/coe/framing"
+)
+
+func TestSplitSCPI(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"plain", "*IDN?\nMEAS?", 6},
+		{"incomplete", "*IDN?", 0},
+		{"definite block", "#15ab\ncd\n*OPC\n", 9},
+		{"block after header", "CURV #13a\nb\n", 12},
+		{"block missing data", "#15ab\n", 0},
+		{"block missing length", "#2", 0},
+		{"indefinite block", "#0ab\n", 5},
+		{"not a block", "A#1A\n", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitSCPI([]byte(tt.data)); got != tt.want {
+				t.Errorf("splitSCPI() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// A block sent in pieces slower than FlushTimeout arrives as one message
+func TestSCPISlowBlock(t *testing.T) {
+	pieces := []string{"CURV #2", "10\n\n\n", "\n\n\n\n\n", "\n\n", "\n"}
+
+	client, server := net.Pipe()
+	go func() {
+		for _, piece := range pieces {
+			client.Write([]byte(piece))
+			time.Sleep(2 * framing.FlushTimeout)
+		}
+		client.Close()
+	}()
+
+	var frames []string
+	framing.ReadFrames(server, framing.Custom{Frame: splitSCPI}, 1024, func(data []byte, partial bool) error {
+		if partial {
+			t.Errorf("partial frame %q", data)
+		}
+		frames = append(frames, string(data))
+		return nil
+	})
+	if len(frames) != 1 {
+		t.Fatalf("frames = %q, want one message", frames)
+	}
+	if got, want := summarizeSCPI([]byte(frames[0]), false, ""), "CURV #210<block: 10 bytes>"; got != want {
+		t.Errorf("summarizeSCPI() = %q, want %q", got, want)
+	}
+}
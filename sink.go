@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/yutat23/coe/tcp"
+)
+
+// opQuery is the operation of the Error event reported when a query gets no response
+const opQuery = "query"
+
+// newSinks returns the sinks of a session: the state of the protocol, the console, and the
+// log file and the recorder when they are open
+func newSinks(console *consoleSink) tcp.Sinks {
+	sinks := tcp.Sinks{protocolSink{}, console}
+	if logFile != nil {
+		sinks = append(sinks, &fileSink{lineFormat: console.lineFormat, file: logFile})
+	}
+	if recorder != nil {
+		sinks = append(sinks, recorder)
+	}
+	return sinks
+}
+
+// lineFormat formats events as the lines of the console and the log file
+type lineFormat struct {
+	terminator []byte // Removed from shown messages
+	peerLines  bool   // Lines start with the peer address (server mode) instead of [Recv]/[Send]
+}
+
+// lines returns the colored and plain text line and the structured log fields of an event.
+// ok is false for events that are not shown.
+func (f lineFormat) lines(e tcp.Event) (colored, plain string, fields []logField, ok bool) {
+	var line string
+	switch e.Kind {
+	case tcp.Connected:
+		if f.peerLines {
+			line = fmt.Sprintf("Client connected: %s", e.Addr)
+		} else {
+			line = fmt.Sprintf("Connection successful: %s", e.Addr)
+		}
+		return line, line, eventFields(e.Time, eventConnect, e.Addr, nil), true
+	case tcp.Disconnected:
+		if !f.peerLines {
+			return "", "", nil, false // The client shows the receive error instead
+		}
+		line = fmt.Sprintf("Client disconnected: %s", e.Addr)
+		return line, line, eventFields(e.Time, eventDisconnect, e.Addr, nil), true
+	case tcp.Received, tcp.FlushedPartial:
+		colored, plain, fields = f.received(e)
+		return colored, plain, fields, true
+	case tcp.Sent:
+		colored, plain, fields = f.sent(e)
+		return colored, plain, fields, true
+	case tcp.Error:
+		switch {
+		case e.Op == tcp.OpConnect || e.Op == tcp.OpAccept:
+			line = fmt.Sprintf("Connection error: %v", e.Err)
+		case e.Op == opQuery:
+			line = fmt.Sprintf("Query timeout: %v", e.Err)
+		case e.Op == tcp.OpSend && f.peerLines:
+			line = fmt.Sprintf("[%s] Send error: %v", e.Addr, e.Err)
+		case e.Op == tcp.OpSend:
+			line = fmt.Sprintf("Send error: %v", e.Err)
+		case f.peerLines:
+			line = fmt.Sprintf("[%s] Receive error: %v", e.Addr, e.Err)
+		default:
+			line = fmt.Sprintf("Receive error: %v", e.Err)
+		}
+		return line, line, eventFields(e.Time, eventError, e.Addr, e.Err), true
+	case tcp.Info:
+		if e.Op == opTelnet {
+			colored, plain, fields = telnetLines(e)
+			return colored, plain, fields, true
+		}
+	}
+	return "", "", nil, false
+}
+
+// received formats a received message, shown without terminator
+func (f lineFormat) received(e tcp.Event) (colored, plain string, fields []logField) {
+	now := e.Time
+	timestamp := now.Format("2006-01-02 15:04:05.000")
+	message := bytes.TrimSuffix(e.Data, f.terminator)
+	data := e.Data
+	if f.peerLines {
+		data = message // Server lines show the bytes of the message only
+	}
+	result := checksum.verify(stripLengthPrefix(message))
+	text := decodeText(message)
+	shown := frameText(data, serverSide, e.Addr, visibleText(text))
+	payload := func(colored bool) string {
+		return result.label(colored) + formatPayload(shown, data, colored) + describeFrame(data, serverSide, e.Addr, colored) + decodePayload(data, colored)
+	}
+
+	if f.peerLines {
+		colored = fmt.Sprintf("%s[%s]%s %s%s%s | %sReceived:%s %s",
+			colorBlue, e.Addr, colorReset,
+			colorYellow, timestamp, colorReset,
+			colorGreen, colorReset, payload(true))
+		plain = fmt.Sprintf("[%s] %s | Received: %s", e.Addr, timestamp, payload(false))
+	} else {
+		colored = fmt.Sprintf("%s[Recv]%s %s%s%s | %s",
+			colorGreen, colorReset,
+			colorYellow, timestamp, colorReset,
+			payload(true))
+		plain = fmt.Sprintf("[Recv] %s | %s", timestamp, payload(false))
+	}
+	fields = append(dataFields(now, eventReceived, e.Addr, text, data, e.Kind == tcp.FlushedPartial), result.fields()...)
+	fields = append(fields, decodedFields(data, serverSide, e.Addr)...)
+	fields = append(fields, payloadFields(data)...)
+	return colored, plain, fields
+}
+
+// sent formats a sent message. Typed messages are shown as typed (with escape sequences),
+// answers as sent; control characters are shown visibly.
+func (f lineFormat) sent(e tcp.Event) (colored, plain string, fields []logField) {
+	now := e.Time
+	timestamp := now.Format("2006-01-02 15:04:05.000")
+	text := e.Text
+	if text == "" {
+		text = decodeText(bytes.TrimSuffix(e.Data, f.terminator))
+	}
+	shown := frameText(e.Data, !serverSide, e.Addr, visibleText(text))
+	payload := func(colored bool) string {
+		return formatPayload(shown, e.Data, colored) + describeFrame(e.Data, !serverSide, e.Addr, colored) + decodePayload(e.Data, colored)
+	}
+
+	if f.peerLines {
+		colored = fmt.Sprintf("%s[%s]%s %s%s%s | %sSent:%s %s",
+			colorBlue, e.Addr, colorReset,
+			colorYellow, timestamp, colorReset,
+			colorRed, colorReset, payload(true))
+		plain = fmt.Sprintf("[%s] %s | Sent: %s", e.Addr, timestamp, payload(false))
+	} else {
+		colored = fmt.Sprintf("%s[Send]%s %s%s%s | %s",
+			colorCyan, colorReset,
+			colorYellow, timestamp, colorReset,
+			payload(true))
+		plain = fmt.Sprintf("[Send] %s | %s", timestamp, payload(false))
+	}
+	fields = append(dataFields(now, eventSent, e.Addr, text, e.Data, false), decodedFields(e.Data, !serverSide, e.Addr)...)
+	fields = append(fields, payloadFields(e.Data)...)
+	return colored, plain, fields
+}
+
+// consoleSink prints events in the --log-format
+type consoleSink struct {
+	lineFormat
+	out       io.Writer // Event lines, like os.Stdout
+	prompt    string    // Input prompt redisplayed around received messages ("" in server mode)
+	hideEmpty bool      // Received messages without data besides the terminator are not shown
+
+	mu sync.Mutex // Keeps lines and the prompt together
+}
+
+func (c *consoleSink) HandleEvent(e tcp.Event) {
+	if c.hideEmpty && e.Kind == tcp.Received && len(bytes.TrimSuffix(e.Data, c.terminator)) == 0 {
+		return
+	}
+	colored, plain, fields, ok := c.lines(e)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prompt == "" {
+		c.writeLine(colored, plain, fields)
+		return
+	}
+
+	// Received messages, errors and reports like Telnet negotiation arrive while the prompt is shown
+	async := e.Kind == tcp.Received || e.Kind == tcp.FlushedPartial || e.Kind == tcp.Info || (e.Kind == tcp.Error && e.Op != tcp.OpConnect)
+	if async {
+		clearPromptLine()
+	}
+	c.writeLine(colored, plain, fields)
+	if e.Kind == tcp.Received || e.Kind == tcp.FlushedPartial || e.Kind == tcp.Sent || e.Kind == tcp.Info {
+		printPrompt(inputPrompt(c.prompt))
+	}
+}
+
+func (c *consoleSink) writeLine(colored, plain string, fields []logField) {
+	if structuredLog() {
+		fmt.Fprintln(c.out, formatStructured(logFormat, fields))
+	} else if colorEnabled {
+		fmt.Fprintln(c.out, colored)
+	} else {
+		fmt.Fprintln(c.out, plain)
+	}
+}
+
+// println prints lines between received messages, like saved SCPI blocks
+func (c *consoleSink) println(lines ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clearPromptLine()
+	for _, line := range lines {
+		fmt.Fprintln(textOutput, line)
+	}
+	printPrompt(inputPrompt(c.prompt))
+}
+
+// showPrompt redisplays the input prompt
+func (c *consoleSink) showPrompt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	printPrompt(inputPrompt(c.prompt))
+}
+
+// fileSink writes events to the --log-file in the --log-file-format
+type fileSink struct {
+	lineFormat
+	file *rotatingFile
+}
+
+func (f *fileSink) HandleEvent(e tcp.Event) {
+	if colored, plain, fields, ok := f.lines(e); ok {
+		f.writeLine(colored, plain, fields)
+	}
+}
+
+func (f *fileSink) writeLine(colored, plain string, fields []logField) {
+	if logFileFormat == logFormatText {
+		f.file.WriteLine(plain)
+	} else {
+		f.file.WriteLine(formatStructured(logFileFormat, fields))
+	}
+}
@@ -0,0 +1,104 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+)
+
+// Client connects to a server and receives its messages
+type Client struct {
+	address string
+	config  Config
+	server  *session
+	started bool
+	done    chan struct{}
+}
+
+// NewClient returns a client connecting to address, like "127.0.0.1:8080"
+func NewClient(address string, config Config) *Client {
+	return &Client{address: address, config: config.withDefaults(), done: make(chan struct{})}
+}
+
+// Connect connects to the server. Messages are received after Start.
+// A failure is also reported as Error event.
+func (c *Client) Connect() error {
+	if c.server != nil {
+		return nil
+	}
+	conn, err := net.Dial("tcp", c.address)
+	if err != nil {
+		c.config.emit(Event{Kind: Error, Addr: c.address, Op: OpConnect, Err: err})
+		return err
+	}
+	c.server = newSession(c.config.wrap(conn, true), &c.config)
+	c.config.emit(Event{Kind: Connected, Addr: c.server.addr})
+	return nil
+}
+
+// RemoteAddr returns the address of the server, or "" before Connect
+func (c *Client) RemoteAddr() string {
+	if c.server == nil {
+		return ""
+	}
+	return c.server.addr
+}
+
+// Start receives messages in the background, after connecting if Connect was not called
+func (c *Client) Start() error {
+	if err := c.Connect(); err != nil {
+		return err
+	}
+	if c.started {
+		return nil
+	}
+	c.started = true
+	go func() {
+		defer close(c.done)
+		c.server.receive()
+		c.server.conn.Close()
+		c.config.emit(Event{Kind: Disconnected, Addr: c.server.addr})
+	}()
+	return nil
+}
+
+// Done is closed when the connection has ended and its Disconnected event is handled
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Stop closes the connection and waits until the Disconnected event is handled
+func (c *Client) Stop() error {
+	if c.server == nil {
+		return nil
+	}
+	err := c.server.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	if c.started {
+		<-c.done
+	}
+	return err
+}
+
+// Send frames a message with the codec and writes it to the server.
+// Write errors are also reported as Error events.
+func (c *Client) Send(message []byte) error {
+	return c.SendText(message, "")
+}
+
+// SendText is Send with the text of the Sent event, like the message as typed
+func (c *Client) SendText(message []byte, text string) error {
+	if c.server == nil {
+		return net.ErrClosed
+	}
+	return c.server.send(message, text)
+}
+
+// SendFrame writes a complete frame as it is, without the codec
+func (c *Client) SendFrame(frame []byte, text string) error {
+	if c.server == nil {
+		return net.ErrClosed
+	}
+	return c.server.write(frame, text)
+}
@@ -0,0 +1,80 @@
+// Package tcp runs the two ends of a coe session: a Server accepting clients and a Client
+// connecting to a server. Messages are framed by a framing.Codec, and everything that
+// happens on the connections is passed to a Sink as events.
+package tcp
+
+import "time"
+
+// EventKind is the kind of an Event
+type EventKind int
+
+const (
+	Connected      EventKind = iota // A client connected, or the client connected to the server
+	Disconnected                    // The connection was closed
+	Received                        // A frame was received
+	FlushedPartial                  // Incomplete data was passed on after framing.FlushTimeout
+	Sent                            // A frame was written
+	Error                           // Connecting, accepting, receiving or sending failed
+	Info                            // Something reported by the application about a connection, like Telnet negotiation
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Received:
+		return "received"
+	case FlushedPartial:
+		return "flushed partial"
+	case Sent:
+		return "sent"
+	case Error:
+		return "error"
+	case Info:
+		return "info"
+	}
+	return "unknown"
+}
+
+// Operations failed in Error events
+const (
+	OpConnect = "connect"
+	OpAccept  = "accept"
+	OpReceive = "receive"
+	OpSend    = "send"
+)
+
+// Event is something that happened on a connection
+type Event struct {
+	Kind EventKind
+	Time time.Time // When it happened, before the event is passed to any sink
+	Addr string    // Remote address of the connection ("" for accept errors)
+	Data []byte    // Received, FlushedPartial: the data as received, Sent: the frame as written, Info: the bytes written in answer
+	Text string    // Sent: the text given to SendText or BroadcastText, Info: what was reported
+	Op   string    // Error: OpConnect, OpAccept, OpReceive or OpSend, Info: what reports it
+	Err  error     // Error: what failed
+}
+
+// Sink receives the events of a Server or Client, like a console or a log file.
+// It is called from the goroutines of the connections, so it must be safe for concurrent use.
+type Sink interface {
+	HandleEvent(e Event)
+}
+
+// SinkFunc is a function used as Sink
+type SinkFunc func(e Event)
+
+func (f SinkFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// Sinks passes each event to several sinks, in order
+type Sinks []Sink
+
+func (s Sinks) HandleEvent(e Event) {
+	for _, sink := range s {
+		sink.HandleEvent(e)
+	}
+}
@@ -0,0 +1,177 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+// ErrNoClient is returned by Server.Send for an address that is not connected
+var ErrNoClient = errors.New("client not found")
+
+// Server accepts clients and receives their messages
+type Server struct {
+	address  string
+	config   Config
+	listener net.Listener
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	closed   bool // Set by Stop: connections accepted afterwards are closed
+	wg       sync.WaitGroup
+}
+
+// NewServer returns a server listening on address, like ":8080"
+func NewServer(address string, config Config) *Server {
+	return &Server{address: address, config: config.withDefaults(), sessions: map[string]*session{}}
+}
+
+// Listen opens the listening socket, so Addr is known before clients are accepted
+func (s *Server) Listen() error {
+	if s.listener != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	return nil
+}
+
+// Addr returns the listening address, or nil before Listen
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Start accepts clients in the background, after listening if Listen was not called
+func (s *Server) Start() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return nil
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return // Listener closed by Stop
+		}
+		if err != nil {
+			s.config.emit(Event{Kind: Error, Op: OpAccept, Err: err})
+			continue
+		}
+
+		client := newSession(s.config.wrap(conn, false), &s.config)
+		s.mu.Lock()
+		if s.closed {
+			// Accepted while Stop was closing the connections
+			s.mu.Unlock()
+			client.conn.Close()
+			return
+		}
+		s.sessions[client.addr] = client
+		s.mu.Unlock()
+		s.config.emit(Event{Kind: Connected, Addr: client.addr})
+
+		// Handle each client in separate goroutine
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			client.receive()
+
+			// Remove from client list when disconnected
+			s.mu.Lock()
+			delete(s.sessions, client.addr)
+			s.mu.Unlock()
+			client.conn.Close()
+			s.config.emit(Event{Kind: Disconnected, Addr: client.addr})
+		}()
+	}
+}
+
+// Stop closes the listener and all client connections, and waits until their
+// Disconnected events are handled
+func (s *Server) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	s.mu.Lock()
+	s.closed = true
+	for _, client := range s.sessions {
+		client.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Clients returns the addresses of the connected clients
+func (s *Server) Clients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]string, 0, len(s.sessions))
+	for addr := range s.sessions {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Send frames a message with the codec and writes it to the client at addr.
+// Write errors are also reported as Error events.
+func (s *Server) Send(addr string, message []byte) error {
+	return s.SendText(addr, message, "")
+}
+
+// SendText is Send with the text of the Sent event, like the message as typed
+func (s *Server) SendText(addr string, message []byte, text string) error {
+	s.mu.Lock()
+	client, ok := s.sessions[addr]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoClient, addr)
+	}
+	return client.send(message, text)
+}
+
+// Broadcast frames a message with the codec and writes it to all clients.
+// It returns the number of clients it was written to; write errors are reported as Error events.
+func (s *Server) Broadcast(message []byte) (int, error) {
+	return s.BroadcastText(message, "")
+}
+
+// BroadcastText is Broadcast with the text of the Sent events
+func (s *Server) BroadcastText(message []byte, text string) (int, error) {
+	frame, err := s.config.Codec.Encode(message)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	clients := make([]*session, 0, len(s.sessions))
+	for _, client := range s.sessions {
+		clients = append(clients, client)
+	}
+	s.mu.Unlock()
+
+	count := 0
+	for _, client := range clients {
+		if client.write(frame, text) == nil {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,111 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/yutat23/coe/framing"
+)
+
+// Config is the configuration of a Server or Client
+type Config struct {
+	Codec      framing.Codec // Framing of messages (default: LF terminator)
+	BufferSize int           // Read buffer size in bytes (default: 1024)
+	Sink       Sink          // Receives the events (nil: events are discarded)
+
+	// Respond returns the message answering a received frame, or nil for no answer
+	// (nil: no answers). It is not called for partial frames.
+	Respond func(addr string, frame []byte) []byte
+
+	// WrapConn wraps each new connection, e.g. to filter or capture the data on the wire.
+	// outbound is true for the connection of a Client.
+	WrapConn func(conn net.Conn, outbound bool) net.Conn
+}
+
+func (c Config) withDefaults() Config {
+	if c.Codec == nil {
+		c.Codec = framing.Terminator{'\n'}
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = 1024
+	}
+	return c
+}
+
+func (c *Config) emit(e Event) {
+	e.Time = time.Now()
+	if c.Sink != nil {
+		c.Sink.HandleEvent(e)
+	}
+}
+
+func (c *Config) wrap(conn net.Conn, outbound bool) net.Conn {
+	if c.WrapConn != nil {
+		return c.WrapConn(conn, outbound)
+	}
+	return conn
+}
+
+// session is one connection of a Server or Client
+type session struct {
+	conn   net.Conn
+	addr   string
+	config *Config
+}
+
+func newSession(conn net.Conn, config *Config) *session {
+	return &session{conn: conn, addr: conn.RemoteAddr().String(), config: config}
+}
+
+// send frames a message and writes it
+func (s *session) send(message []byte, text string) error {
+	frame, err := s.config.Codec.Encode(message)
+	if err != nil {
+		return err
+	}
+	return s.write(frame, text)
+}
+
+// write writes a frame and reports it as Sent, or the failure as Error
+func (s *session) write(frame []byte, text string) error {
+	if _, err := s.conn.Write(frame); err != nil {
+		s.config.emit(Event{Kind: Error, Addr: s.addr, Op: OpSend, Err: err})
+		return err
+	}
+	s.config.emit(Event{Kind: Sent, Addr: s.addr, Data: frame, Text: text})
+	return nil
+}
+
+// receive passes received frames to the sink and answers them until the connection fails
+func (s *session) receive() {
+	var sendErr error
+	err := framing.ReadFrames(s.conn, s.config.Codec, s.config.BufferSize, func(frame []byte, partial bool) error {
+		if partial {
+			s.config.emit(Event{Kind: FlushedPartial, Addr: s.addr, Data: frame})
+			return nil
+		}
+		s.config.emit(Event{Kind: Received, Addr: s.addr, Data: frame})
+		if s.config.Respond == nil {
+			return nil
+		}
+		response := s.config.Respond(s.addr, frame)
+		if response == nil {
+			return nil
+		}
+		answer, err := s.config.Codec.Encode(response)
+		if err != nil {
+			s.config.emit(Event{Kind: Error, Addr: s.addr, Op: OpSend, Err: err})
+			return nil
+		}
+		if err := s.write(answer, ""); err != nil {
+			sendErr = err
+			return err
+		}
+		return nil
+	})
+	// Closed by Stop, or the send error was reported already
+	if err != nil && err != sendErr && !errors.Is(err, net.ErrClosed) {
+		s.config.emit(Event{Kind: Error, Addr: s.addr, Op: OpReceive, Err: err})
+	}
+}
@@ -0,0 +1,151 @@
+package tcp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yutat23/coe/framing"
+)
+
+// events returns a config sending the events of a Server or Client to a channel
+func events(codec framing.Codec) (Config, chan Event) {
+	ch := make(chan Event, 64)
+	return Config{Codec: codec, Sink: SinkFunc(func(e Event) { ch <- e })}, ch
+}
+
+// waitEvent returns the next event of a kind, skipping other kinds
+func waitEvent(t *testing.T, ch chan Event, kind EventKind) Event {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-ch:
+			if e.Kind == kind {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", kind)
+		}
+	}
+}
+
+// startPair starts a server on a loopback port and a client connected to it
+func startPair(t *testing.T, serverConfig Config, serverEvents chan Event) (*Server, *Client, chan Event) {
+	t.Helper()
+	server := NewServer("127.0.0.1:0", serverConfig)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	clientConfig, clientEvents := events(serverConfig.Codec)
+	client := NewClient(server.Addr().String(), clientConfig)
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Stop() })
+	waitEvent(t, clientEvents, Connected)
+	waitEvent(t, serverEvents, Connected)
+	return server, client, clientEvents
+}
+
+func TestSendAndRespond(t *testing.T) {
+	config, serverEvents := events(nil)
+	config.Respond = func(addr string, frame []byte) []byte {
+		return append([]byte("re:"), framing.Terminator("\n").Decode(frame)...)
+	}
+	server, client, clientEvents := startPair(t, config, serverEvents)
+
+	if err := client.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitEvent(t, clientEvents, Sent); string(e.Data) != "hello\n" {
+		t.Errorf("client Sent %q, want %q", e.Data, "hello\n")
+	}
+	e := waitEvent(t, serverEvents, Received)
+	if string(e.Data) != "hello\n" || e.Addr != server.Clients()[0] {
+		t.Errorf("server Received %q from %s, want %q from %s", e.Data, e.Addr, "hello\n", server.Clients()[0])
+	}
+	if e := waitEvent(t, serverEvents, Sent); string(e.Data) != "re:hello\n" {
+		t.Errorf("server Sent %q, want the response %q", e.Data, "re:hello\n")
+	}
+	if e := waitEvent(t, clientEvents, Received); string(e.Data) != "re:hello\n" {
+		t.Errorf("client Received %q, want the response %q", e.Data, "re:hello\n")
+	}
+
+	if err := server.SendText(e.Addr, []byte("to client"), "typed"); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitEvent(t, serverEvents, Sent); string(e.Data) != "to client\n" || e.Text != "typed" {
+		t.Errorf("server Sent %q (%q), want %q (%q)", e.Data, e.Text, "to client\n", "typed")
+	}
+	if e := waitEvent(t, clientEvents, Received); string(e.Data) != "to client\n" {
+		t.Errorf("client Received %q, want %q", e.Data, "to client\n")
+	}
+
+	if err := server.Send("127.0.0.1:1", []byte("x")); !errors.Is(err, ErrNoClient) {
+		t.Errorf("Send() to an unknown client = %v, want ErrNoClient", err)
+	}
+}
+
+func TestBroadcastAndStop(t *testing.T) {
+	codec := &framing.LengthPrefix{Size: 2}
+	config, serverEvents := events(codec)
+	server, client, clientEvents := startPair(t, config, serverEvents)
+
+	otherConfig, otherEvents := events(codec)
+	other := NewClient(server.Addr().String(), otherConfig)
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Stop()
+	waitEvent(t, serverEvents, Connected)
+
+	count, err := server.Broadcast([]byte("all"))
+	if err != nil || count != 2 {
+		t.Fatalf("Broadcast() = %d, %v, want 2 clients", count, err)
+	}
+	for _, ch := range []chan Event{clientEvents, otherEvents} {
+		if e := waitEvent(t, ch, Received); string(e.Data) != "\x00\x03all" {
+			t.Errorf("client Received %q, want %q", e.Data, "\x00\x03all")
+		}
+	}
+
+	if err := server.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, serverEvents, Disconnected)
+	waitEvent(t, serverEvents, Disconnected)
+	if clients := server.Clients(); len(clients) != 0 {
+		t.Errorf("Clients() = %v after Stop, want none", clients)
+	}
+	for _, client := range []*Client{client, other} {
+		select {
+		case <-client.Done():
+		case <-time.After(2 * time.Second):
+			t.Error("client connection did not end after the server stopped")
+		}
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	server := NewServer("127.0.0.1:0", Config{})
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	address := server.Addr().String()
+	server.Stop()
+
+	config, ch := events(nil)
+	client := NewClient(address, config)
+	if err := client.Start(); err == nil {
+		t.Fatal("Start() succeeded without a server")
+	}
+	if e := waitEvent(t, ch, Error); e.Op != OpConnect {
+		t.Errorf("Error event Op = %q, want %q", e.Op, OpConnect)
+	}
+	if err := client.Send([]byte("x")); err == nil {
+		t.Error("Send() succeeded without a connection")
+	}
+}
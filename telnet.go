@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutat23/coe/tcp"
+)
+
+// Telnet commands (RFC 854)
+const (
+	telnetSE   = 240
+	telnetNOP  = 241
+	telnetDM   = 242
+	telnetBRK  = 243
+	telnetIP   = 244
+	telnetAO   = 245
+	telnetAYT  = 246
+	telnetEC   = 247
+	telnetEL   = 248
+	telnetGA   = 249
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+// Telnet options with subnegotiations answered by coe
+const (
+	telnetTTYPE = 24 // Terminal type (RFC 1091)
+	telnetNAWS  = 31 // Window size (RFC 1073)
+)
+
+const (
+	telnetTerminalType = "VT100"
+	telnetWidth        = 80
+	telnetHeight       = 24
+	telnetMaxSB        = 1024 // Longer subnegotiations are cut
+)
+
+var telnetCommandNames = map[byte]string{
+	telnetSE:   "SE",
+	telnetNOP:  "NOP",
+	telnetDM:   "DM",
+	telnetBRK:  "BRK",
+	telnetIP:   "IP",
+	telnetAO:   "AO",
+	telnetAYT:  "AYT",
+	telnetEC:   "EC",
+	telnetEL:   "EL",
+	telnetGA:   "GA",
+	telnetSB:   "SB",
+	telnetWILL: "WILL",
+	telnetWONT: "WONT",
+	telnetDO:   "DO",
+	telnetDONT: "DONT",
+}
+
+var telnetOptionNames = map[byte]string{
+	0:  "BINARY",
+	1:  "ECHO",
+	3:  "SGA",
+	5:  "STATUS",
+	6:  "TIMING-MARK",
+	24: "TTYPE",
+	31: "NAWS",
+	32: "TSPEED",
+	33: "LFLOW",
+	34: "LINEMODE",
+	35: "XDISPLOC",
+	36: "ENVIRON",
+	39: "NEW-ENVIRON",
+	42: "CHARSET",
+}
+
+var (
+	telnetEnabled bool                              // Handle Telnet negotiation with --telnet
+	telnetAccept  = map[byte]bool{1: true, 3: true} // Options accepted with --telnet-accept (default: ECHO, SGA)
+)
+
+// parseTelnetAccept parses the value of --telnet-accept: option names or numbers separated
+// by commas (e.g. "echo,sga,naws" or "1,3,31"), "all" or "none"
+func parseTelnetAccept(value string) (map[byte]bool, error) {
+	accept := map[byte]bool{}
+	switch strings.ToLower(value) {
+	case "none":
+		return accept, nil
+	case "all":
+		for option := 0; option < 256; option++ {
+			accept[byte(option)] = true
+		}
+		return accept, nil
+	}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if number, err := strconv.Atoi(name); err == nil && number >= 0 && number < 256 {
+			accept[byte(number)] = true
+			continue
+		}
+		found := false
+		for option, optionName := range telnetOptionNames {
+			if optionName == name {
+				accept[option] = true
+				found = true
+			}
+		}
+		if !found {
+			names := make([]string, 0, len(telnetOptionNames))
+			for _, optionName := range telnetOptionNames {
+				names = append(names, strings.ToLower(optionName))
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("telnet option must be a number, all, none or one of: %s", strings.Join(names, ", "))
+		}
+	}
+	return accept, nil
+}
+
+// telnetAcceptText returns the accepted options for the startup output
+func telnetAcceptText() string {
+	if len(telnetAccept) == 256 {
+		return "all"
+	}
+	if len(telnetAccept) == 0 {
+		return "none"
+	}
+	options := make([]int, 0, len(telnetAccept))
+	for option := range telnetAccept {
+		options = append(options, int(option))
+	}
+	sort.Ints(options)
+	names := make([]string, len(options))
+	for i, option := range options {
+		names[i] = telnetOptionName(byte(option))
+	}
+	return strings.Join(names, ", ")
+}
+
+// telnetOptionName returns the name of an option, or its number
+func telnetOptionName(option byte) string {
+	if name, ok := telnetOptionNames[option]; ok {
+		return name
+	}
+	return strconv.Itoa(int(option))
+}
+
+// telnetEscape doubles IAC bytes in sent data with --telnet
+func telnetEscape(data []byte) []byte {
+	if !telnetEnabled || bytes.IndexByte(data, telnetIAC) < 0 {
+		return data
+	}
+	return bytes.ReplaceAll(data, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC})
+}
+
+// telnetSession strips Telnet commands from the data received on a connection
+// and answers option negotiation by the accept policy
+type telnetSession struct {
+	conn   net.Conn
+	state  int    // Parser state, one of telnetState*
+	verb   byte   // WILL, WONT, DO or DONT waiting for its option
+	sb     []byte // Subnegotiation being received
+	local  map[byte]bool
+	remote map[byte]bool
+	silent bool     // Commands are not answered, like in replay mode sending the recorded answers
+	sink   tcp.Sink // Receives the commands as Info events
+}
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBIAC
+)
+
+func newTelnetSession(conn net.Conn, sink tcp.Sink) *telnetSession {
+	return &telnetSession{conn: conn, local: map[byte]bool{}, remote: map[byte]bool{}, sink: sink}
+}
+
+// filter returns the data without Telnet commands. Commands split across reads are kept
+// until the rest arrives.
+func (t *telnetSession) filter(data []byte) []byte {
+	clean := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch t.state {
+		case telnetStateData:
+			if b == telnetIAC {
+				t.state = telnetStateIAC
+			} else {
+				clean = append(clean, b)
+			}
+		case telnetStateIAC:
+			t.state = telnetStateData
+			switch b {
+			case telnetIAC:
+				clean = append(clean, telnetIAC) // Escaped 0xFF data byte
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				t.verb = b
+				t.state = telnetStateOption
+			case telnetSB:
+				t.sb = t.sb[:0]
+				t.state = telnetStateSB
+			default:
+				t.event(telnetCommand(b), nil)
+			}
+		case telnetStateOption:
+			t.state = telnetStateData
+			t.negotiate(t.verb, b)
+		case telnetStateSB:
+			if b == telnetIAC {
+				t.state = telnetStateSBIAC
+			} else if len(t.sb) < telnetMaxSB {
+				t.sb = append(t.sb, b)
+			}
+		case telnetStateSBIAC:
+			switch b {
+			case telnetSE:
+				t.state = telnetStateData
+				t.subnegotiate(t.sb)
+			case telnetIAC:
+				t.state = telnetStateSB
+				if len(t.sb) < telnetMaxSB {
+					t.sb = append(t.sb, telnetIAC)
+				}
+			default:
+				// Subnegotiation ended without SE, handle the command normally
+				t.state = telnetStateIAC
+				t.subnegotiate(t.sb)
+				clean = append(clean, t.filter([]byte{b})...)
+			}
+		}
+	}
+	return clean
+}
+
+// negotiate answers WILL, WONT, DO and DONT. Answers are only sent when the state of the
+// option changes, so negotiation does not loop (RFC 854).
+func (t *telnetSession) negotiate(verb, option byte) {
+	var replies [][]byte
+	switch verb {
+	case telnetWILL:
+		if !telnetAccept[option] {
+			replies = append(replies, []byte{telnetIAC, telnetDONT, option})
+		} else if !t.remote[option] {
+			t.remote[option] = true
+			replies = append(replies, []byte{telnetIAC, telnetDO, option})
+		}
+	case telnetWONT:
+		if t.remote[option] {
+			t.remote[option] = false
+			replies = append(replies, []byte{telnetIAC, telnetDONT, option})
+		}
+	case telnetDO:
+		if !telnetAccept[option] {
+			replies = append(replies, []byte{telnetIAC, telnetWONT, option})
+		} else if !t.local[option] {
+			t.local[option] = true
+			replies = append(replies, []byte{telnetIAC, telnetWILL, option})
+			if option == telnetNAWS {
+				replies = append(replies, telnetWindowSize())
+			}
+		}
+	case telnetDONT:
+		if t.local[option] {
+			t.local[option] = false
+			replies = append(replies, []byte{telnetIAC, telnetWONT, option})
+		}
+	}
+	t.event(telnetCommand(verb)+" "+telnetOptionName(option), replies)
+}
+
+// subnegotiate answers TTYPE SEND with the terminal type
+func (t *telnetSession) subnegotiate(sb []byte) {
+	if len(sb) == 0 {
+		t.event("SB", nil)
+		return
+	}
+	option := sb[0]
+	text := "SB " + telnetOptionName(option)
+	switch {
+	case option == telnetTTYPE && len(sb) >= 2 && sb[1] == 1:
+		text += " SEND"
+		var replies [][]byte
+		if t.local[telnetTTYPE] {
+			reply := []byte{telnetIAC, telnetSB, telnetTTYPE, 0}
+			reply = append(reply, telnetTerminalType...)
+			replies = append(replies, append(reply, telnetIAC, telnetSE))
+		}
+		t.event(text, replies)
+		return
+	case option == telnetTTYPE && len(sb) >= 2 && sb[1] == 0:
+		text += " IS " + visibleText(string(sb[2:]))
+	case option == telnetNAWS && len(sb) == 5:
+		text += fmt.Sprintf(" %dx%d", int(sb[1])<<8|int(sb[2]), int(sb[3])<<8|int(sb[4]))
+	case len(sb) > 1:
+		text += fmt.Sprintf(" % X", sb[1:])
+	}
+	t.event(text, nil)
+}
+
+// telnetWindowSize returns the NAWS subnegotiation with the window size
+func telnetWindowSize() []byte {
+	return []byte{telnetIAC, telnetSB, telnetNAWS,
+		telnetWidth >> 8, telnetWidth & 0xFF, telnetHeight >> 8, telnetHeight & 0xFF,
+		telnetIAC, telnetSE}
+}
+
+// telnetCommand returns the name of a command
+func telnetCommand(command byte) string {
+	if name, ok := telnetCommandNames[command]; ok {
+		return name
+	}
+	return strconv.Itoa(int(command))
+}
+
+// opTelnet is the operation of the Info events reporting Telnet commands
+const opTelnet = "telnet"
+
+// event sends the replies to a received command and reports both to the sink of the session
+func (t *telnetSession) event(received string, replies [][]byte) {
+	if t.silent {
+		replies = nil
+	}
+	var sent []byte
+	for _, reply := range replies {
+		if _, err := t.conn.Write(reply); err != nil {
+			break
+		}
+		capture.sent(t.conn, reply)
+		sent = append(sent, reply...)
+	}
+	t.sink.HandleEvent(tcp.Event{Kind: tcp.Info, Time: time.Now(), Addr: t.conn.RemoteAddr().String(), Op: opTelnet, Text: received, Data: sent})
+}
+
+// telnetLines formats a Telnet event, like "Telnet: WILL ECHO -> DO ECHO"
+func telnetLines(e tcp.Event) (colored, plain string, fields []logField) {
+	var answers []string
+	for _, reply := range splitTelnetReplies(e.Data) {
+		answers = append(answers, describeTelnetReply(reply))
+	}
+	now := e.Time
+	timestamp := now.Format("2006-01-02 15:04:05.000")
+	line := e.Text
+	if len(answers) > 0 {
+		line += " -> " + strings.Join(answers, ", ")
+	}
+	colored = fmt.Sprintf("%s[%s]%s %s%s%s | %sTelnet:%s %s",
+		colorBlue, e.Addr, colorReset,
+		colorYellow, timestamp, colorReset,
+		colorPurple, colorReset, line)
+	plain = fmt.Sprintf("[%s] %s | Telnet: %s", e.Addr, timestamp, line)
+	fields = []logField{
+		{"ts", now.Format(time.RFC3339Nano)},
+		{"event", "telnet"},
+		{"peer", e.Addr},
+		{"received", e.Text},
+		{"sent", strings.Join(answers, ", ")},
+	}
+	return colored, plain, fields
+}
+
+// splitTelnetReplies splits the replies sent by telnetSession: commands of three bytes
+// and subnegotiations up to IAC SE
+func splitTelnetReplies(data []byte) [][]byte {
+	var replies [][]byte
+	for len(data) > 0 {
+		n := min(3, len(data))
+		if len(data) > 1 && data[1] == telnetSB {
+			n = len(data)
+			if end := bytes.Index(data, []byte{telnetIAC, telnetSE}); end >= 0 {
+				n = end + 2
+			}
+		}
+		replies = append(replies, data[:n])
+		data = data[n:]
+	}
+	return replies
+}
+
+// describeTelnetReply formats a reply sent by telnetSession
+func describeTelnetReply(reply []byte) string {
+	switch {
+	case len(reply) == 3:
+		return telnetCommand(reply[1]) + " " + telnetOptionName(reply[2])
+	case len(reply) > 5 && reply[1] == telnetSB && reply[2] == telnetTTYPE:
+		return "SB TTYPE IS " + string(reply[4:len(reply)-2])
+	case len(reply) == 9 && reply[1] == telnetSB && reply[2] == telnetNAWS:
+		return fmt.Sprintf("SB NAWS %dx%d", telnetWidth, telnetHeight)
+	}
+	return fmt.Sprintf("% X", reply)
+}
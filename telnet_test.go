@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/yutat23/coe/tcp"
+)
+
+func TestTelnetEventsGoToSink(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	go io.Copy(io.Discard, remote)
+
+	var events []tcp.Event
+	sink := tcp.SinkFunc(func(e tcp.Event) { events = append(events, e) })
+	accept := telnetAccept
+	telnetAccept = map[byte]bool{telnetTTYPE: true}
+	defer func() { telnetAccept = accept }()
+
+	session := newTelnetSession(local, sink)
+	data := session.filter([]byte("a\xff\xfd\x18\xff\xfa\x18\x01\xff\xf0\xff\xfb\x01b"))
+	if string(data) != "ab" {
+		t.Errorf("filter() = %q, want %q", data, "ab")
+	}
+
+	want := []string{
+		"DO TTYPE -> WILL TTYPE",
+		"SB TTYPE SEND -> SB TTYPE IS VT100",
+		"WILL ECHO -> DONT ECHO",
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Kind != tcp.Info || e.Op != opTelnet {
+			t.Errorf("event %d: kind %v op %q, want info telnet", i, e.Kind, e.Op)
+		}
+		_, plain, _ := telnetLines(e)
+		if got := plain[len(plain)-len(want[i]):]; got != want[i] {
+			t.Errorf("event %d: line %q, want it to end with %q", i, plain, want[i])
+		}
+	}
+}
+
+func TestParseTelnetAccept(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []byte
+		wantErr bool
+	}{
+		{"echo,sga", []byte{1, 3}, false},
+		{"Naws, 24", []byte{telnetNAWS, 24}, false},
+		{"none", nil, false},
+		{"1,foo", nil, true},
+		{"256", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTelnetAccept(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTelnetAccept(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseTelnetAccept(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+		for _, option := range tt.want {
+			if !got[option] {
+				t.Errorf("parseTelnetAccept(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		}
+	}
+	if all, err := parseTelnetAccept("all"); err != nil || len(all) != 256 {
+		t.Errorf("parseTelnetAccept(all) = %d options, %v, want 256", len(all), err)
+	}
+}
+
+func TestTelnetFilter(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	replies := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(remote)
+		replies <- data
+	}()
+	accept := telnetAccept
+	telnetAccept = map[byte]bool{telnetTTYPE: true}
+	defer func() { telnetAccept = accept }()
+
+	session := newTelnetSession(local, tcp.Sinks{})
+	// A command split across reads is completed by the next one
+	data := session.filter([]byte("a\xff\xff\xff\xfd"))
+	data = append(data, session.filter([]byte("\x18\xff\xfa\x18\x01\xff\xf0\xff\xfb\x01b"))...)
+	if string(data) != "a\xffb" {
+		t.Errorf("filter() = %q, want %q", data, "a\xffb")
+	}
+
+	local.Close()
+	want := "\xff\xfb\x18" + // WILL TTYPE
+		"\xff\xfa\x18\x00VT100\xff\xf0" + // SB TTYPE IS VT100
+		"\xff\xfe\x01" // DONT ECHO
+	if got := string(<-replies); got != want {
+		t.Errorf("replies = %q, want %q", got, want)
+	}
+}